@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/services"
 )
 
 func main() {
@@ -14,5 +16,13 @@ func main() {
 
 	log.Println("Running migrations...")
 	database.RunMigrations("database/migrations")
+
+	log.Println("Encrypting notification secrets...")
+	updated, err := services.EncryptStoredSecrets(context.Background(), database.DB, services.NewSecretBox(cfg))
+	if err != nil {
+		log.Fatalf("Error encrypting notification secrets: %v", err)
+	}
+	log.Printf("🔐 Encrypted secrets in %d environments", updated)
+
 	log.Println("✅ Migrations completed successfully")
 }
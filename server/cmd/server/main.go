@@ -1,21 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
-	"github.com/prabalesh/vigileye/handlers"
 	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/router"
+	"github.com/prabalesh/vigileye/scheduler"
 	"github.com/prabalesh/vigileye/services"
 	"github.com/rs/cors"
 )
 
 func main() {
 	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Connect to Database
 	database.ConnectDB(cfg.DatabaseURL)
@@ -23,7 +28,7 @@ func main() {
 
 	// Initialize Telegram Helper Bot (Non-blocking)
 	if cfg.Env != "test" {
-		helperBot, err := services.NewHelperBotService()
+		helperBot, err := services.NewHelperBotService(database.DB)
 		if err != nil {
 			log.Printf("⚠️  Helper Bot not started: %v", err)
 		} else {
@@ -31,91 +36,35 @@ func main() {
 		}
 	}
 
-	r := mux.NewRouter()
-
-	// Public routes
-	auth := r.PathPrefix("/api/auth").Subrouter()
-	auth.HandleFunc("/register", handlers.Register).Methods("POST")
-	auth.HandleFunc("/login", handlers.Login).Methods("POST")
-
-	// API Key Protected routes (Ingestion)
-	// We define this BEFORE the general /api prefix to ensure correct matching
-	logRouter := r.PathPrefix("/api/log").Subrouter()
-	logRouter.Use(middleware.RateLimitMiddleware)
-	logRouter.Use(middleware.APIKeyMiddleware)
-	logRouter.HandleFunc("", handlers.LogError).Methods("POST")
-
-	// Protected routes (JWT - Dashboard)
-	api := r.PathPrefix("/api").Subrouter()
-	api.Use(middleware.AuthMiddleware(cfg.JWTSecret))
-
-	api.HandleFunc("/auth/me", handlers.Me).Methods("GET")
-
-	api.HandleFunc("/projects", handlers.GetProjects).Methods("GET")
-	api.HandleFunc("/projects", handlers.CreateProject).Methods("POST")
-	api.HandleFunc("/projects/{id:[0-9]+}", handlers.GetProject).Methods("GET")
-
-	// Project Member routes
-	memberRouter := api.PathPrefix("/projects/{id:[0-9]+}/members").Subrouter()
-	memberRouter.HandleFunc("", handlers.GetProjectMembers).Methods("GET")
-	memberRouter.HandleFunc("/check-last-admin/{user_id:[0-9]+}", handlers.CheckLastAdmin).Methods("GET")
-
-	// Admin-only member routes
-	adminMemberRouter := memberRouter.PathPrefix("").Subrouter()
-	adminMemberRouter.Use(middleware.RequireAdmin)
-	adminMemberRouter.HandleFunc("", handlers.InviteMember).Methods("POST")
-	adminMemberRouter.HandleFunc("/{user_id:[0-9]+}", handlers.UpdateMemberRole).Methods("PATCH")
-	adminMemberRouter.HandleFunc("/{user_id:[0-9]+}", handlers.RemoveMember).Methods("DELETE")
-
-	api.HandleFunc("/projects/{id:[0-9]+}/environments", handlers.GetEnvironments).Methods("GET")
-	api.HandleFunc("/projects/{id:[0-9]+}/environments", handlers.CreateEnvironment).Methods("POST")
-	api.HandleFunc("/projects/{id:[0-9]+}/environments/{env_id:[0-9]+}", handlers.GetEnvironment).Methods("GET")
-
-	// Admin-only environment routes
-	adminEnvRouter := api.PathPrefix("/projects/{id:[0-9]+}/environments/{env_id:[0-9]+}").Subrouter()
-	adminEnvRouter.Use(middleware.RequireAdmin)
-	adminEnvRouter.HandleFunc("", handlers.UpdateEnvironment).Methods("PATCH")
-	adminEnvRouter.HandleFunc("", handlers.DeleteEnvironment).Methods("DELETE")
-	adminEnvRouter.HandleFunc("/regenerate-key", handlers.RegenerateEnvironmentKey).Methods("POST")
-
-	api.HandleFunc("/projects/{id:[0-9]+}/error-groups", handlers.GetErrorGroups).Methods("GET")
-	api.HandleFunc("/projects/{id:[0-9]+}/error-groups/{group_id:[0-9]+}", handlers.GetErrorGroupDetail).Methods("GET")
-	api.HandleFunc("/projects/{id:[0-9]+}/error-groups/{group_id:[0-9]+}/occurrences", handlers.GetErrorGroupOccurrences).Methods("GET")
-	api.HandleFunc("/projects/{id:[0-9]+}/error-groups/{group_id:[0-9]+}/resolve", handlers.ResolveErrorGroup).Methods("PATCH")
-	api.HandleFunc("/projects/{id:[0-9]+}/error-groups/{group_id:[0-9]+}/ignore", handlers.IgnoreErrorGroup).Methods("PATCH")
-	api.HandleFunc("/projects/{id:[0-9]+}/error-groups/{group_id:[0-9]+}/reopen", handlers.ReopenErrorGroup).Methods("PATCH")
-
-	api.HandleFunc("/projects/{id:[0-9]+}/errors", handlers.GetErrors).Methods("GET")
-	api.HandleFunc("/projects/{id:[0-9]+}/errors/{error_id:[0-9]+}", handlers.GetErrorDetail).Methods("GET")
-	api.HandleFunc("/projects/{id:[0-9]+}/errors/{error_id:[0-9]+}/resolve", handlers.ResolveError).Methods("PATCH")
-
-	// Notification routes
-	notifHandler := handlers.NewNotificationHandler(database.DB)
-	api.HandleFunc("/projects/{id:[0-9]+}/environments/{env_id:[0-9]+}/notifications/test", notifHandler.TestTelegramNotification).Methods("POST")
-	api.HandleFunc("/projects/{id:[0-9]+}/environments/{env_id:[0-9]+}/notifications/history", notifHandler.GetNotificationHistory).Methods("GET")
+	// Start background scheduler (leader-elected across replicas)
+	if cfg.Env != "test" {
+		go newScheduler(cfg).Start(context.Background())
+	}
+
+	// Keep cached API key lookups in step with changes made on other replicas
+	if cfg.Env != "test" {
+		go middleware.ListenForAPIKeyChanges(context.Background(), cfg.DatabaseURL)
+	}
+
+	r := router.New(cfg)
 
 	// CORS
 	// Dashboard CORS
 	dashboardCors := cors.New(cors.Options{
 		AllowedOrigins:   cfg.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 		Debug:            cfg.Env == "development",
 	})
 
-	// Ingestion CORS (Global)
-	ingestionCors := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"POST", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "X-API-Key"},
-		Debug:          cfg.Env == "development",
-	})
+	// Ingestion CORS depends on the API key's allowed origins, so the key middleware handles it
+	ingestionCors := middleware.IngestionCORS(r)
 
 	// Switch CORS based on path
 	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if strings.HasPrefix(req.URL.Path, "/api/log") {
-			ingestionCors.Handler(r).ServeHTTP(w, req)
+			ingestionCors.ServeHTTP(w, req)
 		} else {
 			dashboardCors.Handler(r).ServeHTTP(w, req)
 		}
@@ -124,3 +73,25 @@ func main() {
 	log.Printf("🚀 Vigileye Server starting on port %s in %s mode...", cfg.Port, cfg.Env)
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, handler))
 }
+
+// newScheduler registers the periodic jobs that run on the leader replica
+func newScheduler(cfg config.Config) *scheduler.Scheduler {
+	sched := scheduler.New(database.DB)
+
+	notifService := services.NewNotificationService(database.DB, cfg.BaseURL, cfg.TelegramHelperBotToken, services.NewSecretBox(cfg))
+	retention := services.NewRetentionService(database.DB, cfg.LogRetentionDays)
+	groups := services.NewErrorGroupService(database.DB)
+	projects := services.NewProjectService(database.DB)
+	logins := services.NewLoginThrottle(database.DB)
+
+	sched.Register(scheduler.Job{Name: "threshold-evaluation", Interval: time.Minute, Run: notifService.EvaluateThresholds})
+	sched.Register(scheduler.Job{Name: "pending-notifications", Interval: time.Minute, Run: notifService.ProcessPendingNotifications})
+	sched.Register(scheduler.Job{Name: "escalations", Interval: time.Minute, Run: notifService.ProcessEscalations})
+	sched.Register(scheduler.Job{Name: "digests", Interval: 5 * time.Minute, Run: notifService.SendDigests})
+	sched.Register(scheduler.Job{Name: "retention", Interval: time.Hour, Run: retention.PurgeExpiredLogs})
+	sched.Register(scheduler.Job{Name: "snooze-expiry", Interval: time.Minute, Run: groups.ExpireSnoozes})
+	sched.Register(scheduler.Job{Name: "project-purge", Interval: time.Hour, Run: projects.PurgeDeletedProjects})
+	sched.Register(scheduler.Job{Name: "login-throttle-prune", Interval: time.Hour, Run: logins.PruneLoginThrottles})
+
+	return sched
+}
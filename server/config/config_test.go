@@ -41,3 +41,49 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("Expected Env test, got %s", cfg.Env)
 	}
 }
+
+func TestSecretKeyIsRequiredInProduction(t *testing.T) {
+	os.Setenv("JWT_SECRET", "jwt-secret")
+	defer os.Unsetenv("JWT_SECRET")
+	os.Unsetenv("SECRET_KEY")
+
+	if cfg := LoadConfig(); cfg.SecretKey == "" || cfg.SecretKey == cfg.JWTSecret || cfg.Validate() != nil {
+		t.Errorf("Expected a separate development secret key, got %q", cfg.SecretKey)
+	}
+
+	os.Setenv("ENV", "production")
+	defer os.Unsetenv("ENV")
+	if err := LoadConfig().Validate(); err == nil {
+		t.Error("Expected production without SECRET_KEY to be rejected")
+	}
+
+	os.Setenv("SECRET_KEY", "jwt-secret")
+	defer os.Unsetenv("SECRET_KEY")
+	if err := LoadConfig().Validate(); err == nil {
+		t.Error("Expected SECRET_KEY equal to JWT_SECRET to be rejected")
+	}
+
+	os.Setenv("SECRET_KEY", "another-secret")
+	if err := LoadConfig().Validate(); err != nil {
+		t.Errorf("Expected a separate SECRET_KEY to be accepted, got %v", err)
+	}
+}
+
+func TestGetEnvInt(t *testing.T) {
+	key := "TEST_ENV_INT"
+
+	if result := getEnvInt(key, 7); result != 7 {
+		t.Errorf("Expected fallback 7, got %d", result)
+	}
+
+	os.Setenv(key, "30")
+	defer os.Unsetenv(key)
+	if result := getEnvInt(key, 7); result != 30 {
+		t.Errorf("Expected 30, got %d", result)
+	}
+
+	os.Setenv(key, "not-a-number")
+	if result := getEnvInt(key, 7); result != 7 {
+		t.Errorf("Expected fallback 7 for invalid value, got %d", result)
+	}
+}
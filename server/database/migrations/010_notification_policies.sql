@@ -0,0 +1,15 @@
+-- Per-group delivery state for debounce, back-off and escalation policies
+ALTER TABLE error_groups
+ADD COLUMN IF NOT EXISTS notification_step INTEGER DEFAULT 0,
+ADD COLUMN IF NOT EXISTS streak_started_at TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS notify_pending_at TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS escalated_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_error_groups_notify_pending
+ON error_groups(notify_pending_at)
+WHERE notify_pending_at IS NOT NULL;
+
+COMMENT ON COLUMN error_groups.notification_step IS 'Notifications sent since the group was last opened, drives exponential back-off';
+COMMENT ON COLUMN error_groups.streak_started_at IS 'Time of the first notification since the group was last opened';
+COMMENT ON COLUMN error_groups.notify_pending_at IS 'Set when a notification was suppressed by back-off or quiet hours and still needs delivery';
+COMMENT ON COLUMN error_groups.escalated_at IS 'Time the escalation channel was notified for the current streak';
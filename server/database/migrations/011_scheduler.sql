@@ -0,0 +1,6 @@
+-- Track periodic digest delivery per environment
+ALTER TABLE environments
+ADD COLUMN IF NOT EXISTS last_digest_at TIMESTAMPTZ;
+
+-- Retention purges by age
+CREATE INDEX IF NOT EXISTS idx_error_logs_created_at_id ON error_logs(created_at, id);
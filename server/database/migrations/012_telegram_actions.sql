@@ -0,0 +1,26 @@
+-- Triage state reachable from Telegram alert buttons
+ALTER TABLE error_groups
+ADD COLUMN IF NOT EXISTS assigned_to INTEGER REFERENCES users(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_error_groups_assigned_to ON error_groups(assigned_to) WHERE assigned_to IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_error_groups_snoozed_until ON error_groups(snoozed_until) WHERE snoozed_until IS NOT NULL;
+
+-- Telegram account <-> Vigileye user mapping
+CREATE TABLE IF NOT EXISTS telegram_user_links (
+    telegram_user_id BIGINT PRIMARY KEY,
+    user_id INTEGER UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    telegram_username VARCHAR(255),
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- One-time codes generated in the dashboard and redeemed through the helper bot
+CREATE TABLE IF NOT EXISTS telegram_link_codes (
+    code VARCHAR(16) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL, -- 'user'
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
@@ -0,0 +1,22 @@
+-- Telegram chats linked to a project through the helper bot
+CREATE TABLE IF NOT EXISTS telegram_chat_links (
+    chat_id BIGINT PRIMARY KEY,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    chat_title VARCHAR(255),
+    linked_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_telegram_chat_links_project_id ON telegram_chat_links(project_id);
+
+-- Environments whose alerts are mirrored to a linked chat by the helper bot
+CREATE TABLE IF NOT EXISTS telegram_chat_subscriptions (
+    chat_id BIGINT REFERENCES telegram_chat_links(chat_id) ON DELETE CASCADE,
+    environment_id INTEGER REFERENCES environments(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (chat_id, environment_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_telegram_chat_subscriptions_env ON telegram_chat_subscriptions(environment_id);
+
+COMMENT ON COLUMN telegram_link_codes.purpose IS '''user'' links a Telegram account, ''chat'' links a chat to project_id';
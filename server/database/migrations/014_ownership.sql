@@ -0,0 +1,12 @@
+-- CODEOWNERS-style ownership file used to auto-assign new error groups
+CREATE TABLE IF NOT EXISTS project_ownership (
+    project_id INTEGER PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+ALTER TABLE error_groups
+ADD COLUMN IF NOT EXISTS assigned_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_error_groups_project_assigned ON error_groups(project_id, assigned_to);
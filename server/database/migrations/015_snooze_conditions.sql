@@ -0,0 +1,8 @@
+-- Count- and user-based snoozes; snoozed_until (012) covers time-based ones
+ALTER TABLE error_groups
+ADD COLUMN IF NOT EXISTS snoozed_at TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS snooze_until_occurrences INTEGER,
+ADD COLUMN IF NOT EXISTS snooze_user_limit INTEGER;
+
+COMMENT ON COLUMN error_groups.snooze_until_occurrences IS 'occurrence_count at which a snoozed group reopens';
+COMMENT ON COLUMN error_groups.snooze_user_limit IS 'distinct affected users since snoozed_at at which a snoozed group reopens';
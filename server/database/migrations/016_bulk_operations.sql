@@ -0,0 +1,15 @@
+-- Record of bulk actions on error groups and their per-group outcome
+CREATE TABLE IF NOT EXISTS bulk_operations (
+    id SERIAL PRIMARY KEY,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(20) NOT NULL,
+    filter JSONB,
+    group_ids INTEGER[] NOT NULL DEFAULT '{}',
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    results JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_bulk_operations_project ON bulk_operations(project_id, created_at DESC);
@@ -0,0 +1,21 @@
+-- Fingerprints of groups merged into another group; LogError routes them to error_group_id
+CREATE TABLE IF NOT EXISTS error_group_fingerprints (
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    fingerprint VARCHAR(64) NOT NULL,
+    error_group_id INTEGER NOT NULL REFERENCES error_groups(id) ON DELETE CASCADE,
+    merged_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    merged_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (project_id, environment_id, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_error_group_fingerprints_group ON error_group_fingerprints(error_group_id);
+
+-- Each log remembers its own fingerprint so a merge can be split back out
+ALTER TABLE error_logs ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64);
+
+UPDATE error_logs el SET fingerprint = eg.fingerprint
+FROM error_groups eg
+WHERE el.error_group_id = eg.id AND el.fingerprint IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_error_logs_group_fingerprint ON error_logs(error_group_id, fingerprint);
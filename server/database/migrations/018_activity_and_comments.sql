@@ -0,0 +1,25 @@
+-- Threaded comments on error groups; mentions holds the user IDs of @mentioned members
+CREATE TABLE IF NOT EXISTS error_group_comments (
+    id SERIAL PRIMARY KEY,
+    error_group_id INTEGER NOT NULL REFERENCES error_groups(id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES error_group_comments(id) ON DELETE CASCADE,
+    author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    mentions INTEGER[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_error_group_comments_group ON error_group_comments(error_group_id);
+
+-- Append-only timeline per group; actor_id is NULL for system events (auto-reopen, notifications)
+CREATE TABLE IF NOT EXISTS error_group_activity (
+    id BIGSERIAL PRIMARY KEY,
+    error_group_id INTEGER NOT NULL REFERENCES error_groups(id) ON DELETE CASCADE,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    type VARCHAR(32) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    comment_id INTEGER REFERENCES error_group_comments(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_error_group_activity_group ON error_group_activity(error_group_id, id);
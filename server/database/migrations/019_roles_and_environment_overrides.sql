@@ -0,0 +1,24 @@
+-- Fine-grained project roles; 'member' becomes 'developer', which keeps the same rights
+ALTER TABLE project_members DROP CONSTRAINT IF EXISTS project_members_role_check;
+
+UPDATE project_members SET role = 'developer' WHERE role = 'member';
+
+-- Every project owner gets an explicit 'owner' membership
+INSERT INTO project_members (project_id, user_id, role)
+SELECT id, owner_id, 'owner' FROM projects WHERE owner_id IS NOT NULL
+ON CONFLICT (project_id, user_id) DO UPDATE SET role = 'owner';
+
+ALTER TABLE project_members ALTER COLUMN role SET DEFAULT 'developer';
+ALTER TABLE project_members ADD CONSTRAINT project_members_role_check
+    CHECK (role IN ('owner', 'admin', 'developer', 'viewer', 'notifier'));
+
+-- Per-environment role overrides; 'none' hides the environment from the member
+CREATE TABLE IF NOT EXISTS project_member_environment_roles (
+    project_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'developer', 'viewer', 'notifier', 'none')),
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (project_id, user_id, environment_id),
+    FOREIGN KEY (project_id, user_id) REFERENCES project_members(project_id, user_id) ON DELETE CASCADE
+);
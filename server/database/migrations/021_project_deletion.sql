@@ -0,0 +1,5 @@
+-- Soft delete: a deleted project is hidden and stops ingesting, then purged after purge_after
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE projects ADD COLUMN IF NOT EXISTS purge_after TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_projects_purge_after ON projects (purge_after) WHERE deleted_at IS NOT NULL;
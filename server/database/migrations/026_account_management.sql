@@ -0,0 +1,26 @@
+-- Email verification and password reset. Tokens are random, single use and stored hashed; a
+-- verification token only counts for the address it was sent to.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('verify_email', 'password_reset')),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user ON account_tokens (user_id, purpose);
+
+-- Deleting an account must not cascade into projects (their errors are purged in batches) or be
+-- blocked by the groups its user resolved
+ALTER TABLE projects DROP CONSTRAINT IF EXISTS projects_owner_id_fkey;
+ALTER TABLE projects ADD CONSTRAINT projects_owner_id_fkey
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE error_groups DROP CONSTRAINT IF EXISTS error_groups_resolved_by_fkey;
+ALTER TABLE error_groups ADD CONSTRAINT error_groups_resolved_by_fkey
+    FOREIGN KEY (resolved_by) REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,24 @@
+-- Who changed what in a project. changes holds the before/after of changed fields and request
+-- the request body, both with secrets redacted. actor_email is kept for when the user is gone.
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    actor_email VARCHAR(255) NOT NULL DEFAULT '',
+    token_id INTEGER REFERENCES personal_access_tokens(id) ON DELETE SET NULL,
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL,
+    target_id VARCHAR(64) NOT NULL DEFAULT '',
+    changes JSONB NOT NULL DEFAULT '{}',
+    request JSONB,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_project ON audit_logs (project_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_project_action ON audit_logs (project_id, action, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_project_actor ON audit_logs (project_id, actor_id, id DESC);
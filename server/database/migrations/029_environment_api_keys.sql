@@ -0,0 +1,29 @@
+-- Environments can have several ingestion keys, so one can be rotated with an overlap or a leaked
+-- one revoked without breaking every other client. Keys are shown in the dashboard like
+-- environments.api_key was, so they are stored as they are.
+CREATE TABLE IF NOT EXISTS environment_api_keys (
+    id SERIAL PRIMARY KEY,
+    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    api_key VARCHAR(100) NOT NULL UNIQUE DEFAULT gen_random_uuid()::text,
+    allowed_origins TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_environment_api_keys_environment ON environment_api_keys (environment_id);
+
+-- Existing keys keep working as each environment's "Default" key
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='environments' AND column_name='api_key') THEN
+        INSERT INTO environment_api_keys (environment_id, name, api_key, created_at)
+        SELECT id, 'Default', api_key::text, COALESCE(created_at, NOW()) FROM environments
+        ON CONFLICT (api_key) DO NOTHING;
+
+        ALTER TABLE environments DROP COLUMN api_key;
+    END IF;
+END $$;
@@ -0,0 +1,7 @@
+-- Public keys are meant to be embedded in browser bundles and only work from allowed origins;
+-- secret keys are for servers. Existing keys were used both ways, so they stay secret.
+ALTER TABLE environment_api_keys
+ADD COLUMN IF NOT EXISTS kind VARCHAR(10) NOT NULL DEFAULT 'secret' CHECK (kind IN ('public', 'secret'));
+
+-- Origins allowed for every key of the environment that doesn't list its own
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS allowed_origins TEXT[] NOT NULL DEFAULT '{}';
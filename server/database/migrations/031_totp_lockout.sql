@@ -0,0 +1,4 @@
+-- Wrong second-step codes lock the second step like login_throttles lock password sign-in, instead
+-- of being forgiven by the next password login
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_failure_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_locked_until TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+-- Tabs and parallel requests can refresh with the same token at once. A rotated token presented
+-- again shortly after its rotation is such a race rather than a copy, and doesn't revoke the session.
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
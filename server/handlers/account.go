@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+// reauthWindow is how recent a sign-in must be to stand in for a password confirmation
+const reauthWindow = 5 * time.Minute
+
+// validNewPassword checks password against the configured policy. It writes the error response
+// and returns false if the password can't be used.
+func validNewPassword(w http.ResponseWriter, cfg config.Config, password, email string) bool {
+	policy := utils.PasswordPolicy{MinLength: cfg.PasswordMinLength, MinClasses: cfg.PasswordMinClasses}
+	if err := policy.Validate(password, email); err != nil {
+		msg := err.Error()
+		http.Error(w, strings.ToUpper(msg[:1])+msg[1:], http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeAccountError(w http.ResponseWriter, handler string, err error) {
+	switch err {
+	case services.ErrInvalidAccountToken:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrEmailVerified:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrWrongPassword:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("[%s] Error: %v", handler, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// ResendVerification emails a new verification link to the current user
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if err := services.NewAccountService(database.DB, config.LoadConfig()).SendVerification(userID); err != nil {
+		writeAccountError(w, "ResendVerification", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyEmail redeems the link from a verification email
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.NewAccountService(database.DB, config.LoadConfig()).VerifyEmail(input.Token); err != nil {
+		writeAccountError(w, "VerifyEmail", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword emails a reset link. It always answers 202 so it doesn't reveal which
+// addresses have accounts.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	cfg := config.LoadConfig()
+	if cfg.OIDCRequired {
+		http.Error(w, "Password sign-in is disabled; use single sign-on", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.NewAccountService(database.DB, cfg).RequestPasswordReset(input.Email); err != nil {
+		log.Printf("[ForgotPassword] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password from a reset link and ends all of the user's sessions
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	cfg := config.LoadConfig()
+	if cfg.OIDCRequired {
+		http.Error(w, "Password sign-in is disabled; use single sign-on", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if !validNewPassword(w, cfg, input.Password, "") {
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(input.Password)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := services.NewAccountService(database.DB, cfg).ResetPassword(input.Token, hashedPassword)
+	if err != nil {
+		writeAccountError(w, "ResetPassword", err)
+		return
+	}
+	recordSecurityEvent(r, userID, services.SecurityPasswordReset, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword replaces the current user's password and signs out their other sessions
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	cfg := config.LoadConfig()
+	var email string
+	if err := database.DB.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+		log.Printf("[ChangePassword] Query error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !validNewPassword(w, cfg, input.NewPassword, email) {
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(input.NewPassword)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	err = services.NewAccountService(database.DB, cfg).ChangePassword(userID, sessionID, input.CurrentPassword, hashedPassword)
+	if err != nil {
+		writeAccountError(w, "ChangePassword", err)
+		return
+	}
+	recordSecurityEvent(r, userID, services.SecurityPasswordChanged, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateProfile changes the current user's display name
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > 255 {
+		http.Error(w, "Name is required (max 255 characters)", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := database.DB.QueryRow(`
+		UPDATE users SET name = $1 WHERE id = $2
+		RETURNING id, email, name, email_verified_at IS NOT NULL, created_at
+	`, input.Name, userID).Scan(&user.ID, &user.Email, &user.Name, &user.EmailVerified, &user.CreatedAt)
+	if err != nil {
+		log.Printf("[UpdateProfile] Update error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// reauthenticatePasswordless confirms a sensitive action for an account without a password: with
+// a two-factor or recovery code when 2FA is enabled, otherwise by a sign-in within the last few
+// minutes. It writes the error response and returns false on failure.
+func reauthenticatePasswordless(w http.ResponseWriter, r *http.Request, cfg config.Config, userID int, code string) bool {
+	twoFactor := services.NewTwoFactorService(database.DB, cfg)
+	enabled, err := twoFactor.Enabled(userID)
+	if err != nil {
+		log.Printf("[reauthenticatePasswordless] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+
+	if enabled {
+		err := twoFactor.Verify(userID, code)
+		switch err {
+		case nil:
+			return true
+		// Not a 401, which would end the dashboard session
+		case services.ErrInvalidTOTPCode:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case services.ErrTooManyTOTPFailures:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			log.Printf("[reauthenticatePasswordless] Error: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return false
+	}
+
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+	recent, err := services.NewSessionService(database.DB, cfg).SignedInWithin(userID, sessionID, reauthWindow)
+	if err != nil {
+		log.Printf("[reauthenticatePasswordless] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if !recent {
+		http.Error(w, "Sign in again to confirm this action", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// DeleteAccount deletes the current user. Projects they own alone are purged with the account;
+// shared projects have to be transferred or deleted first. Passwordless accounts have to
+// re-authenticate first.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	accounts := services.NewAccountService(database.DB, cfg)
+	hasPassword, err := accounts.HasPassword(userID)
+	if err != nil {
+		writeAccountError(w, "DeleteAccount", err)
+		return
+	}
+	if !hasPassword && !reauthenticatePasswordless(w, r, cfg, userID, input.Code) {
+		return
+	}
+
+	err = accounts.DeleteAccount(userID, input.Password)
+	if err == services.ErrOwnsSharedProjects {
+		names, _ := accounts.SharedOwnedProjects(userID)
+		http.Error(w, "Transfer or delete the projects you share with others first: "+strings.Join(names, ", "), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeAccountError(w, "DeleteAccount", err)
+		return
+	}
+
+	// Projects they owned alone were deleted with the account
+	middleware.InvalidateAPIKeys()
+	clearSessionCookies(w, cfg)
+	w.WriteHeader(http.StatusNoContent)
+}
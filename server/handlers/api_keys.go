@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// New environments, and environments whose keys have all been revoked, get a key of this name
+const defaultKeyName = "Default"
+
+func writeEnvironmentKeyError(w http.ResponseWriter, handler string, err error) {
+	switch err {
+	case services.ErrInvalidKeyInput, services.ErrInvalidOverlap, services.ErrPublicKeyOrigin:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrKeyNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("[%s] Error: %v", handler, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// decodeKeyOverlap reads the optional {"overlap_hours": n} body of a rotation
+func decodeKeyOverlap(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	var input struct {
+		OverlapHours int `json:"overlap_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return 0, false
+	}
+	return time.Duration(input.OverlapHours) * time.Hour, true
+}
+
+// auditedKey is the part of a key that goes in the audit log
+func auditedKey(k *services.EnvironmentKey) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            k.Name,
+		"kind":            k.Kind,
+		"allowed_origins": k.AllowedOrigins,
+		"expires_at":      k.ExpiresAt,
+		"api_key":         k.APIKey,
+	}
+}
+
+func environmentKeyVars(r *http.Request) (projectID, envID, keyID int) {
+	vars := mux.Vars(r)
+	projectID, _ = strconv.Atoi(vars["id"])
+	envID, _ = strconv.Atoi(vars["env_id"])
+	keyID, _ = strconv.Atoi(vars["key_id"])
+	return projectID, envID, keyID
+}
+
+// GetEnvironmentKeys lists an environment's API keys that haven't been revoked. Secret keys are
+// masked for members who can't manage environments.
+func GetEnvironmentKeys(w http.ResponseWriter, r *http.Request) {
+	projectID, envID, _ := environmentKeyVars(r)
+
+	keys, err := services.NewEnvironmentKeyService(database.DB).List(projectID, envID)
+	if err != nil {
+		writeEnvironmentKeyError(w, "GetEnvironmentKeys", err)
+		return
+	}
+	if !middleware.Access(r).CanIn(envID, models.PermEnvironmentsManage) {
+		for i := range keys {
+			keys[i].Mask()
+		}
+	}
+
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateEnvironmentKey adds a secret or public API key, optionally limited to some origins or
+// expiring
+func CreateEnvironmentKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, envID, _ := environmentKeyVars(r)
+
+	var input services.EnvironmentKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	key, err := services.NewEnvironmentKeyService(database.DB).Create(projectID, envID, userID, input)
+	if err != nil {
+		writeEnvironmentKeyError(w, "CreateEnvironmentKey", err)
+		return
+	}
+	// Like every other key change, so no replica answers from a stale view of the keys
+	middleware.InvalidateAPIKeys()
+	middleware.AuditTarget(r, "api_key", strconv.Itoa(key.ID))
+	middleware.AuditChange(r, nil, auditedKey(key))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// UpdateEnvironmentKey replaces a key's name, allowed origins and expiry
+func UpdateEnvironmentKey(w http.ResponseWriter, r *http.Request) {
+	projectID, envID, keyID := environmentKeyVars(r)
+
+	var input services.EnvironmentKeyInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	keys := services.NewEnvironmentKeyService(database.DB)
+	before, err := keys.Get(projectID, envID, keyID)
+	if err != nil {
+		writeEnvironmentKeyError(w, "UpdateEnvironmentKey", err)
+		return
+	}
+	key, err := keys.Update(projectID, envID, keyID, input)
+	if err != nil {
+		writeEnvironmentKeyError(w, "UpdateEnvironmentKey", err)
+		return
+	}
+	middleware.InvalidateAPIKeys()
+	middleware.AuditChange(r, auditedKey(before), auditedKey(key))
+
+	json.NewEncoder(w).Encode(key)
+}
+
+// RotateEnvironmentKey replaces a key with a new one of the same name and origins. With
+// {"overlap_hours": n} the old key keeps working for n hours; otherwise it is revoked at once.
+func RotateEnvironmentKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, envID, keyID := environmentKeyVars(r)
+
+	overlap, ok := decodeKeyOverlap(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := services.NewEnvironmentKeyService(database.DB).Rotate(projectID, envID, keyID, userID, overlap)
+	if err != nil {
+		writeEnvironmentKeyError(w, "RotateEnvironmentKey", err)
+		return
+	}
+	middleware.InvalidateAPIKeys()
+	middleware.AuditChange(r, nil, map[string]interface{}{
+		"replaced_by":   key.ID,
+		"overlap_hours": int(overlap.Hours()),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// RevokeEnvironmentKey stops a key from working straight away
+func RevokeEnvironmentKey(w http.ResponseWriter, r *http.Request) {
+	projectID, envID, keyID := environmentKeyVars(r)
+
+	key, err := services.NewEnvironmentKeyService(database.DB).Revoke(projectID, envID, keyID)
+	if err != nil {
+		writeEnvironmentKeyError(w, "RevokeEnvironmentKey", err)
+		return
+	}
+	middleware.InvalidateAPIKeys()
+	middleware.AuditChange(r, auditedKey(key), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
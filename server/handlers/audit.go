@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// Exports stop after this many entries; narrow the date range to get older ones
+const maxAuditExport = 100000
+
+// parseAuditFilter reads the audit log filters from the query string
+func parseAuditFilter(r *http.Request) (services.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+	if v := query.Get("actor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid actor_id")
+		}
+		filter.ActorID = id
+	}
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: use RFC 3339, e.g. 2024-01-02T15:04:05Z", name)
+			}
+			*dst = t
+		}
+	}
+	return filter, nil
+}
+
+// GetAuditLog returns a page of the project's audit log, newest first
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	logs, total, err := services.NewAuditLogService(database.DB).List(projectID, filter, limit, offset)
+	if err != nil {
+		log.Printf("[GetAuditLog] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": logs,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// ExportAuditLog downloads the filtered audit log as CSV or JSON (?format=csv|json)
+func ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	filename := fmt.Sprintf("audit-log-project-%d-%s.%s", projectID, time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	audit := services.NewAuditLogService(database.DB)
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		err = exportAuditJSON(w, audit, projectID, filter)
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+		err = exportAuditCSV(w, audit, projectID, filter)
+	}
+	// The response has already started, so all that's left is to log it
+	if err != nil {
+		log.Printf("[ExportAuditLog] Error: %v", err)
+	}
+}
+
+func exportAuditJSON(w http.ResponseWriter, audit *services.AuditLogService, projectID int, filter services.AuditFilter) error {
+	enc := json.NewEncoder(w)
+	first := true
+	w.Write([]byte("["))
+	err := audit.Each(projectID, filter, maxAuditExport, func(l *services.AuditLog) error {
+		if !first {
+			w.Write([]byte(","))
+		}
+		first = false
+		return enc.Encode(l)
+	})
+	w.Write([]byte("]\n"))
+	return err
+}
+
+func exportAuditCSV(w http.ResponseWriter, audit *services.AuditLogService, projectID int, filter services.AuditFilter) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"id", "created_at", "actor_id", "actor_email", "token_id", "action", "target_type", "target_id",
+		"changes", "request", "method", "path", "status", "ip_address", "user_agent",
+	})
+	err := audit.Each(projectID, filter, maxAuditExport, func(l *services.AuditLog) error {
+		actorID, tokenID := "", ""
+		if l.ActorID != nil {
+			actorID = strconv.Itoa(*l.ActorID)
+		}
+		if l.TokenID != nil {
+			tokenID = strconv.Itoa(*l.TokenID)
+		}
+		return cw.Write([]string{
+			strconv.FormatInt(l.ID, 10), l.CreatedAt.UTC().Format(time.RFC3339), actorID,
+			csvSafe(l.ActorEmail), tokenID, l.Action, l.TargetType, csvSafe(l.TargetID),
+			csvSafe(string(l.Changes)), csvSafe(string(l.Request)), l.Method, csvSafe(l.Path),
+			strconv.Itoa(l.Status), l.IPAddress, csvSafe(l.UserAgent),
+		})
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// csvSafe stops spreadsheet apps from running user-controlled values as formulas
+func csvSafe(s string) string {
+	if s != "" && (s[0] == '=' || s[0] == '+' || s[0] == '-' || s[0] == '@' || s[0] == '\t' || s[0] == '\r') {
+		return "'" + s
+	}
+	return s
+}
@@ -1,22 +1,36 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
 	"github.com/prabalesh/vigileye/middleware"
 	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
 	"github.com/prabalesh/vigileye/utils"
 )
 
 func Register(w http.ResponseWriter, r *http.Request) {
+	if config.LoadConfig().OIDCRequired {
+		http.Error(w, "Password sign-in is disabled; use single sign-on", http.StatusForbidden)
+		return
+	}
+
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 		Name     string `json:"name"`
+		// InviteToken accepts a project invitation as part of signing up
+		InviteToken string `json:"invite_token"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -31,9 +45,8 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate password
-	if len(input.Password) < 8 {
-		http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+	cfg := config.LoadConfig()
+	if !validNewPassword(w, cfg, input.Password, input.Email) {
 		return
 	}
 
@@ -43,8 +56,15 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	var user models.User
-	err = database.DB.QueryRow(
+	err = tx.QueryRow(
 		"INSERT INTO users (email, password_hash, name) VALUES ($1, $2, $3) RETURNING id, email, name, created_at",
 		input.Email, hashedPassword, input.Name,
 	).Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
@@ -54,29 +74,53 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := config.LoadConfig()
-	token, err := utils.GenerateJWT(user.ID, cfg.JWTSecret)
-	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+	var invitation *services.Invitation
+	if input.InviteToken != "" {
+		// The account is only created if the invitation can be accepted
+		invitation, err = services.NewInvitationService(database.DB, cfg).AcceptTx(tx, input.InviteToken, user.ID)
+		if err != nil {
+			writeInvitationError(w, "Register", err)
+			return
+		}
+		// The invitation link was mailed to this address, so it is already verified
+		if _, err := tx.Exec("UPDATE users SET email_verified_at = NOW() WHERE id = $1", user.ID); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		user.EmailVerified = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "vigileye_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   cfg.Env == "production",
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600 * 24 * 7, // 7 days
-	})
+	if !user.EmailVerified {
+		if err := services.NewAccountService(database.DB, cfg).SendVerification(user.ID); err != nil {
+			log.Printf("[Register] Error sending verification email: %v", err)
+		}
+	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	if !startSession(w, r, cfg, user.ID) {
+		return
+	}
+
+	response := map[string]interface{}{
 		"user": user,
-	})
+	}
+	if invitation != nil {
+		response["project_id"] = invitation.ProjectID
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
+	cfg := config.LoadConfig()
+	if cfg.OIDCRequired {
+		http.Error(w, "Password sign-in is disabled; use single sign-on", http.StatusForbidden)
+		return
+	}
+
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -87,52 +131,105 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := middleware.ClientIP(r)
+	throttle := services.NewLoginThrottle(database.DB)
+	retryAfter, err := throttle.RetryAfter(input.Email, ip)
+	if err != nil {
+		log.Printf("[Login] Error checking lockout: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		writeLockedOut(w, retryAfter)
+		return
+	}
+
 	var user models.User
-	err := database.DB.QueryRow(
-		"SELECT id, email, password_hash, name, created_at FROM users WHERE email = $1",
+	err = database.DB.QueryRow(
+		"SELECT id, email, password_hash, name, email_verified_at IS NOT NULL, created_at FROM users WHERE email = $1",
 		input.Email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.CreatedAt)
-
-	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Name, &user.EmailVerified, &user.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[Login] Query error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	if !utils.CheckPasswordHash(input.Password, user.PasswordHash) {
+	// Unknown emails and SSO-only accounts cost a bcrypt comparison too, so timing doesn't tell
+	// them apart from a wrong password
+	valid := false
+	if err == nil && user.PasswordHash != "" {
+		valid = utils.CheckPasswordHash(input.Password, user.PasswordHash)
+	} else {
+		utils.DummyPasswordCheck(input.Password)
+	}
+
+	if !valid {
+		lockout, err := throttle.RecordFailure(input.Email, ip)
+		if err != nil {
+			log.Printf("[Login] Error recording failure: %v", err)
+		}
+		if user.ID != 0 {
+			recordSecurityEvent(r, user.ID, services.SecurityLoginFailed, nil)
+			if lockout > 0 {
+				recordSecurityEvent(r, user.ID, services.SecurityLoginLocked, map[string]interface{}{
+					"seconds": int(lockout.Seconds()),
+				})
+			}
+		}
+		if lockout > 0 {
+			writeLockedOut(w, lockout)
+			return
+		}
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	cfg := config.LoadConfig()
-	token, err := utils.GenerateJWT(user.ID, cfg.JWTSecret)
+	// With 2FA on, the password only earns a short-lived token for the second step, and failures
+	// are only cleared once that succeeds
+	twoFactor := services.NewTwoFactorService(database.DB, cfg)
+	enabled, err := twoFactor.Enabled(user.ID)
 	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		log.Printf("[Login] Error checking 2FA: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    twoFactor.IssueMFAToken(user.ID, "password"),
+		})
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "vigileye_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   cfg.Env == "production",
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600 * 24 * 7, // 7 days
-	})
+	if err := throttle.RecordSuccess(input.Email); err != nil {
+		log.Printf("[Login] Error clearing failures: %v", err)
+	}
+	if !startSession(w, r, cfg, user.ID) {
+		return
+	}
+	recordSecurityEvent(r, user.ID, services.SecurityLoginSucceeded, map[string]interface{}{"method": "password"})
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"user": user,
 	})
 }
 
+// writeLockedOut answers a sign-in attempt while the account or client is locked out
+func writeLockedOut(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too many failed sign-in attempts. Try again in %d seconds.", seconds), http.StatusTooManyRequests)
+}
+
 func Me(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(int)
 
 	var user models.User
 	err := database.DB.QueryRow(
-		"SELECT id, email, name, created_at FROM users WHERE id = $1",
+		"SELECT id, email, name, email_verified_at IS NOT NULL, created_at FROM users WHERE id = $1",
 		userID,
-	).Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.Name, &user.EmailVerified, &user.CreatedAt)
 
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -1,43 +1,36 @@
 package handlers
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
 	"github.com/prabalesh/vigileye/middleware"
 	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
 )
 
+// primaryKeyColumn selects an environment's newest usable API key, the one the dashboard shows
+const primaryKeyColumn = `COALESCE((
+	SELECT k.api_key FROM environment_api_keys k
+	WHERE k.environment_id = environments.id AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	ORDER BY k.id DESC LIMIT 1
+), '')`
+
 func GetEnvironments(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	rows, err := database.DB.Query(`
-		SELECT id, project_id, name, description, api_key, settings, is_active, created_at, updated_at 
+		SELECT id, project_id, name, description, `+primaryKeyColumn+`, allowed_origins, settings, is_active, created_at, updated_at
 		FROM environments WHERE project_id = $1 ORDER BY created_at ASC
 	`, projectID)
 	if err != nil {
@@ -46,16 +39,19 @@ func GetEnvironments(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	access := middleware.Access(r)
 	envs := []models.Environment{}
 	for rows.Next() {
 		var e models.Environment
 		var settingsJSON []byte
-		if err := rows.Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		if err := rows.Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, pq.Array(&e.AllowedOrigins), &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt); err != nil {
 			continue
 		}
-		if len(settingsJSON) > 0 {
-			json.Unmarshal(settingsJSON, &e.Settings)
+		if !access.CanIn(e.ID, models.PermProjectView) {
+			continue
 		}
+		decodeEnvironmentSettings(&e, settingsJSON)
+		maskEnvironmentKey(r, &e)
 		envs = append(envs, e)
 	}
 
@@ -63,65 +59,32 @@ func GetEnvironments(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetEnvironment(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	envID, _ := strconv.Atoi(vars["env_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	var e models.Environment
 	var settingsJSON []byte
-	err = database.DB.QueryRow(`
-		SELECT id, project_id, name, description, api_key, settings, is_active, created_at, updated_at 
+	err := database.DB.QueryRow(`
+		SELECT id, project_id, name, description, `+primaryKeyColumn+`, allowed_origins, settings, is_active, created_at, updated_at
 		FROM environments WHERE id = $1 AND project_id = $2
-	`, envID, projectID).Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt)
+	`, envID, projectID).Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, pq.Array(&e.AllowedOrigins), &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt)
 
 	if err != nil {
 		http.Error(w, "Environment not found", http.StatusNotFound)
 		return
 	}
 
-	if len(settingsJSON) > 0 {
-		json.Unmarshal(settingsJSON, &e.Settings)
-	}
+	decodeEnvironmentSettings(&e, settingsJSON)
+	maskEnvironmentKey(r, &e)
 
 	json.NewEncoder(w).Encode(e)
 }
 
 func CreateEnvironment(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	var input struct {
 		Name string `json:"name"`
 	}
@@ -137,68 +100,136 @@ func CreateEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	var e models.Environment
 	var settingsJSON []byte
-	err = database.DB.QueryRow(`
-		INSERT INTO environments (project_id, name) 
-		VALUES ($1, $2) 
-		RETURNING id, project_id, name, description, api_key, settings, is_active, created_at, updated_at
-	`, projectID, input.Name).Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt)
+	err = tx.QueryRow(`
+		INSERT INTO environments (project_id, name)
+		VALUES ($1, $2)
+		RETURNING id, project_id, name, description, allowed_origins, settings, is_active, created_at, updated_at
+	`, projectID, input.Name).Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, pq.Array(&e.AllowedOrigins), &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt)
 
 	if err != nil {
 		http.Error(w, "Database error or environment already exists", http.StatusInternalServerError)
 		return
 	}
 
-	if len(settingsJSON) > 0 {
-		json.Unmarshal(settingsJSON, &e.Settings)
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	key, err := services.CreateEnvironmentKey(tx, e.ID, &userID, services.EnvironmentKeyInput{Name: defaultKeyName})
+	if err != nil {
+		log.Printf("[CreateEnvironment] Create key error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	e.APIKey = key.APIKey
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
+	middleware.AuditTarget(r, "environment", strconv.Itoa(e.ID))
+	middleware.AuditChange(r, nil, map[string]string{"name": e.Name})
+
+	decodeEnvironmentSettings(&e, settingsJSON)
 
 	json.NewEncoder(w).Encode(e)
 }
 
+// decodeEnvironmentSettings unmarshals stored settings with secrets masked
+func decodeEnvironmentSettings(e *models.Environment, settingsJSON []byte) {
+	if len(settingsJSON) == 0 {
+		return
+	}
+	json.Unmarshal(settingsJSON, &e.Settings)
+	services.MaskNotificationSecrets(&e.Settings.Notifications)
+}
+
+// maskEnvironmentKey hides the environment's key from members who can't manage environments
+func maskEnvironmentKey(r *http.Request, e *models.Environment) {
+	if !middleware.Access(r).CanIn(e.ID, models.PermEnvironmentsManage) {
+		e.APIKey = services.MaskAPIKey(e.APIKey)
+	}
+}
+
 func sendJSONError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]string{"message": message})
 }
 
+type environmentUpdate struct {
+	Name           *string          `json:"name"`
+	IsActive       *bool            `json:"is_active"`
+	AllowedOrigins *[]string        `json:"allowed_origins"`
+	Settings       *json.RawMessage `json:"settings"`
+}
+
 func UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
-	vars := mux.Vars(r)
-	projectID, _ := strconv.Atoi(vars["id"])
-	envID, _ := strconv.Atoi(vars["env_id"])
+	envID, _ := strconv.Atoi(mux.Vars(r)["env_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
+	var input environmentUpdate
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		log.Printf("[UpdateEnvironment] Decode error: %v", err)
+		sendJSONError(w, fmt.Sprintf("Invalid input: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	bodyBytes, _ := io.ReadAll(r.Body)
-	log.Printf("[UpdateEnvironment] Raw body: %s", string(bodyBytes))
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-	var input struct {
-		Name     *string          `json:"name"`
-		IsActive *bool            `json:"is_active"`
-		Settings *json.RawMessage `json:"settings"`
+	// Environment managers may only change settings if they may also manage notifications
+	if input.Settings != nil && !middleware.Access(r).CanIn(envID, models.PermNotificationsManage) {
+		sendJSONError(w, "Insufficient permissions", http.StatusForbidden)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		log.Printf("[UpdateEnvironment] Decode error: %v", err)
+
+	updateEnvironment(w, r, input)
+}
+
+// UpdateEnvironmentNotifications replaces an environment's notification settings. It is the
+// route for notifiers, who may not change the environment itself.
+func UpdateEnvironmentNotifications(w http.ResponseWriter, r *http.Request) {
+	var notifications json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&notifications); err != nil {
+		log.Printf("[UpdateEnvironmentNotifications] Decode error: %v", err)
 		sendJSONError(w, fmt.Sprintf("Invalid input: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	settings, _ := json.Marshal(map[string]json.RawMessage{"notifications": notifications})
+	raw := json.RawMessage(settings)
+	updateEnvironment(w, r, environmentUpdate{Settings: &raw})
+}
+
+// updateEnvironment applies an update whose fields the caller has been checked for
+func updateEnvironment(w http.ResponseWriter, r *http.Request, input environmentUpdate) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	envID, _ := strconv.Atoi(vars["env_id"])
+
+	var before struct {
+		Name           string          `json:"name"`
+		IsActive       bool            `json:"is_active"`
+		AllowedOrigins []string        `json:"allowed_origins"`
+		Settings       json.RawMessage `json:"settings"`
+	}
+	var storedJSON []byte
+	err := database.DB.QueryRow(
+		"SELECT name, is_active, allowed_origins, settings FROM environments WHERE id = $1 AND project_id = $2", envID, projectID,
+	).Scan(&before.Name, &before.IsActive, pq.Array(&before.AllowedOrigins), &storedJSON)
+	if err != nil {
+		sendJSONError(w, "Environment not found", http.StatusNotFound)
+		return
+	}
+	if len(storedJSON) > 0 {
+		before.Settings = storedJSON
+	}
+
+	var newSettings, storedSettings *models.EnvironmentSettings
+
 	query := "UPDATE environments SET updated_at = NOW()"
 	args := []interface{}{}
 	argIdx := 1
@@ -220,27 +251,92 @@ func UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
 		args = append(args, *input.IsActive)
 		argIdx++
 	}
+
+	if input.AllowedOrigins != nil {
+		origins, err := services.NormalizeOrigins(*input.AllowedOrigins)
+		if err != nil {
+			sendJSONError(w, "Invalid allowed origins: use up to 20 origins like https://app.example.com or https://*.example.com", http.StatusBadRequest)
+			return
+		}
+		if len(origins) == 0 {
+			used, err := services.NewEnvironmentKeyService(database.DB).PublicKeysUseEnvironmentOrigins(envID)
+			if err != nil {
+				log.Printf("[UpdateEnvironment] Key check error: %v", err)
+				sendJSONError(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if used {
+				sendJSONError(w, services.ErrPublicKeyOrigin.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		*input.AllowedOrigins = origins
+		query += fmt.Sprintf(", allowed_origins = $%d", argIdx)
+		args = append(args, pq.Array(origins))
+		argIdx++
+	}
 	if input.Settings != nil {
 		// Validate settings structure
-		var dummy models.EnvironmentSettings
-		if err := json.Unmarshal([]byte(*input.Settings), &dummy); err != nil {
+		var settings models.EnvironmentSettings
+		if err := json.Unmarshal([]byte(*input.Settings), &settings); err != nil {
 			log.Printf("[UpdateEnvironment] Settings validation error: %v", err)
 			sendJSONError(w, fmt.Sprintf("Invalid settings structure: %v", err), http.StatusBadRequest)
 			return
 		}
+		if err := settings.Notifications.Telegram.Policy.QuietHours.Validate(); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid settings structure: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := services.ValidateTelegramTemplates(settings.Notifications.Telegram.Templates); err != nil {
+			sendJSONError(w, fmt.Sprintf("Invalid notification template: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// Secrets are write-only: keep stored values unless a new one is sent
+		var stored models.EnvironmentSettings
+		if len(storedJSON) > 0 {
+			json.Unmarshal(storedJSON, &stored)
+		}
+		services.PreserveNotificationSecrets(&settings.Notifications, &stored.Notifications)
+
+		cfg := config.LoadConfig()
+		box := services.NewSecretBox(cfg)
+		if telegram := settings.Notifications.Telegram; telegram.Enabled && telegram.Interactive {
+			botToken, err := box.Decrypt(telegram.BotToken)
+			if err == nil {
+				err = services.CheckWebhookBot(database.DB, box, envID, botToken, cfg.TelegramHelperBotToken)
+			}
+			if err == services.ErrHelperBotWebhook || err == services.ErrWebhookBotShared {
+				sendJSONError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Printf("[UpdateEnvironment] Webhook bot check error: %v", err)
+				sendJSONError(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
 
-		settingsJSON, _ := json.Marshal(input.Settings)
+		if err := services.EncryptNotificationSecrets(box, &settings.Notifications); err != nil {
+			log.Printf("[UpdateEnvironment] Encrypt error: %v", err)
+			sendJSONError(w, "Failed to store notification secrets", http.StatusInternalServerError)
+			return
+		}
+
+		newSettings, storedSettings = &settings, &stored
+
+		settingsJSON, _ := json.Marshal(settings)
 		query += fmt.Sprintf(", settings = $%d", argIdx)
 		args = append(args, settingsJSON)
 		argIdx++
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d AND project_id = $%d RETURNING id, project_id, name, description, api_key, settings, is_active, created_at, updated_at", argIdx, argIdx+1)
+	query += fmt.Sprintf(" WHERE id = $%d AND project_id = $%d RETURNING id, project_id, name, description, "+primaryKeyColumn+", allowed_origins, settings, is_active, created_at, updated_at", argIdx, argIdx+1)
 	args = append(args, envID, projectID)
 
 	var e models.Environment
 	var settingsJSON []byte
-	err = database.DB.QueryRow(query, args...).Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt)
+	err = database.DB.QueryRow(query, args...).Scan(&e.ID, &e.ProjectID, &e.Name, &e.Description, &e.APIKey, pq.Array(&e.AllowedOrigins), &settingsJSON, &e.IsActive, &e.CreatedAt, &e.UpdatedAt)
 
 	if err != nil {
 		log.Printf("[UpdateEnvironment] DB Error: %v", err)
@@ -248,37 +344,35 @@ func UpdateEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Stored secrets are encrypted, so the diff shows that they changed without revealing them
+	after := before
+	after.Name, after.IsActive, after.AllowedOrigins = e.Name, e.IsActive, e.AllowedOrigins
 	if len(settingsJSON) > 0 {
-		json.Unmarshal(settingsJSON, &e.Settings)
+		after.Settings = settingsJSON
+	}
+	middleware.AuditChange(r, before, after)
+	if input.IsActive != nil || input.AllowedOrigins != nil {
+		middleware.InvalidateAPIKeys()
+	}
+
+	if newSettings != nil {
+		go syncTelegramWebhook(envID, newSettings.Notifications.Telegram, storedSettings.Notifications.Telegram)
 	}
 
+	decodeEnvironmentSettings(&e, settingsJSON)
+	maskEnvironmentKey(r, &e)
+
 	json.NewEncoder(w).Encode(e)
 }
 
 func DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	envID, _ := strconv.Atoi(vars["env_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	// Check if it's the last environment
 	var count int
-	err = database.DB.QueryRow("SELECT COUNT(*) FROM environments WHERE project_id = $1", projectID).Scan(&count)
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM environments WHERE project_id = $1", projectID).Scan(&count)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -289,47 +383,64 @@ func DeleteEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = database.DB.Exec("DELETE FROM environments WHERE id = $1 AND project_id = $2", envID, projectID)
+	var name string
+	err = database.DB.QueryRow("DELETE FROM environments WHERE id = $1 AND project_id = $2 RETURNING name", envID, projectID).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	middleware.AuditChange(r, map[string]string{"name": name}, nil)
+	middleware.InvalidateAPIKeys()
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RegenerateEnvironmentKey replaces the environment's primary key. By default the old key stops
+// working at once; {"overlap_hours": n} keeps it working for n hours while clients are updated.
 func RegenerateEnvironmentKey(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	envID, _ := strconv.Atoi(vars["env_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
+	overlap, ok := decodeKeyOverlap(w, r)
+	if !ok {
 		return
 	}
 
-	var apiKey string
-	err = database.DB.QueryRow(`
-		UPDATE environments SET api_key = gen_random_uuid() 
-		WHERE id = $1 AND project_id = $2 
-		RETURNING api_key
-	`, envID, projectID).Scan(&apiKey)
-
-	if err != nil {
+	keys := services.NewEnvironmentKeyService(database.DB)
+	old, err := keys.Primary(projectID, envID)
+	var key *services.EnvironmentKey
+	if err == services.ErrKeyNotFound {
+		// Every key was revoked or has expired, so there is nothing to overlap with
+		key, err = keys.Create(projectID, envID, userID, services.EnvironmentKeyInput{Name: defaultKeyName})
+	} else if err == nil {
+		key, err = keys.Rotate(projectID, envID, old.ID, userID, overlap)
+	}
+	if err == services.ErrKeyNotFound {
 		http.Error(w, "Environment not found", http.StatusNotFound)
 		return
 	}
+	if err == services.ErrInvalidOverlap {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[RegenerateEnvironmentKey] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.InvalidateAPIKeys()
+
+	var before interface{}
+	if old != nil {
+		before = map[string]interface{}{"key_id": old.ID, "api_key": old.APIKey}
+	}
+	middleware.AuditChange(r, before, map[string]interface{}{"key_id": key.ID, "api_key": key.APIKey})
 
-	json.NewEncoder(w).Encode(map[string]string{"api_key": apiKey})
+	json.NewEncoder(w).Encode(map[string]string{"api_key": key.APIKey})
 }
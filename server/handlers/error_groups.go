@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prabalesh/vigileye/database"
 	"github.com/prabalesh/vigileye/middleware"
 	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
 )
 
 func GetErrorGroups(w http.ResponseWriter, r *http.Request) {
@@ -16,24 +19,20 @@ func GetErrorGroups(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	query := r.URL.Query()
-	envID := query.Get("environment_id")
-	status := query.Get("status")
+	filter := services.GroupFilter{
+		Status:              query.Get("status"),
+		AssignedTo:          query.Get("assigned_to"),
+		ExcludeEnvironments: middleware.Access(r).DeniedEnvironments(models.PermProjectView),
+	}
+	if envID := query.Get("environment_id"); envID != "" {
+		id, err := strconv.Atoi(envID)
+		if err != nil {
+			http.Error(w, "Invalid environment_id filter", http.StatusBadRequest)
+			return
+		}
+		filter.EnvironmentID = &id
+	}
 	limit, _ := strconv.Atoi(query.Get("limit"))
 	if limit == 0 {
 		limit = 50
@@ -44,25 +43,19 @@ func GetErrorGroups(w http.ResponseWriter, r *http.Request) {
 		SELECT eg.id, eg.project_id, eg.environment_id, eg.fingerprint, eg.message, 
 		       eg.stack, eg.url, eg.source, eg.level, eg.first_seen, eg.last_seen, 
 		       eg.occurrence_count, eg.status, eg.resolved_at, eg.resolved_by, 
-		       eg.last_notified_at, eg.notification_count,
-		       eg.created_at, e.name as environment_name
+		       eg.last_notified_at, eg.notification_count, eg.assigned_to, eg.assigned_at,
+		       eg.snoozed_until, eg.created_at, e.name as environment_name, u.name as assignee_name
 		FROM error_groups eg
 		JOIN environments e ON eg.environment_id = e.id
+		LEFT JOIN users u ON u.id = eg.assigned_to
 		WHERE eg.project_id = $1`
 
-	args := []interface{}{projectID}
-	argIdx := 2
-
-	if envID != "" {
-		sqlQuery += " AND eg.environment_id = $" + strconv.Itoa(argIdx)
-		args = append(args, envID)
-		argIdx++
-	}
-	if status != "" {
-		sqlQuery += " AND eg.status = $" + strconv.Itoa(argIdx)
-		args = append(args, status)
-		argIdx++
+	sqlQuery, args, err := filter.Apply(sqlQuery, []interface{}{projectID}, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	argIdx := len(args) + 1
 
 	sqlQuery += " ORDER BY eg.last_seen DESC LIMIT $" + strconv.Itoa(argIdx) + " OFFSET $" + strconv.Itoa(argIdx+1)
 	args = append(args, limit, offset)
@@ -76,7 +69,8 @@ func GetErrorGroups(w http.ResponseWriter, r *http.Request) {
 
 	type GroupWithEnv struct {
 		models.ErrorGroup
-		EnvironmentName string `json:"environmentName"`
+		EnvironmentName string  `json:"environmentName"`
+		AssigneeName    *string `json:"assigneeName,omitempty"`
 	}
 
 	groups := []GroupWithEnv{}
@@ -86,8 +80,8 @@ func GetErrorGroups(w http.ResponseWriter, r *http.Request) {
 			&g.ID, &g.ProjectID, &g.EnvironmentID, &g.Fingerprint, &g.Message,
 			&g.Stack, &g.URL, &g.Source, &g.Level, &g.FirstSeen, &g.LastSeen,
 			&g.OccurrenceCount, &g.Status, &g.ResolvedAt, &g.ResolvedBy,
-			&g.LastNotifiedAt, &g.NotificationCount,
-			&g.CreatedAt, &g.EnvironmentName,
+			&g.LastNotifiedAt, &g.NotificationCount, &g.AssignedTo, &g.AssignedAt,
+			&g.SnoozedUntil, &g.CreatedAt, &g.EnvironmentName, &g.AssigneeName,
 		)
 		if err != nil {
 			continue
@@ -99,37 +93,25 @@ func GetErrorGroups(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetErrorGroupDetail(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	groupID, _ := strconv.Atoi(vars["group_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	var g models.ErrorGroup
-	err = database.DB.QueryRow(`
+	err := database.DB.QueryRow(`
 		SELECT id, project_id, environment_id, fingerprint, message, stack, url, 
 		       source, level, first_seen, last_seen, occurrence_count, status, 
-		       resolved_at, resolved_by, last_notified_at, notification_count, created_at
+		       resolved_at, resolved_by, last_notified_at, notification_count,
+		       assigned_to, assigned_at, snoozed_until, snoozed_at,
+		       snooze_until_occurrences, snooze_user_limit, created_at
 		FROM error_groups WHERE id = $1 AND project_id = $2
 	`, groupID, projectID).Scan(
 		&g.ID, &g.ProjectID, &g.EnvironmentID, &g.Fingerprint, &g.Message,
 		&g.Stack, &g.URL, &g.Source, &g.Level, &g.FirstSeen, &g.LastSeen,
 		&g.OccurrenceCount, &g.Status, &g.ResolvedAt, &g.ResolvedBy,
-		&g.LastNotifiedAt, &g.NotificationCount, &g.CreatedAt,
+		&g.LastNotifiedAt, &g.NotificationCount,
+		&g.AssignedTo, &g.AssignedAt, &g.SnoozedUntil, &g.SnoozedAt,
+		&g.SnoozeUntilOccurrences, &g.SnoozeUserLimit, &g.CreatedAt,
 	)
 
 	if err != nil {
@@ -141,26 +123,10 @@ func GetErrorGroupDetail(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetErrorGroupOccurrences(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	groupID, _ := strconv.Atoi(vars["group_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	query := r.URL.Query()
 	limit, _ := strconv.Atoi(query.Get("limit"))
 	if limit == 0 {
@@ -221,42 +187,294 @@ func updateGroupStatus(w http.ResponseWriter, r *http.Request, status string) {
 	projectID, _ := strconv.Atoi(vars["id"])
 	groupID, _ := strconv.Atoi(vars["group_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
+	var previous string
+	database.DB.QueryRow("SELECT status FROM error_groups WHERE id = $1 AND project_id = $2", groupID, projectID).Scan(&previous)
 
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
+	err := services.NewErrorGroupService(database.DB).UpdateStatus(projectID, groupID, userID, status)
+	if err != nil {
+		http.Error(w, "Error group not found", http.StatusNotFound)
 		return
 	}
+	middleware.AuditChange(r, map[string]string{"status": previous}, map[string]string{"status": status})
 
-	var query string
-	var args []interface{}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
 
-	if status == "resolved" {
-		query = "UPDATE error_groups SET status = $1, resolved_at = NOW(), resolved_by = $2 WHERE id = $3 AND project_id = $4 RETURNING id"
-		args = append(args, status, userID, groupID, projectID)
-	} else if status == "ignored" {
-		query = "UPDATE error_groups SET status = $1, resolved_at = NULL, resolved_by = NULL WHERE id = $2 AND project_id = $3 RETURNING id"
-		args = append(args, status, groupID, projectID)
-	} else { // unresolved
-		query = "UPDATE error_groups SET status = $1, resolved_at = NULL, resolved_by = NULL WHERE id = $2 AND project_id = $3 RETURNING id"
-		args = append(args, status, groupID, projectID)
+// AssignErrorGroup sets or clears a group's assignee; the assignee must be a project member
+func AssignErrorGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	groups := services.NewErrorGroupService(database.DB)
+
+	var input struct {
+		AssignedTo *int `json:"assigned_to"`
 	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var previous *int
+	database.DB.QueryRow("SELECT assigned_to FROM error_groups WHERE id = $1 AND project_id = $2", groupID, projectID).Scan(&previous)
 
-	var id int
-	err = database.DB.QueryRow(query, args...).Scan(&id)
+	err := groups.Assign(projectID, groupID, input.AssignedTo, &userID)
+	if err == services.ErrNotMember {
+		http.Error(w, "Assignee is not a member of this project", http.StatusBadRequest)
+		return
+	}
+	if err == services.ErrGroupNotFound {
+		http.Error(w, "Error group not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		log.Printf("[AssignErrorGroup] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(r, map[string]*int{"assigned_to": previous}, map[string]*int{"assigned_to": input.AssignedTo})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "assigned_to": input.AssignedTo})
+}
+
+// SnoozeErrorGroup silences a group until a time, for a duration, for N more occurrences or
+// for N more affected users, whichever comes first
+func SnoozeErrorGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	groups := services.NewErrorGroupService(database.DB)
+
+	var input struct {
+		Until       *time.Time `json:"until"`
+		Duration    string     `json:"duration"`
+		Occurrences *int       `json:"occurrences"`
+		Users       *int       `json:"users"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	opts := services.SnoozeOptions{Until: input.Until, Occurrences: input.Occurrences, Users: input.Users}
+	if input.Duration != "" {
+		if input.Until != nil {
+			http.Error(w, "Specify either until or duration, not both", http.StatusBadRequest)
+			return
+		}
+		d, err := services.ParseMuteDuration(input.Duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		until := time.Now().Add(d)
+		opts.Until = &until
+	}
+
+	err := groups.SnoozeWith(projectID, groupID, userID, opts)
+	if err == services.ErrInvalidSnooze {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == services.ErrGroupNotFound {
 		http.Error(w, "Error group not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Printf("[SnoozeErrorGroup] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
+
+// BulkUpdateErrorGroups applies one action to a list of groups or to every group matching a filter
+func BulkUpdateErrorGroups(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var input services.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Deleting discards occurrence history, so it needs more than triage rights
+	perm := models.PermErrorsTriage
+	if input.Action == services.BulkDelete {
+		perm = models.PermErrorsDelete
+	}
+	if !middleware.Can(r, perm) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+	input.ExcludeEnvironments = middleware.Access(r).DeniedEnvironments(perm)
+
+	result, err := services.NewBulkService(database.DB).Apply(projectID, userID, input)
+	switch err {
+	case nil:
+	case services.ErrInvalidBulkAction, services.ErrBulkTarget, services.ErrTooManyGroups, services.ErrInvalidFilter:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case services.ErrNotMember:
+		http.Error(w, "Assignee is not a member of this project", http.StatusBadRequest)
+		return
+	default:
+		log.Printf("[BulkUpdateErrorGroups] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// MergeErrorGroups folds the given groups into the group in the URL
+func MergeErrorGroups(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	groups := services.NewErrorGroupService(database.DB)
+
+	var input struct {
+		GroupIDs []int `json:"group_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err := groups.Merge(projectID, groupID, input.GroupIDs, userID)
+	switch err {
+	case nil:
+	case services.ErrMergeTargets, services.ErrMergeEnvironments:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case services.ErrGroupNotFound:
+		http.Error(w, "Error group not found", http.StatusNotFound)
+		return
+	default:
+		log.Printf("[MergeErrorGroups] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "group_id": groupID})
+}
+
+// UnmergeErrorGroup splits a merged fingerprint back out into its own group
+func UnmergeErrorGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	groups := services.NewErrorGroupService(database.DB)
+
+	var input struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Fingerprint == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	newGroupID, err := groups.Unmerge(projectID, groupID, userID, input.Fingerprint)
+	if err == services.ErrFingerprintNotMerged {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[UnmergeErrorGroup] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "group_id": newGroupID})
+}
+
+func GetErrorGroupFingerprints(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	groups := services.NewErrorGroupService(database.DB)
+
+	fingerprints, err := groups.Fingerprints(projectID, groupID)
+	if err == services.ErrGroupNotFound {
+		http.Error(w, "Error group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[GetErrorGroupFingerprints] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(fingerprints)
+}
+
+// GetErrorGroupActivity returns the group's timeline (status changes, assignments, notifications, comments)
+func GetErrorGroupActivity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	activity, err := services.NewActivityService(database.DB).List(projectID, groupID, limit, offset)
+	if err != nil {
+		log.Printf("[GetErrorGroupActivity] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(activity)
+}
+
+// CreateErrorGroupComment adds a comment, optionally as a reply; @email mentions of members are resolved
+func CreateErrorGroupComment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	groupID, _ := strconv.Atoi(vars["group_id"])
+
+	var input struct {
+		Body     string `json:"body"`
+		ParentID *int   `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	comment, err := services.NewActivityService(database.DB).AddComment(projectID, groupID, userID, input.ParentID, input.Body)
+	if err == services.ErrEmptyComment || err == services.ErrCommentParent {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == services.ErrGroupNotFound {
+		http.Error(w, "Error group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[CreateErrorGroupComment] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
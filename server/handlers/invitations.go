@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// writeInvitationError maps invitation service errors to responses
+func writeInvitationError(w http.ResponseWriter, handler string, err error) {
+	switch err {
+	case services.ErrInvalidEmail, services.ErrInvitationEmail:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrInvitationNotFound:
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+	case services.ErrInvitationExists, services.ErrAlreadyMember:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrInvitationExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+	case services.ErrInvitationDelivery:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		log.Printf("[%s] Error: %v", handler, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// GetInvitations lists the project's pending invitations
+func GetInvitations(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	invitations, err := services.NewInvitationService(database.DB, config.LoadConfig()).ListPending(projectID)
+	if err != nil {
+		writeInvitationError(w, "GetInvitations", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// ResendInvitation emails a fresh link; the previous one stops working
+func ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	invitationID, _ := strconv.Atoi(vars["invitation_id"])
+
+	inv, err := services.NewInvitationService(database.DB, config.LoadConfig()).Resend(projectID, invitationID)
+	if err != nil {
+		writeInvitationError(w, "ResendInvitation", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(inv)
+}
+
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	invitationID, _ := strconv.Atoi(vars["invitation_id"])
+
+	if err := services.NewInvitationService(database.DB, config.LoadConfig()).Revoke(projectID, invitationID); err != nil {
+		writeInvitationError(w, "RevokeInvitation", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LookupInvitation shows what an invitation link offers, before the visitor signs in or registers
+func LookupInvitation(w http.ResponseWriter, r *http.Request) {
+	inv, err := services.NewInvitationService(database.DB, config.LoadConfig()).Lookup(mux.Vars(r)["token"])
+	if err != nil {
+		writeInvitationError(w, "LookupInvitation", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"project_name": inv.ProjectName,
+		"email":        inv.Email,
+		"role":         inv.Role,
+		"inviter_name": inv.InviterName,
+		"expires_at":   inv.ExpiresAt,
+	})
+}
+
+// AcceptInvitation adds the signed-in user to the invited project
+func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := services.NewInvitationService(database.DB, config.LoadConfig()).Accept(input.Token, userID)
+	if err != nil {
+		writeInvitationError(w, "AcceptInvitation", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"project_id": inv.ProjectID,
+		"role":       inv.Role,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
 	"github.com/prabalesh/vigileye/middleware"
@@ -57,13 +59,29 @@ func LogError(w http.ResponseWriter, r *http.Request) {
 	defer tx.Rollback()
 
 	// Find or create error group
-	var groupID int
+	var group models.ErrorGroup
 	err = tx.QueryRow(`
-		SELECT id FROM error_groups 
-		WHERE fingerprint = $1 AND project_id = $2 AND environment_id = $3
-	`, fingerprint, projectID, environmentID).Scan(&groupID)
+		SELECT id, status, occurrence_count, snoozed_until, snoozed_at,
+		       snooze_until_occurrences, snooze_user_limit
+		FROM error_groups 
+		WHERE project_id = $2 AND environment_id = $3 AND (
+		    fingerprint = $1 OR id = (
+		        -- Fingerprints of merged groups route to the surviving group
+		        SELECT error_group_id FROM error_group_fingerprints
+		        WHERE fingerprint = $1 AND project_id = $2 AND environment_id = $3
+		    )
+		)
+		LIMIT 1
+		FOR UPDATE
+	`, fingerprint, projectID, environmentID).Scan(
+		&group.ID, &group.Status, &group.OccurrenceCount, &group.SnoozedUntil, &group.SnoozedAt,
+		&group.SnoozeUntilOccurrences, &group.SnoozeUserLimit,
+	)
+	groupID := group.ID
+	reopened := false
 
-	if err != nil {
+	created := err != nil
+	if created {
 		err = tx.QueryRow(`
 			INSERT INTO error_groups (
 				project_id, environment_id, fingerprint, message, stack, url, 
@@ -79,42 +97,67 @@ func LogError(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		// Update existing group - only auto-reopen if it was resolved (not ignored)
+		// Auto-reopen resolved groups (not ignored ones) and snoozed groups whose snooze has ended
+		reopened = group.Status == "resolved"
+		if group.Status == "snoozed" {
+			affectedUsers := 0
+			if group.SnoozeUserLimit != nil {
+				affectedUsers, err = countSnoozeAffectedUsers(tx, &group, input.UserID)
+				if err != nil {
+					log.Printf("[LogError] Error counting affected users: %v", err)
+					http.Error(w, "Database error", http.StatusInternalServerError)
+					return
+				}
+			}
+			reopened = group.SnoozeEnded(time.Now(), group.OccurrenceCount+1, affectedUsers)
+		}
+
 		_, err = tx.Exec(`
 			UPDATE error_groups 
 			SET last_seen = $1, 
 			    occurrence_count = occurrence_count + 1,
-			    status = CASE 
-			        WHEN status = 'resolved' THEN 'unresolved'
-			        ELSE status
-			    END,
-			    resolved_at = CASE 
-			        WHEN status = 'resolved' THEN NULL
-			        ELSE resolved_at
-			    END,
-			    resolved_by = CASE 
-			        WHEN status = 'resolved' THEN NULL
-			        ELSE resolved_by
-			    END
+			    status = CASE WHEN $3 THEN 'unresolved' ELSE status END,
+			    resolved_at = CASE WHEN $3 THEN NULL ELSE resolved_at END,
+			    resolved_by = CASE WHEN $3 THEN NULL ELSE resolved_by END,
+			    notification_step = CASE WHEN $3 THEN 0 ELSE notification_step END,
+			    streak_started_at = CASE WHEN $3 THEN NULL ELSE streak_started_at END,
+			    escalated_at = CASE WHEN $3 THEN NULL ELSE escalated_at END,
+			    snoozed_until = CASE WHEN $3 THEN NULL ELSE snoozed_until END,
+			    snoozed_at = CASE WHEN $3 THEN NULL ELSE snoozed_at END,
+			    snooze_until_occurrences = CASE WHEN $3 THEN NULL ELSE snooze_until_occurrences END,
+			    snooze_user_limit = CASE WHEN $3 THEN NULL ELSE snooze_user_limit END
 			WHERE id = $2
-		`, input.Timestamp, groupID)
+		`, input.Timestamp, groupID, reopened)
 
 		if err != nil {
 			log.Printf("[LogError] Error updating error group: %v", err)
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
+
+		if reopened {
+			reason := "regression"
+			if group.Status == "snoozed" {
+				reason = "snooze_ended"
+			}
+			err = services.RecordActivity(tx, groupID, nil, services.ActivityReopened, map[string]interface{}{"reason": reason})
+			if err != nil {
+				log.Printf("[LogError] Error recording activity: %v", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 
 	_, err = tx.Exec(`
 		INSERT INTO error_logs (
 			project_id, environment_id, error_group_id, timestamp, source, level, message, 
 			stack, url, method, user_agent, user_id, status_code, extra_data,
-			request_body, request_headers, response_body, response_time_ms
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+			request_body, request_headers, response_body, response_time_ms, fingerprint
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 	`, projectID, environmentID, groupID, input.Timestamp, input.Source, input.Level, input.Message,
 		input.Stack, input.URL, input.Method, input.UserAgent, input.UserID, input.StatusCode, input.ExtraData,
-		input.RequestBody, input.RequestHeaders, input.ResponseBody, input.ResponseTimeMs)
+		input.RequestBody, input.RequestHeaders, input.ResponseBody, input.ResponseTimeMs, fingerprint)
 
 	if err != nil {
 		log.Printf("[LogError] Error inserting error log: %v", err)
@@ -128,32 +171,19 @@ func LogError(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Trigger notifications in the background
-	go triggerNotifications(projectID, environmentID, groupID, input)
+	if created {
+		go autoAssignGroup(projectID, groupID)
+	}
+	go triggerNotifications(projectID, environmentID, groupID, input, reopened, group.Status)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
 func GetErrors(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	query := r.URL.Query()
 	level := query.Get("level")
 	source := query.Get("source")
@@ -192,6 +222,11 @@ func GetErrors(w http.ResponseWriter, r *http.Request) {
 		args = append(args, groupIDInt)
 		argIdx++
 	}
+	if hidden := middleware.Access(r).DeniedEnvironments(models.PermProjectView); len(hidden) > 0 {
+		sqlQuery += " AND environment_id <> ALL($" + strconv.Itoa(argIdx) + ")"
+		args = append(args, pq.Array(hidden))
+		argIdx++
+	}
 
 	sqlQuery += " ORDER BY created_at DESC LIMIT $" + strconv.Itoa(argIdx) + " OFFSET $" + strconv.Itoa(argIdx+1)
 	args = append(args, limit, offset)
@@ -223,28 +258,12 @@ func GetErrors(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetErrorDetail(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	errorID, _ := strconv.Atoi(vars["error_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	var l models.ErrorLog
-	err = database.DB.QueryRow(`
+	err := database.DB.QueryRow(`
 		SELECT id, project_id, environment_id, error_group_id, timestamp, source, level, message, stack, url, method, user_agent, user_id, status_code, extra_data, request_body, request_headers, response_body, response_time_ms, resolved, created_at 
 		FROM error_logs WHERE id = $1 AND project_id = $2
 	`, errorID, projectID).Scan(
@@ -262,26 +281,10 @@ func GetErrorDetail(w http.ResponseWriter, r *http.Request) {
 }
 
 func ResolveError(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	errorID, _ := strconv.Atoi(vars["error_id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
-
-	if err != nil || !exists {
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
-		return
-	}
-
 	var input struct {
 		Resolved bool `json:"resolved"`
 	}
@@ -290,7 +293,7 @@ func ResolveError(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = database.DB.Exec("UPDATE error_logs SET resolved = $1 WHERE id = $2 AND project_id = $3", input.Resolved, errorID, projectID)
+	_, err := database.DB.Exec("UPDATE error_logs SET resolved = $1 WHERE id = $2 AND project_id = $3", input.Resolved, errorID, projectID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -299,8 +302,37 @@ func ResolveError(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-// triggerNotifications handles sending alerts to various channels
-func triggerNotifications(projectID, environmentID, groupID int, logEntry models.ErrorLog) {
+// autoAssignGroup assigns an unassigned group to its owner from the project's ownership file
+func autoAssignGroup(projectID, groupID int) {
+	if err := services.NewOwnershipService(database.DB).AutoAssign(projectID, groupID); err != nil {
+		log.Printf("[AutoAssign] Error for error_group_id=%d: %v", groupID, err)
+	}
+}
+
+// countSnoozeAffectedUsers counts distinct users hit by a snoozed group since it was snoozed,
+// including the user of the log being ingested
+func countSnoozeAffectedUsers(tx *sql.Tx, group *models.ErrorGroup, userID *string) (int, error) {
+	var count int
+	var seen sql.NullBool
+	err := tx.QueryRow(`
+		SELECT COUNT(DISTINCT user_id), BOOL_OR(user_id = $3)
+		FROM error_logs
+		WHERE error_group_id = $1 AND created_at >= $2 AND user_id IS NOT NULL
+	`, group.ID, group.SnoozedAt, userID).Scan(&count, &seen)
+	if err != nil {
+		return 0, err
+	}
+
+	if userID != nil && *userID != "" && !seen.Bool {
+		count++
+	}
+	return count, nil
+}
+
+// triggerNotifications handles sending alerts to various channels. reopened is set when the log
+// reopened a group that was previously in previousStatus ("resolved" for a regression, "snoozed"
+// when a snooze ended).
+func triggerNotifications(projectID, environmentID, groupID int, logEntry models.ErrorLog, reopened bool, previousStatus string) {
 	// 1. Fetch error group and environment details
 	var eg models.ErrorGroup
 	var env models.Environment
@@ -308,12 +340,14 @@ func triggerNotifications(projectID, environmentID, groupID int, logEntry models
 
 	err := database.DB.QueryRow(`
 		SELECT eg.id, eg.project_id, eg.environment_id, eg.message, eg.stack, eg.level, 
-		       eg.first_seen, eg.last_seen, eg.occurrence_count, eg.status, eg.last_notified_at
+		       eg.first_seen, eg.last_seen, eg.occurrence_count, eg.status, eg.last_notified_at,
+		       eg.notification_step, eg.streak_started_at, eg.assigned_to
 		FROM error_groups eg
 		WHERE eg.id = $1
 	`, groupID).Scan(
 		&eg.ID, &eg.ProjectID, &eg.EnvironmentID, &eg.Message, &eg.Stack, &eg.Level,
 		&eg.FirstSeen, &eg.LastSeen, &eg.OccurrenceCount, &eg.Status, &eg.LastNotifiedAt,
+		&eg.NotificationStep, &eg.StreakStartedAt, &eg.AssignedTo,
 	)
 	if err != nil {
 		log.Printf("[Notification] Error fetching error group: %v", err)
@@ -328,27 +362,37 @@ func triggerNotifications(projectID, environmentID, groupID int, logEntry models
 		return
 	}
 
-	log.Printf("[Notification] Raw settingsJSON from DB: %s", string(settingsJSON))
-
 	if len(settingsJSON) > 0 {
 		if err := json.Unmarshal(settingsJSON, &env.Settings); err != nil {
 			log.Printf("[Notification] Error unmarshaling settings: %v", err)
 			return
 		}
-		log.Printf("[Notification] Unmarshaled settings: %+v", env.Settings)
-		log.Printf("[Notification] Telegram config: enabled=%v, bot_token_len=%d, chat_id=%s",
+		log.Printf("[Notification] Telegram config: enabled=%v, bot_token_set=%v, chat_id=%s",
 			env.Settings.Notifications.Telegram.Enabled,
-			len(env.Settings.Notifications.Telegram.BotToken),
+			env.Settings.Notifications.Telegram.BotToken != "",
 			env.Settings.Notifications.Telegram.ChatID)
 	} else {
 		log.Printf("[Notification] No settings found in database for environment_id=%d", environmentID)
 	}
 
-	// 2. Use NotificationService to handle logic
 	cfg := config.LoadConfig()
-	notifService := services.NewNotificationService(database.DB, cfg.BaseURL)
+	secrets := services.NewSecretBox(cfg)
+	if err := services.DecryptNotificationSecrets(secrets, &env.Settings.Notifications); err != nil {
+		log.Printf("[Notification] Error decrypting secrets: %v", err)
+		return
+	}
+
+	// 2. Use NotificationService to handle logic
+	notifService := services.NewNotificationService(database.DB, cfg.BaseURL, cfg.TelegramHelperBotToken, secrets)
+
+	if reopened && previousStatus == "resolved" {
+		if err := notifService.NotifyAssigneeOfRegression(&eg, &env); err != nil {
+			log.Printf("[Notification] Failed to notify assignee: %v", err)
+		}
+	}
 
-	if notifService.ShouldNotify(&eg, &env.Settings.Notifications) {
+	snoozeEnded := reopened && previousStatus == "snoozed" && notifService.ShouldNotifySnoozeEnd(&env.Settings.Notifications)
+	if snoozeEnded || notifService.ShouldNotify(&eg, &env.Settings.Notifications) {
 		log.Printf("[Notification] Triggering notification for error_group_id=%d", groupID)
 		err := notifService.SendNotification(&eg, &env, &env.Settings.Notifications)
 		if err != nil {
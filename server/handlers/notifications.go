@@ -3,13 +3,14 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
-	"github.com/prabalesh/vigileye/middleware"
 	"github.com/prabalesh/vigileye/models"
 	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
 
 	"github.com/gorilla/mux"
 )
@@ -17,12 +18,14 @@ import (
 type NotificationHandler struct {
 	db              *sql.DB
 	telegramService *services.TelegramService
+	secrets         *utils.SecretBox
 }
 
-func NewNotificationHandler(db *sql.DB) *NotificationHandler {
+func NewNotificationHandler(db *sql.DB, secrets *utils.SecretBox) *NotificationHandler {
 	return &NotificationHandler{
 		db:              db,
 		telegramService: services.NewTelegramService(),
+		secrets:         secrets,
 	}
 }
 
@@ -32,15 +35,6 @@ func (h *NotificationHandler) TestTelegramNotification(w http.ResponseWriter, r
 	projectID, _ := strconv.Atoi(vars["id"])
 	envID, _ := strconv.Atoi(vars["env_id"])
 
-	// Get current user from context
-	userID := r.Context().Value(middleware.UserIDKey).(int)
-
-	// Check if user is admin
-	if !h.isUserAdmin(projectID, userID) {
-		sendJSONError(w, "Admin access required", http.StatusForbidden)
-		return
-	}
-
 	// Get environment settings
 	var settingsJSON []byte
 	err := h.db.QueryRow(`
@@ -54,19 +48,23 @@ func (h *NotificationHandler) TestTelegramNotification(w http.ResponseWriter, r
 
 	var envSettings models.EnvironmentSettings
 	if len(settingsJSON) > 0 {
-		log.Printf("[Test Notification] Raw settings from DB: %s", string(settingsJSON))
 		if err := json.Unmarshal(settingsJSON, &envSettings); err != nil {
 			log.Printf("[Test Notification] Unmarshal error: %v", err)
 			sendJSONError(w, "Invalid settings format", http.StatusInternalServerError)
 			return
 		}
+		if err := services.DecryptNotificationSecrets(h.secrets, &envSettings.Notifications); err != nil {
+			log.Printf("[Test Notification] Decrypt error: %v", err)
+			sendJSONError(w, "Stored bot token could not be decrypted. Please re-enter it.", http.StatusInternalServerError)
+			return
+		}
 	} else {
 		log.Printf("[Test Notification] No settings found in DB")
 	}
 
 	telegram := envSettings.Notifications.Telegram
-	log.Printf("[Test Notification] Extracted config: enabled=%v, bot_token_len=%d, chat_id=%s",
-		telegram.Enabled, len(telegram.BotToken), telegram.ChatID)
+	log.Printf("[Test Notification] Extracted config: enabled=%v, bot_token_set=%v, chat_id=%s",
+		telegram.Enabled, telegram.BotToken != "", telegram.ChatID)
 
 	if !telegram.Enabled || telegram.BotToken == "" || telegram.ChatID == "" {
 		sendJSONError(w, "Telegram notifications not configured or enabled. Please save your settings first.", http.StatusBadRequest)
@@ -74,7 +72,7 @@ func (h *NotificationHandler) TestTelegramNotification(w http.ResponseWriter, r
 	}
 
 	// Send test notification
-	err = h.telegramService.TestNotification(telegram.BotToken, telegram.ChatID)
+	err = h.telegramService.TestNotification(telegram.BotToken, telegram.ChatID, telegram.Templates)
 
 	if err != nil {
 		log.Printf("[Test Notification] Error: %v", err)
@@ -95,17 +93,88 @@ func (h *NotificationHandler) TestTelegramNotification(w http.ResponseWriter, r
 	})
 }
 
-// GetNotificationHistory returns a list of error groups that have been notified
-func (h *NotificationHandler) GetNotificationHistory(w http.ResponseWriter, r *http.Request) {
+// PreviewNotificationTemplate renders a notification template against sample data.
+// Templates not included in the request fall back to the environment's saved overrides.
+func (h *NotificationHandler) PreviewNotificationTemplate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	envID, _ := strconv.Atoi(vars["env_id"])
 
-	userID := r.Context().Value(middleware.UserIDKey).(int)
-	if !h.isUserAdmin(projectID, userID) {
-		sendJSONError(w, "Admin access required", http.StatusForbidden)
+	var settingsJSON []byte
+	err := h.db.QueryRow(`
+		SELECT settings FROM environments WHERE id = $1 AND project_id = $2
+	`, envID, projectID).Scan(&settingsJSON)
+	if err != nil {
+		sendJSONError(w, "Environment not found", http.StatusNotFound)
+		return
+	}
+
+	var envSettings models.EnvironmentSettings
+	if len(settingsJSON) > 0 {
+		json.Unmarshal(settingsJSON, &envSettings)
+	}
+	templates := envSettings.Notifications.Telegram.Templates
+
+	var input struct {
+		Kind      string  `json:"kind"`
+		ParseMode *string `json:"parse_mode"`
+		Template  *string `json:"template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendJSONError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if input.Kind == "" {
+		input.Kind = services.TemplateError
+	}
+	if input.ParseMode != nil {
+		templates.ParseMode = *input.ParseMode
+	}
+	if input.Template != nil {
+		switch input.Kind {
+		case services.TemplateError:
+			templates.Error = *input.Template
+		case services.TemplateSpike:
+			templates.Spike = *input.Template
+		case services.TemplateEscalation:
+			templates.Escalation = *input.Template
+		case services.TemplateRegression:
+			templates.Regression = *input.Template
+		case services.TemplateDigest:
+			templates.Digest = *input.Template
+		}
+	}
+
+	// Report template errors instead of silently falling back like real deliveries do
+	if err := services.ValidateTelegramTemplates(templates); err != nil {
+		sendJSONError(w, fmt.Sprintf("Invalid notification template: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	parts, parseMode, err := services.RenderTelegram(templates, input.Kind, services.SampleTemplateData(input.Kind))
+	if err == services.ErrUnknownTemplate {
+		sendJSONError(w, "Unknown template kind", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[Preview Notification] Render error: %v", err)
+		sendJSONError(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":       input.Kind,
+		"parse_mode": parseMode,
+		"messages":   parts,
+	})
+}
+
+// GetNotificationHistory returns a list of error groups that have been notified
+func (h *NotificationHandler) GetNotificationHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	envID, _ := strconv.Atoi(vars["env_id"])
 
 	rows, err := h.db.Query(`
 		SELECT id, message, level, notification_count, last_notified_at, occurrence_count
@@ -151,16 +220,3 @@ func (h *NotificationHandler) GetNotificationHistory(w http.ResponseWriter, r *h
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(history)
 }
-
-func (h *NotificationHandler) isUserAdmin(projectID, userID int) bool {
-	var role string
-	err := h.db.QueryRow(`
-        SELECT role FROM project_members
-        WHERE project_id = $1 AND user_id = $2
-        UNION
-        SELECT 'admin' as role FROM projects
-        WHERE id = $1 AND owner_id = $2
-    `, projectID, userID).Scan(&role)
-
-	return err == nil && (role == "admin" || role == "owner")
-}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const (
+	oidcStateCookie  = "vigileye_oidc"
+	oidcStatePurpose = "oidc-state"
+	oidcStateTTL     = 10 * time.Minute
+)
+
+// The provider caches its discovery document and signing keys, so it is shared across requests
+var oidcProvider = sync.OnceValue(func() *services.OIDCProvider {
+	return services.NewOIDCProvider(config.LoadConfig())
+})
+
+// OIDCLogin starts single sign-on. The state, nonce and PKCE verifier travel in a signed,
+// short-lived cookie, so no server-side state is needed.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := oidcProvider()
+	if provider == nil {
+		http.Error(w, services.ErrOIDCNotConfigured.Error(), http.StatusNotFound)
+		return
+	}
+	cfg := config.LoadConfig()
+
+	values := make([]string, 3)
+	for i := range values {
+		v, err := utils.GenerateToken(32)
+		if err != nil {
+			http.Error(w, "Error starting sign-in", http.StatusInternalServerError)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("[OIDCLogin] Error: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    utils.SignToken(oidcStatePurpose, strings.Join(values, " "), time.Now().Add(oidcStateTTL), cfg.SecretKey),
+		Path:     "/api/auth/oidc",
+		HttpOnly: true,
+		Secure:   cfg.Env == "production",
+		// Lax, so the cookie comes back on the provider's top-level redirect
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes single sign-on and sends the browser back to the dashboard
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := oidcProvider()
+	if provider == nil {
+		http.Error(w, services.ErrOIDCNotConfigured.Error(), http.StatusNotFound)
+		return
+	}
+	cfg := config.LoadConfig()
+	dashboard := strings.TrimRight(cfg.BaseURL, "/")
+
+	fail := func(reason string, err error) {
+		if err != nil {
+			log.Printf("[OIDCCallback] %s: %v", reason, err)
+		}
+		http.Redirect(w, r, dashboard+"/login?sso_error="+url.QueryEscape(reason), http.StatusFound)
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1, HttpOnly: true})
+	if err != nil {
+		fail("invalid_state", err)
+		return
+	}
+	payload, err := utils.VerifySignedToken(cookie.Value, oidcStatePurpose, cfg.SecretKey, time.Now())
+	if err != nil {
+		fail("invalid_state", err)
+		return
+	}
+	values := strings.Split(payload, " ")
+	if len(values) != 3 || r.URL.Query().Get("state") != values[0] {
+		fail("invalid_state", nil)
+		return
+	}
+	nonce, verifier := values[1], values[2]
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		fail("cancelled", nil)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), r.URL.Query().Get("code"), verifier, nonce)
+	if err != nil {
+		fail("failed", err)
+		return
+	}
+
+	userID, err := services.NewSSOService(database.DB, cfg.OIDCAllowedDomains).SignIn(claims)
+	switch err {
+	case nil:
+	case services.ErrSSODomain:
+		fail("domain_not_allowed", nil)
+		return
+	case services.ErrSSOEmailUnverified:
+		fail("unverified_email", nil)
+		return
+	default:
+		fail("failed", err)
+		return
+	}
+
+	// Users with 2FA still need their second factor. The token goes in the fragment so it stays
+	// out of server and proxy logs; the dashboard posts it to /api/auth/2fa/verify.
+	twoFactor := services.NewTwoFactorService(database.DB, cfg)
+	enabled, err := twoFactor.Enabled(userID)
+	if err != nil {
+		fail("failed", err)
+		return
+	}
+	if enabled {
+		http.Redirect(w, r, dashboard+"/login#mfa_token="+url.QueryEscape(twoFactor.IssueMFAToken(userID, "sso")), http.StatusFound)
+		return
+	}
+
+	tokens, err := services.NewSessionService(database.DB, cfg).Create(userID, r.UserAgent(), middleware.ClientIP(r))
+	if err != nil {
+		fail("failed", err)
+		return
+	}
+	setSessionCookies(w, cfg, tokens)
+	recordSecurityEvent(r, userID, services.SecurityLoginSucceeded, map[string]interface{}{"method": "sso"})
+	http.Redirect(w, r, dashboard+"/", http.StatusFound)
+}
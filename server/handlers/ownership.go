@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/services"
+)
+
+const maxOwnershipFileSize = 64 << 10
+
+func GetOwnership(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	file, err := services.NewOwnershipService(database.DB).Get(projectID)
+	if err != nil {
+		log.Printf("[GetOwnership] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(file)
+}
+
+// UpdateOwnership replaces the project's ownership file. The body is either the raw
+// file (as uploaded) or JSON of the form {"content": "..."}.
+func UpdateOwnership(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxOwnershipFileSize+1))
+	if err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxOwnershipFileSize {
+		http.Error(w, "Ownership file too large (max 64KB)", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	content := string(body)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var input struct {
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(body, &input); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		content = input.Content
+	}
+
+	ownership := services.NewOwnershipService(database.DB)
+	before, err := ownership.Get(projectID)
+	if err != nil {
+		log.Printf("[UpdateOwnership] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	unknownOwners, err := ownership.Save(projectID, userID, content)
+	if errors.Is(err, services.ErrInvalidOwnership) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[UpdateOwnership] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(r, map[string]string{"content": before.Content}, map[string]string{"content": content})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"unknown_owners": unknownOwners,
+	})
+}
@@ -5,23 +5,33 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
 	"github.com/prabalesh/vigileye/middleware"
 	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
 )
 
 func GetProjects(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(int)
 
+	// Projects pending deletion are only listed for their owner, on request, so they can be restored
+	includeDeleted := r.URL.Query().Get("include_deleted") == "true"
+
 	rows, err := database.DB.Query(`
-		SELECT p.id, p.name, p.owner_id, p.created_at 
+		SELECT p.id, p.name, p.owner_id, p.created_at, p.require_2fa, p.deleted_at, p.purge_after
 		FROM projects p
 		LEFT JOIN project_members pm ON p.id = pm.project_id
-		WHERE p.owner_id = $1 OR pm.user_id = $1
+		WHERE (p.owner_id = $1 OR pm.user_id = $1)
+		  AND (p.deleted_at IS NULL OR ($2 AND p.owner_id = $1))
 		GROUP BY p.id
-	`, userID)
+	`, userID, includeDeleted)
 	if err != nil {
 		log.Printf("[GetProjects] Query error: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -32,7 +42,7 @@ func GetProjects(w http.ResponseWriter, r *http.Request) {
 	projects := []models.Project{}
 	for rows.Next() {
 		var p models.Project
-		if err := rows.Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt, &p.Require2FA, &p.DeletedAt, &p.PurgeAfter); err != nil {
 			continue
 		}
 		projects = append(projects, p)
@@ -80,32 +90,58 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 	for _, name := range envs {
 		var e models.Environment
 		var settingsJSON []byte
-		err = tx.QueryRow("INSERT INTO environments (project_id, name) VALUES ($1, $2) RETURNING id, project_id, name, api_key, settings, is_active, created_at", p.ID, name).
-			Scan(&e.ID, &e.ProjectID, &e.Name, &e.APIKey, &settingsJSON, &e.IsActive, &e.CreatedAt)
+		err = tx.QueryRow("INSERT INTO environments (project_id, name) VALUES ($1, $2) RETURNING id, project_id, name, allowed_origins, settings, is_active, created_at", p.ID, name).
+			Scan(&e.ID, &e.ProjectID, &e.Name, pq.Array(&e.AllowedOrigins), &settingsJSON, &e.IsActive, &e.CreatedAt)
 		if err != nil {
 			log.Printf("[CreateProject] Insert environment '%s' error: %v", name, err)
 			http.Error(w, "Database error creating environments", http.StatusInternalServerError)
 			return
 		}
 
-		// Unmarshal settings if present
-		if len(settingsJSON) > 0 {
-			if err := json.Unmarshal(settingsJSON, &e.Settings); err != nil {
-				log.Printf("[CreateProject] Unmarshal settings for '%s' error: %v", name, err)
-			}
+		key, err := services.CreateEnvironmentKey(tx, e.ID, &userID, services.EnvironmentKeyInput{Name: defaultKeyName})
+		if err != nil {
+			log.Printf("[CreateProject] Create key for '%s' error: %v", name, err)
+			http.Error(w, "Database error creating environments", http.StatusInternalServerError)
+			return
 		}
+		e.APIKey = key.APIKey
+
+		decodeEnvironmentSettings(&e, settingsJSON)
 
 		createdEnvs = append(createdEnvs, e)
 	}
 
 	// Add creator as admin member
-	_, err = tx.Exec("INSERT INTO project_members (project_id, user_id, role) VALUES ($1, $2, 'admin')", p.ID, userID)
+	_, err = tx.Exec("INSERT INTO project_members (project_id, user_id, role) VALUES ($1, $2, 'owner')", p.ID, userID)
 	if err != nil {
 		log.Printf("[CreateProject] Insert project member error: %v", err)
 		http.Error(w, "Database error adding member", http.StatusInternalServerError)
 		return
 	}
 
+	// Project creation isn't a project route, so it is audited here rather than by the middleware
+	entry := &services.AuditEntry{
+		ProjectID:  p.ID,
+		ActorID:    userID,
+		Action:     "project.create",
+		TargetType: "project",
+		TargetID:   strconv.Itoa(p.ID),
+		Changes:    utils.JSONDiff(nil, map[string]interface{}{"name": p.Name}),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     http.StatusOK,
+		IPAddress:  middleware.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+	if tokenID, ok := r.Context().Value(middleware.TokenIDKey).(int); ok {
+		entry.TokenID = &tokenID
+	}
+	if err := services.RecordAuditLog(tx, entry); err != nil {
+		log.Printf("[CreateProject] Audit log error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("[CreateProject] Transaction commit error: %v", err)
 		http.Error(w, "Database error committing", http.StatusInternalServerError)
@@ -125,7 +161,7 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 			{
 				ProjectID: p.ID,
 				UserID:    userID,
-				Role:      "admin",
+				Role:      models.RoleOwner,
 				CreatedAt: p.CreatedAt, // Approximate
 			},
 		},
@@ -133,37 +169,156 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetProject(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value(middleware.UserIDKey).(int)
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 
-	// Check access
-	var exists bool
-	err := database.DB.QueryRow(`
-		SELECT EXISTS (
-			SELECT 1 FROM projects p
-			LEFT JOIN project_members pm ON p.id = pm.project_id
-			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
-		)
-	`, projectID, userID).Scan(&exists)
+	var p models.Project
+	err := database.DB.QueryRow(
+		"SELECT id, name, owner_id, created_at, require_2fa, deleted_at, purge_after FROM projects WHERE id = $1",
+		projectID,
+	).Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt, &p.Require2FA, &p.DeletedAt, &p.PurgeAfter)
+
+	if err != nil {
+		log.Printf("[GetProject] Query error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(p)
+}
+
+// UpdateProject renames a project and sets its two-factor policy
+func UpdateProject(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var input struct {
+		Name       *string `json:"name"`
+		Require2FA *bool   `json:"require_2fa"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if input.Name != nil {
+		*input.Name = strings.TrimSpace(*input.Name)
+		if *input.Name == "" || len(*input.Name) > 255 {
+			http.Error(w, "Name is required (max 255 characters)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Whoever turns the policy on must meet it, or they would lock themselves out
+	if input.Require2FA != nil && *input.Require2FA {
+		enabled, err := services.NewTwoFactorService(database.DB, config.LoadConfig()).Enabled(userID)
+		if err != nil {
+			log.Printf("[UpdateProject] Error checking 2FA: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			http.Error(w, "Enable two-factor authentication on your account before requiring it", http.StatusBadRequest)
+			return
+		}
+	}
 
-	if err != nil || !exists {
-		log.Printf("[GetProject] Access check error or not found: err=%v, exists=%v", err, exists)
-		http.Error(w, "Project not found or access denied", http.StatusNotFound)
+	var before models.Project
+	err := database.DB.QueryRow("SELECT name, require_2fa FROM projects WHERE id = $1", projectID).Scan(&before.Name, &before.Require2FA)
+	if err != nil {
+		log.Printf("[UpdateProject] Select error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	var p models.Project
-	err = database.DB.QueryRow(
-		"SELECT id, name, owner_id, created_at FROM projects WHERE id = $1",
-		projectID,
-	).Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt)
+	err = database.DB.QueryRow(`
+		UPDATE projects SET name = COALESCE($1, name), require_2fa = COALESCE($2, require_2fa)
+		WHERE id = $3
+		RETURNING id, name, owner_id, created_at, require_2fa
+	`, input.Name, input.Require2FA, projectID).Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt, &p.Require2FA)
+	if err != nil {
+		log.Printf("[UpdateProject] Update error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(r,
+		map[string]interface{}{"name": before.Name, "require_2fa": before.Require2FA},
+		map[string]interface{}{"name": p.Name, "require_2fa": p.Require2FA},
+	)
 
+	json.NewEncoder(w).Encode(p)
+}
+
+// DeleteProject soft-deletes a project. It disappears and stops accepting errors straight away,
+// and is purged once PROJECT_DELETION_GRACE_DAYS have passed unless the owner restores it.
+func DeleteProject(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	grace := time.Duration(config.LoadConfig().ProjectDeletionDays) * 24 * time.Hour
+	p, err := services.NewProjectService(database.DB).SoftDelete(projectID, grace)
+	if err == services.ErrProjectNotFound {
+		http.Error(w, "Project is already pending deletion", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		log.Printf("[GetProject] Query error: %v", err)
+		log.Printf("[DeleteProject] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.InvalidateAPIKeys()
+
+	json.NewEncoder(w).Encode(p)
+}
+
+// RestoreProject cancels a pending deletion
+func RestoreProject(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	p, err := services.NewProjectService(database.DB).Restore(projectID)
+	if err == services.ErrProjectNotDeleted {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[RestoreProject] Error: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	// Its keys may be remembered as invalid
+	middleware.InvalidateAPIKeys()
 
 	json.NewEncoder(w).Encode(p)
 }
+
+// TransferProject hands ownership to another admin; the current owner becomes an admin
+func TransferProject(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var input struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	err := services.NewProjectService(database.DB).TransferOwnership(projectID, userID, input.UserID)
+	if err == services.ErrTransferTarget {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == services.ErrProjectNotFound {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[TransferProject] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditTarget(r, "member", strconv.Itoa(input.UserID))
+	middleware.AuditChange(r, map[string]int{"owner_id": userID}, map[string]int{"owner_id": input.UserID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
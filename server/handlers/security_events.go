@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// recordSecurityEvent adds to the user's security log. Failures are logged but don't fail the
+// request.
+func recordSecurityEvent(r *http.Request, userID int, kind string, data map[string]interface{}) {
+	err := services.RecordSecurityEvent(database.DB, userID, kind, middleware.ClientIP(r), r.UserAgent(), data)
+	if err != nil {
+		log.Printf("[recordSecurityEvent] Error recording %s for user %d: %v", kind, userID, err)
+	}
+}
+
+// GetSecurityEvents returns the current user's sign-ins and credential changes, newest first
+func GetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	events, err := services.NewSecurityEventService(database.DB).List(userID, query.Get("type"), limit, offset)
+	if err != nil {
+		log.Printf("[GetSecurityEvents] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(events)
+}
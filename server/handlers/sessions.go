@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/services"
+)
+
+const (
+	accessCookie  = "vigileye_token"
+	refreshCookie = "vigileye_refresh"
+	// The refresh token is only sent to the endpoints that use it
+	refreshCookiePath = "/api/auth"
+)
+
+// startSession creates a session for a user who just authenticated and sets its cookies. It
+// writes the error response and returns false on failure.
+func startSession(w http.ResponseWriter, r *http.Request, cfg config.Config, userID int) bool {
+	tokens, err := services.NewSessionService(database.DB, cfg).Create(userID, r.UserAgent(), middleware.ClientIP(r))
+	if err != nil {
+		log.Printf("[startSession] Error creating session: %v", err)
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return false
+	}
+	setSessionCookies(w, cfg, tokens)
+	return true
+}
+
+func setSessionCookies(w http.ResponseWriter, cfg config.Config, tokens *services.SessionTokens) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cfg.Env == "production",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(time.Until(tokens.AccessExpiresAt).Seconds()),
+	})
+	if tokens.RefreshToken == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    tokens.RefreshToken,
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		Secure:   cfg.Env == "production",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(time.Until(tokens.RefreshExpiresAt).Seconds()),
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter, cfg config.Config) {
+	for name, path := range map[string]string{accessCookie: "/", refreshCookie: refreshCookiePath} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			HttpOnly: true,
+			Secure:   cfg.Env == "production",
+			MaxAge:   -1,
+		})
+	}
+}
+
+// RefreshSession rotates the refresh token cookie and issues a new access token
+func RefreshSession(w http.ResponseWriter, r *http.Request) {
+	cfg := config.LoadConfig()
+
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, _, err := services.NewSessionService(database.DB, cfg).Refresh(cookie.Value, r.UserAgent(), middleware.ClientIP(r))
+	if err == services.ErrInvalidRefreshToken {
+		clearSessionCookies(w, cfg)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("[RefreshSession] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookies(w, cfg, tokens)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_at": tokens.AccessExpiresAt,
+	})
+}
+
+// Logout ends the current session. It works with an expired access token too, since the
+// refresh cookie identifies the session.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	cfg := config.LoadConfig()
+
+	if cookie, err := r.Cookie(refreshCookie); err == nil && cookie.Value != "" {
+		if err := services.NewSessionService(database.DB, cfg).RevokeByRefreshToken(cookie.Value); err != nil {
+			log.Printf("[Logout] Error: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	clearSessionCookies(w, cfg)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll ends every session of the current user, including this one
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	cfg := config.LoadConfig()
+
+	revoked, err := services.NewSessionService(database.DB, cfg).RevokeAll(userID, "")
+	if err != nil {
+		log.Printf("[LogoutAll] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookies(w, cfg)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revoked": revoked,
+	})
+}
+
+// GetSessions lists the current user's signed-in devices
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(string)
+
+	sessions, err := services.NewSessionService(database.DB, config.LoadConfig()).List(userID, sessionID)
+	if err != nil {
+		log.Printf("[GetSessions] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession logs one of the current user's devices out
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	err := services.NewSessionService(database.DB, config.LoadConfig()).Revoke(userID, mux.Vars(r)["session_id"])
+	if err == services.ErrSessionNotFound {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[RevokeSession] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
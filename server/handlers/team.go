@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
 	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
 )
 
 // GetProjectMembers lists all members of a project
@@ -30,26 +34,49 @@ func GetProjectMembers(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type MemberInfo struct {
-		UserID    int       `json:"user_id"`
-		Email     string    `json:"email"`
-		Name      string    `json:"name"`
-		Role      string    `json:"role"`
-		CreatedAt time.Time `json:"created_at"` // Changed to time.Time
+		UserID           int            `json:"user_id"`
+		Email            string         `json:"email"`
+		Name             string         `json:"name"`
+		Role             string         `json:"role"`
+		EnvironmentRoles map[int]string `json:"environment_roles"`
+		CreatedAt        time.Time      `json:"created_at"` // Changed to time.Time
 	}
 
 	members := []MemberInfo{}
+	index := map[int]int{}
 	for rows.Next() {
 		var m MemberInfo
 		if err := rows.Scan(&m.UserID, &m.Email, &m.Name, &m.Role, &m.CreatedAt); err != nil {
 			continue
 		}
+		m.EnvironmentRoles = map[int]string{}
+		index[m.UserID] = len(members)
 		members = append(members, m)
 	}
 
+	overrides, err := database.DB.Query(`
+		SELECT user_id, environment_id, role FROM project_member_environment_roles WHERE project_id = $1
+	`, projectID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer overrides.Close()
+	for overrides.Next() {
+		var userID, envID int
+		var role string
+		if err := overrides.Scan(&userID, &envID, &role); err != nil {
+			continue
+		}
+		if i, ok := index[userID]; ok {
+			members[i].EnvironmentRoles[envID] = role
+		}
+	}
+
 	json.NewEncoder(w).Encode(members)
 }
 
-// InviteMember adds a new member to the project
+// InviteMember adds a registered user to the project, or emails an invitation to anyone else
 func InviteMember(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
@@ -63,15 +90,32 @@ func InviteMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if input.Role != "admin" && input.Role != "member" {
+	input.Role = normalizeRole(input.Role)
+	if !models.IsAssignableRole(input.Role) {
 		http.Error(w, "Invalid role", http.StatusBadRequest)
 		return
 	}
 
 	var userID int
 	err := database.DB.QueryRow("SELECT id FROM users WHERE email = $1", input.Email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		// No account yet: email them an invitation they can accept when registering
+		inviterID := r.Context().Value(middleware.UserIDKey).(int)
+		inv, err := services.NewInvitationService(database.DB, config.LoadConfig()).Create(projectID, inviterID, input.Email, input.Role)
+		if err != nil {
+			writeInvitationError(w, "InviteMember", err)
+			return
+		}
+		middleware.AuditTarget(r, "invitation", strconv.Itoa(inv.ID))
+		middleware.AuditChange(r, nil, map[string]string{"email": inv.Email, "role": inv.Role})
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"invitation": inv,
+		})
+		return
+	}
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
@@ -93,6 +137,8 @@ func InviteMember(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	middleware.AuditTarget(r, "member", strconv.Itoa(userID))
+	middleware.AuditChange(r, nil, map[string]string{"email": input.Email, "role": pm.Role})
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(pm)
@@ -112,12 +158,12 @@ func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if input.Role != "admin" && input.Role != "member" {
+	input.Role = normalizeRole(input.Role)
+	if !models.IsAssignableRole(input.Role) {
 		http.Error(w, "Invalid role", http.StatusBadRequest)
 		return
 	}
 
-	// Check if changing last admin to member
 	var currentRole string
 	err := database.DB.QueryRow("SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, targetUserID).Scan(&currentRole)
 	if err != nil {
@@ -125,13 +171,9 @@ func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if currentRole == "admin" && input.Role == "member" {
-		var adminCount int
-		err = database.DB.QueryRow("SELECT COUNT(*) FROM project_members WHERE project_id = $1 AND role = 'admin'", projectID).Scan(&adminCount)
-		if err == nil && adminCount <= 1 {
-			http.Error(w, "Cannot remove last admin", http.StatusForbidden)
-			return
-		}
+	if currentRole == models.RoleOwner {
+		http.Error(w, "The owner's role can only change through an ownership transfer", http.StatusForbidden)
+		return
 	}
 
 	var pm models.ProjectMember
@@ -145,6 +187,7 @@ func UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	middleware.AuditChange(r, map[string]string{"role": currentRole}, map[string]string{"role": pm.Role})
 
 	json.NewEncoder(w).Encode(pm)
 }
@@ -155,7 +198,7 @@ func RemoveMember(w http.ResponseWriter, r *http.Request) {
 	projectID, _ := strconv.Atoi(vars["id"])
 	targetUserID, _ := strconv.Atoi(vars["user_id"])
 
-	// Check if removing last admin
+	// Ownership only changes hands through a transfer
 	var role string
 	err := database.DB.QueryRow("SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, targetUserID).Scan(&role)
 	if err != nil {
@@ -163,13 +206,9 @@ func RemoveMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if role == "admin" {
-		var adminCount int
-		err = database.DB.QueryRow("SELECT COUNT(*) FROM project_members WHERE project_id = $1 AND role = 'admin'", projectID).Scan(&adminCount)
-		if err == nil && adminCount <= 1 {
-			http.Error(w, "Cannot remove last admin", http.StatusForbidden)
-			return
-		}
+	if role == models.RoleOwner {
+		http.Error(w, "The project owner cannot be removed", http.StatusForbidden)
+		return
 	}
 
 	_, err = database.DB.Exec("DELETE FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, targetUserID)
@@ -177,28 +216,124 @@ func RemoveMember(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	middleware.AuditChange(r, map[string]string{"role": role}, nil)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
-// CheckLastAdmin checks if a user is the last admin of a project
+// CheckLastAdmin checks if a user is the last admin of a project. The owner always counts
+// as one, since they can't be removed or demoted here.
 func CheckLastAdmin(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	projectID, _ := strconv.Atoi(vars["id"])
 	targetUserID, _ := strconv.Atoi(vars["user_id"])
 
 	var adminCount int
-	err := database.DB.QueryRow("SELECT COUNT(*) FROM project_members WHERE project_id = $1 AND role = 'admin'", projectID).Scan(&adminCount)
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM project_members WHERE project_id = $1 AND role IN ('owner', 'admin')", projectID).Scan(&adminCount)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	var role string
-	err = database.DB.QueryRow("SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, targetUserID).Scan(&role)
+	database.DB.QueryRow("SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, targetUserID).Scan(&role)
 
-	isLastAdmin := (adminCount == 1 && role == "admin")
+	isLastAdmin := role == models.RoleOwner || (adminCount == 1 && role == models.RoleAdmin)
 
 	json.NewEncoder(w).Encode(map[string]bool{"is_last_admin": isLastAdmin})
 }
+
+// SetMemberEnvironmentRole overrides a member's role in one environment; "none" hides it
+func SetMemberEnvironmentRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	targetUserID, _ := strconv.Atoi(vars["user_id"])
+	envID, _ := strconv.Atoi(vars["env_id"])
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	input.Role = normalizeRole(input.Role)
+	if !models.IsEnvironmentRole(input.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	var role string
+	err := database.DB.QueryRow(`
+		SELECT pm.role FROM project_members pm
+		JOIN environments e ON e.project_id = pm.project_id AND e.id = $3
+		WHERE pm.project_id = $1 AND pm.user_id = $2
+	`, projectID, targetUserID, envID).Scan(&role)
+	if err != nil {
+		http.Error(w, "Member or environment not found", http.StatusNotFound)
+		return
+	}
+	if role == models.RoleOwner {
+		http.Error(w, "The project owner has full access to every environment", http.StatusForbidden)
+		return
+	}
+
+	var previous *string
+	database.DB.QueryRow(`
+		SELECT role FROM project_member_environment_roles
+		WHERE project_id = $1 AND user_id = $2 AND environment_id = $3
+	`, projectID, targetUserID, envID).Scan(&previous)
+
+	_, err = database.DB.Exec(`
+		INSERT INTO project_member_environment_roles (project_id, user_id, environment_id, role)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (project_id, user_id, environment_id) DO UPDATE SET role = EXCLUDED.role
+	`, projectID, targetUserID, envID, input.Role)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(r,
+		map[string]interface{}{"environment_id": envID, "role": previous},
+		map[string]interface{}{"environment_id": envID, "role": input.Role},
+	)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"environment_id": envID, "role": input.Role})
+}
+
+// DeleteMemberEnvironmentRole removes an override so the member's project role applies again
+func DeleteMemberEnvironmentRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	targetUserID, _ := strconv.Atoi(vars["user_id"])
+	envID, _ := strconv.Atoi(vars["env_id"])
+
+	var previous string
+	err := database.DB.QueryRow(`
+		DELETE FROM project_member_environment_roles
+		WHERE project_id = $1 AND user_id = $2 AND environment_id = $3
+		RETURNING role
+	`, projectID, targetUserID, envID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if previous != "" {
+		middleware.AuditChange(r,
+			map[string]interface{}{"environment_id": envID, "role": previous},
+			map[string]interface{}{"environment_id": envID, "role": nil},
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// normalizeRole maps the legacy "member" role to developer, which has the same rights
+func normalizeRole(role string) string {
+	if role == "member" {
+		return models.RoleDeveloper
+	}
+	return role
+}
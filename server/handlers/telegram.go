@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// CreateTelegramLinkCode issues a one-time code for linking the caller's Telegram account
+func CreateTelegramLinkCode(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	code, expiresAt, err := services.NewTelegramLinkService(database.DB).CreateUserLinkCode(userID)
+	if err != nil {
+		log.Printf("[CreateTelegramLinkCode] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":         code,
+		"expires_at":   expiresAt,
+		"instructions": fmt.Sprintf("Send /link %s to the Vigil Eye helper bot in a private chat", code),
+	})
+}
+
+// GetTelegramLink returns the caller's linked Telegram account, if any
+func GetTelegramLink(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	telegramUserID, username, err := services.NewTelegramLinkService(database.DB).GetUserLink(userID)
+	if err == services.ErrTelegramNotLinked {
+		json.NewEncoder(w).Encode(map[string]interface{}{"linked": false})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"linked":            true,
+		"telegram_user_id":  telegramUserID,
+		"telegram_username": username,
+	})
+}
+
+func DeleteTelegramLink(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	if err := services.NewTelegramLinkService(database.DB).Unlink(userID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateTelegramChatLinkCode issues a one-time code for linking a Telegram chat to the project
+func CreateTelegramChatLinkCode(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	code, expiresAt, err := services.NewTelegramLinkService(database.DB).CreateChatLinkCode(userID, projectID)
+	if err != nil {
+		log.Printf("[CreateTelegramChatLinkCode] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":         code,
+		"expires_at":   expiresAt,
+		"instructions": fmt.Sprintf("Add the Vigil Eye helper bot to your group and send /link %s", code),
+	})
+}
+
+func GetTelegramChats(w http.ResponseWriter, r *http.Request) {
+	projectID, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	chats, err := services.NewTelegramLinkService(database.DB).ProjectChats(projectID)
+	if err != nil {
+		log.Printf("[GetTelegramChats] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(chats)
+}
+
+func DeleteTelegramChat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["id"])
+	chatID, err := strconv.ParseInt(vars["chat_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.NewTelegramLinkService(database.DB).UnlinkChat(projectID, chatID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TelegramWebhook receives button presses on alerts sent by an environment's bot
+func TelegramWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	envID, _ := strconv.Atoi(vars["env_id"])
+
+	cfg := config.LoadConfig()
+	expected := services.WebhookSecretToken(cfg.SecretKey, envID)
+	if !hmac.Equal([]byte(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(expected)) {
+		http.Error(w, "Invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid update", http.StatusBadRequest)
+		return
+	}
+
+	// Always acknowledge so Telegram does not redeliver
+	defer w.WriteHeader(http.StatusOK)
+
+	if update.CallbackQuery == nil {
+		return
+	}
+
+	var projectID int
+	var settingsJSON []byte
+	err := database.DB.QueryRow("SELECT project_id, settings FROM environments WHERE id = $1", envID).Scan(&projectID, &settingsJSON)
+	if err != nil {
+		log.Printf("[TelegramWebhook] Environment %d not found: %v", envID, err)
+		return
+	}
+
+	var settings models.EnvironmentSettings
+	if len(settingsJSON) > 0 {
+		json.Unmarshal(settingsJSON, &settings)
+	}
+	if err := services.DecryptNotificationSecrets(services.NewSecretBox(cfg), &settings.Notifications); err != nil {
+		log.Printf("[TelegramWebhook] Error decrypting secrets: %v", err)
+		return
+	}
+
+	handleAlertAction(projectID, settings.Notifications.Telegram.BotToken, update.CallbackQuery)
+}
+
+func handleAlertAction(projectID int, botToken string, cq *tgbotapi.CallbackQuery) {
+	telegram := services.NewTelegramService()
+	answer := func(text string) {
+		if err := telegram.AnswerCallbackQuery(botToken, cq.ID, text); err != nil {
+			log.Printf("[TelegramWebhook] Error answering callback: %v", err)
+		}
+	}
+
+	action, groupID, err := services.ParseAlertAction(cq.Data)
+	if err != nil {
+		answer("Unknown action")
+		return
+	}
+
+	userID, userName, err := services.NewTelegramLinkService(database.DB).LookupUser(cq.From.ID)
+	if err == services.ErrTelegramNotLinked {
+		answer("Link your Telegram account in Vigil Eye first, then try again.")
+		return
+	}
+	if err != nil {
+		log.Printf("[TelegramWebhook] Error looking up user: %v", err)
+		answer("Something went wrong, please try again.")
+		return
+	}
+
+	groups := services.NewErrorGroupService(database.DB)
+	allowed, err := groups.CanTriage(projectID, groupID, userID)
+	if err == services.ErrGroupNotFound {
+		answer("This error group no longer exists.")
+		return
+	}
+	if err != nil || !allowed {
+		answer("You are not a member of this project or can't triage its errors.")
+		return
+	}
+
+	switch action {
+	case services.AlertActionResolve:
+		err = groups.UpdateStatus(projectID, groupID, userID, "resolved")
+	case services.AlertActionIgnore:
+		err = groups.UpdateStatus(projectID, groupID, userID, "ignored")
+	case services.AlertActionSnooze1h:
+		err = groups.Snooze(projectID, groupID, userID, time.Now().Add(time.Hour))
+	case services.AlertActionAssignMe:
+		err = groups.Assign(projectID, groupID, &userID, &userID)
+	}
+
+	if err == services.ErrGroupNotFound {
+		answer("This error group no longer exists.")
+		return
+	}
+	if err != nil {
+		log.Printf("[TelegramWebhook] Error applying %s to group %d: %v", action, groupID, err)
+		answer("Something went wrong, please try again.")
+		return
+	}
+
+	summary := services.AlertActionSummary(action, userName)
+
+	// Show who acted on the original alert; appending keeps existing entity offsets valid
+	if cq.Message != nil {
+		text := cq.Message.Text + "\n\n" + summary
+		err := telegram.EditMessageText(botToken, cq.Message.Chat.ID, cq.Message.MessageID, text, cq.Message.Entities, services.AlertActionKeyboard(groupID))
+		if err != nil {
+			log.Printf("[TelegramWebhook] Error editing message: %v", err)
+		}
+	}
+
+	answer(summary)
+}
+
+// syncTelegramWebhook registers or removes the alert bot webhook after settings change
+func syncTelegramWebhook(envID int, current, previous models.TelegramNotification) {
+	cfg := config.LoadConfig()
+	box := services.NewSecretBox(cfg)
+	telegram := services.NewTelegramService()
+
+	if current.Enabled && current.Interactive {
+		botToken, err := box.Decrypt(current.BotToken)
+		if err != nil || botToken == "" {
+			log.Printf("[TelegramWebhook] Cannot register webhook for environment %d: missing bot token", envID)
+			return
+		}
+		// A webhook would stop the helper bot's polling; UpdateEnvironment refuses this already
+		if botToken == cfg.TelegramHelperBotToken {
+			log.Printf("[TelegramWebhook] Not registering webhook for environment %d: it uses the helper bot", envID)
+			return
+		}
+		url := fmt.Sprintf("%s/api/telegram/webhook/%d", cfg.APIURL, envID)
+		if err := telegram.SetWebhook(botToken, url, services.WebhookSecretToken(cfg.SecretKey, envID)); err != nil {
+			log.Printf("[TelegramWebhook] Error registering webhook for environment %d: %v", envID, err)
+		}
+		return
+	}
+
+	if previous.Enabled && previous.Interactive {
+		botToken, err := box.Decrypt(previous.BotToken)
+		if err != nil || botToken == "" {
+			return
+		}
+		if err := telegram.DeleteWebhook(botToken); err != nil {
+			log.Printf("[TelegramWebhook] Error removing webhook for environment %d: %v", envID, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// GetPersonalTokens lists the current user's API tokens, without their secrets
+func GetPersonalTokens(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	tokens, err := services.NewPersonalTokenService(database.DB).List(userID)
+	if err != nil {
+		log.Printf("[GetPersonalTokens] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreatePersonalToken issues an API token. The secret is only included in this response.
+func CreatePersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	token, secret, err := services.NewPersonalTokenService(database.DB).Create(userID, input.Name, input.Scopes, input.ExpiresInDays)
+	if err == services.ErrInvalidTokenInput {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[CreatePersonalToken] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	recordSecurityEvent(r, userID, services.SecurityTokenCreated, map[string]interface{}{
+		"token_id": token.ID,
+		"name":     token.Name,
+		"scopes":   token.Scopes,
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*services.PersonalToken
+		Token string `json:"token"`
+	}{token, secret})
+}
+
+func RevokePersonalToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	tokenID, _ := strconv.Atoi(mux.Vars(r)["token_id"])
+
+	err := services.NewPersonalTokenService(database.DB).Revoke(userID, tokenID)
+	if err == services.ErrTokenNotFound {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[RevokePersonalToken] Error: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	recordSecurityEvent(r, userID, services.SecurityTokenRevoked, map[string]interface{}{"token_id": tokenID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+)
+
+type twoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+func writeTwoFactorError(w http.ResponseWriter, handler string, err error) {
+	switch err {
+	case services.ErrTwoFactorEnabled:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case services.ErrTwoFactorNotSetUp:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case services.ErrInvalidTOTPCode, services.ErrInvalidMFAToken:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case services.ErrTooManyTOTPFailures:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		log.Printf("[%s] Error: %v", handler, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+// SetupTwoFactor starts enrollment and returns the secret and the otpauth:// URI for the QR code
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	secret, uri, err := services.NewTwoFactorService(database.DB, config.LoadConfig()).Setup(userID)
+	if err != nil {
+		writeTwoFactorError(w, "SetupTwoFactor", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// EnableTwoFactor confirms enrollment with a code and returns the recovery codes
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input twoFactorCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := services.NewTwoFactorService(database.DB, config.LoadConfig()).Enable(userID, input.Code)
+	if err != nil {
+		writeTwoFactorError(w, "EnableTwoFactor", err)
+		return
+	}
+	recordSecurityEvent(r, userID, services.SecurityTwoFactorEnabled, nil)
+
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns 2FA off; it takes a current code or a recovery code
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input twoFactorCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.NewTwoFactorService(database.DB, config.LoadConfig()).Disable(userID, input.Code); err != nil {
+		writeTwoFactorError(w, "DisableTwoFactor", err)
+		return
+	}
+	recordSecurityEvent(r, userID, services.SecurityTwoFactorDisabled, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	var input twoFactorCodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := services.NewTwoFactorService(database.DB, config.LoadConfig()).RegenerateRecoveryCodes(userID, input.Code)
+	if err != nil {
+		writeTwoFactorError(w, "RegenerateRecoveryCodes", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// VerifyTwoFactor is the second login step: it trades the token from Login or the SSO callback
+// plus a code for a session
+func VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.LoadConfig()
+	twoFactor := services.NewTwoFactorService(database.DB, cfg)
+
+	userID, method, err := twoFactor.ParseMFAToken(input.MFAToken)
+	if err != nil {
+		writeTwoFactorError(w, "VerifyTwoFactor", err)
+		return
+	}
+	if err := twoFactor.Verify(userID, input.Code); err != nil {
+		if err == services.ErrInvalidTOTPCode {
+			recordSecurityEvent(r, userID, services.SecurityLoginFailed, map[string]interface{}{"step": "two_factor"})
+		}
+		writeTwoFactorError(w, "VerifyTwoFactor", err)
+		return
+	}
+
+	var user models.User
+	err = database.DB.QueryRow(
+		"SELECT id, email, name, email_verified_at IS NOT NULL, created_at FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Email, &user.Name, &user.EmailVerified, &user.CreatedAt)
+	if err != nil {
+		log.Printf("[VerifyTwoFactor] Error loading user: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := services.NewLoginThrottle(database.DB).RecordSuccess(user.Email); err != nil {
+		log.Printf("[VerifyTwoFactor] Error clearing failures: %v", err)
+	}
+	if !startSession(w, r, cfg, user.ID) {
+		return
+	}
+	recordSecurityEvent(r, user.ID, services.SecurityLoginSucceeded, map[string]interface{}{"method": method, "two_factor": true})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user": user,
+	})
+}
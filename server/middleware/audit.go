@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const auditEntryKey contextKey = "audit_entry"
+
+// Request bodies larger than this are audited without their body
+const maxAuditedBody = 64 << 10
+
+// RecordAudit stores an audit log entry; it is a variable so tests can stub the database
+var RecordAudit = func(e *services.AuditEntry) error {
+	return services.RecordAuditLog(database.DB, e)
+}
+
+// auditTargets maps path variables to the kind of resource they identify, most specific first
+var auditTargets = []struct{ variable, kind string }{
+	{"user_id", "member"},
+	{"invitation_id", "invitation"},
+	{"group_id", "error_group"},
+	{"error_id", "error"},
+	{"chat_id", "telegram_chat"},
+	{"key_id", "api_key"},
+	{"env_id", "environment"},
+	{"id", "project"},
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Audit records successful requests to a project route in the project's audit log under
+// action. It must run inside RequirePermission. Handlers add the before and after state of what
+// they changed with AuditChange.
+func Audit(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxAuditedBody+1))
+			if err != nil {
+				http.Error(w, "Invalid input", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+			projectID, _ := r.Context().Value(ProjectIDKey).(int)
+			userID, _ := r.Context().Value(UserIDKey).(int)
+			entry := &services.AuditEntry{
+				ProjectID: projectID,
+				ActorID:   userID,
+				Action:    action,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				IPAddress: ClientIP(r),
+				UserAgent: r.UserAgent(),
+			}
+			if tokenID, ok := r.Context().Value(TokenIDKey).(int); ok {
+				entry.TokenID = &tokenID
+			}
+			vars := mux.Vars(r)
+			for _, t := range auditTargets {
+				if id := vars[t.variable]; id != "" {
+					entry.TargetType, entry.TargetID = t.kind, id
+					break
+				}
+			}
+
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditEntryKey, entry)))
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.status >= 400 {
+				return
+			}
+			entry.Status = rec.status
+
+			var request interface{}
+			if len(body) <= maxAuditedBody && json.Unmarshal(body, &request) == nil {
+				entry.Request = utils.RedactSecrets(request)
+			}
+			if err := RecordAudit(entry); err != nil {
+				log.Printf("[Audit] Error recording %s in project %d: %v", action, projectID, err)
+			}
+		})
+	}
+}
+
+// AuditChange attaches the state before and after a change to the request's audit entry. Either
+// may be nil for creations and deletions. Secret fields are redacted.
+func AuditChange(r *http.Request, before, after interface{}) {
+	if entry, ok := r.Context().Value(auditEntryKey).(*services.AuditEntry); ok {
+		entry.Changes = utils.JSONDiff(before, after)
+	}
+}
+
+// AuditTarget overrides the audited target, for routes that create a resource
+func AuditTarget(r *http.Request, kind, id string) {
+	if entry, ok := r.Context().Value(auditEntryKey).(*services.AuditEntry); ok {
+		entry.TargetType, entry.TargetID = kind, id
+	}
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+func TestAudit(t *testing.T) {
+	var recorded []*services.AuditEntry
+	original := RecordAudit
+	RecordAudit = func(e *services.AuditEntry) error {
+		recorded = append(recorded, e)
+		return nil
+	}
+	defer func() { RecordAudit = original }()
+
+	status := http.StatusOK
+	handler := Audit("environment.update")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("Expected the handler to read the body, got %v", err)
+		}
+		AuditChange(r,
+			map[string]interface{}{"name": "prod", "api_key": "old-key"},
+			map[string]interface{}{"name": input["name"], "api_key": "new-key"},
+		)
+		w.WriteHeader(status)
+	}))
+
+	serve := func() {
+		body := `{"name":"production","settings":{"bot_token":"123:abc"}}`
+		req := httptest.NewRequest("PATCH", "/api/projects/1/environments/2", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": "1", "env_id": "2"})
+		ctx := context.WithValue(req.Context(), ProjectIDKey, 1)
+		ctx = context.WithValue(ctx, UserIDKey, 7)
+		ctx = context.WithValue(ctx, TokenIDKey, 9)
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	}
+
+	serve()
+	if len(recorded) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %d", len(recorded))
+	}
+	e := recorded[0]
+	if e.ProjectID != 1 || e.ActorID != 7 || e.TokenID == nil || *e.TokenID != 9 {
+		t.Errorf("Unexpected actor in %+v", e)
+	}
+	if e.Action != "environment.update" || e.TargetType != "environment" || e.TargetID != "2" || e.Status != http.StatusOK {
+		t.Errorf("Unexpected action or target in %+v", e)
+	}
+
+	changes := e.Changes.(map[string]utils.FieldChange)
+	if c := changes["name"]; c.From != "prod" || c.To != "production" {
+		t.Errorf("Expected name change, got %+v", c)
+	}
+	if c := changes["api_key"]; c.From != utils.Redacted || c.To != utils.Redacted {
+		t.Errorf("Expected api_key to be redacted, got %+v", c)
+	}
+
+	request, _ := json.Marshal(e.Request)
+	if strings.Contains(string(request), "123:abc") {
+		t.Errorf("Expected secrets to be redacted from the request, got %s", request)
+	}
+
+	// Failed requests change nothing, so they aren't recorded
+	status = http.StatusBadRequest
+	serve()
+	if len(recorded) != 1 {
+		t.Errorf("Expected failed request not to be audited, got %d entries", len(recorded))
+	}
+}
+
+func TestAuditKeepsLargeBodiesReadable(t *testing.T) {
+	original := RecordAudit
+	var recorded *services.AuditEntry
+	RecordAudit = func(e *services.AuditEntry) error {
+		recorded = e
+		return nil
+	}
+	defer func() { RecordAudit = original }()
+
+	body := `"` + strings.Repeat("a", maxAuditedBody+100) + `"`
+	handler := Audit("ownership.update")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if len(data) != len(body) {
+			t.Errorf("Expected the full %d byte body, got %d", len(body), len(data))
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/api/projects/1/ownership", strings.NewReader(body)))
+
+	if recorded == nil || recorded.Request != nil {
+		t.Errorf("Expected the entry without its oversized body, got %+v", recorded)
+	}
+}
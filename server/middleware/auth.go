@@ -2,12 +2,15 @@ package middleware
 
 import (
 	"context"
+	"log"
+	"net"
 	"net/http"
-	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
 	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/services"
 	"github.com/prabalesh/vigileye/utils"
 )
 
@@ -16,6 +19,18 @@ type contextKey string
 const UserIDKey contextKey = "user_id"
 const ProjectIDKey contextKey = "project_id"
 const EnvironmentIDKey contextKey = "environment_id"
+const SessionIDKey contextKey = "session_id"
+
+// TokenScopesKey holds the scopes of the personal access token a request was made with, and
+// TokenIDKey its ID
+const TokenScopesKey contextKey = "token_scopes"
+const TokenIDKey contextKey = "token_id"
+
+// SessionActive reports whether an access token's session has not been revoked; it is a
+// variable so tests can stub the database
+var SessionActive = func(sessionID string, userID int) (bool, error) {
+	return services.SessionActive(database.DB, sessionID, userID)
+}
 
 func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -28,6 +43,26 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
 			}
 
+			// Personal access tokens are only accepted as bearer tokens
+			if authHeader != "" && strings.HasPrefix(tokenString, services.PersonalTokenPrefix) {
+				identity, err := AuthenticateToken(tokenString, ClientIP(r))
+				if err == services.ErrInvalidAccessToken {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					log.Printf("[AuthMiddleware] Error checking token: %v", err)
+					http.Error(w, "Database error", http.StatusInternalServerError)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), UserIDKey, identity.UserID)
+				ctx = context.WithValue(ctx, TokenScopesKey, identity.Scopes)
+				ctx = context.WithValue(ctx, TokenIDKey, identity.TokenID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			// If no header, try to get from cookie
 			if tokenString == "" {
 				cookie, err := r.Cookie("vigileye_token")
@@ -41,86 +76,119 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			userID, err := utils.VerifyJWT(tokenString, jwtSecret)
+			claims, err := utils.VerifyJWT(tokenString, jwtSecret)
 			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			active, err := SessionActive(claims.SessionID, claims.UserID)
+			if err != nil {
+				log.Printf("[AuthMiddleware] Error checking session: %v", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				http.Error(w, "Session expired or revoked", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func APIKeyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey == "" {
-			http.Error(w, "X-API-Key header required", http.StatusForbidden)
+// AuthenticateToken resolves a personal access token; it is a variable so tests can stub the
+// database
+var AuthenticateToken = func(token, ip string) (*services.TokenIdentity, error) {
+	return services.NewPersonalTokenService(database.DB).Authenticate(token, ip)
+}
+
+// RequireSession rejects requests made with a personal access token, for account routes that
+// only the dashboard may use
+func RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(TokenScopesKey).([]string); ok {
+			http.Error(w, "Not available to API tokens", http.StatusForbidden)
 			return
 		}
+		next(w, r)
+	}
+}
+
+// ClientIP returns the address of the connecting client
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
-		var projectID, environmentID int
-		err := database.DB.QueryRow(`
-			SELECT project_id, id FROM environments 
-			WHERE api_key = $1 AND is_active = TRUE
-		`, apiKey).Scan(&projectID, &environmentID)
+var apiKeys = sync.OnceValue(func() *services.APIKeyCache {
+	return services.NewAPIKeyCache(database.DB, config.LoadConfig())
+})
 
-		if err != nil {
-			http.Error(w, "Invalid API Key", http.StatusForbidden)
-			return
-		}
+// LookupAPIKey resolves an ingestion key; it is a variable so tests can stub the database
+var LookupAPIKey = func(apiKey string) (*services.APIKeyIdentity, error) {
+	return apiKeys().Get(apiKey)
+}
 
-		ctx := context.WithValue(r.Context(), ProjectIDKey, projectID)
-		ctx = context.WithValue(ctx, EnvironmentIDKey, environmentID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// InvalidateAPIKeys drops cached key lookups on every replica after keys, environments or
+// projects change
+func InvalidateAPIKeys() {
+	apiKeys().Clear()
+	if err := services.NotifyAPIKeyChange(database.DB); err != nil {
+		log.Printf("[InvalidateAPIKeys] Error notifying other replicas: %v", err)
+	}
+}
+
+// ListenForAPIKeyChanges keeps this replica's key cache in step with changes made on others
+func ListenForAPIKeyChanges(ctx context.Context, databaseURL string) {
+	apiKeys().Listen(ctx, databaseURL)
 }
-func RequireAdmin(next http.Handler) http.Handler {
+
+func APIKeyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID, ok := r.Context().Value(UserIDKey).(int)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apiKey := r.Header.Get("X-API-Key")
+		if apiKey == "" {
+			http.Error(w, "X-API-Key header required", http.StatusForbidden)
 			return
 		}
 
-		vars := mux.Vars(r)
-		projectID, _ := strconv.Atoi(vars["id"])
-		if projectID == 0 {
-			projectID, _ = strconv.Atoi(vars["projectId"])
-		}
-		if projectID == 0 {
-			// Try to get from context if not in URL
-			if pid, ok := r.Context().Value(ProjectIDKey).(int); ok {
-				projectID = pid
-			}
-		}
-
-		if projectID == 0 {
-			http.Error(w, "Project ID required", http.StatusBadRequest)
+		identity, err := LookupAPIKey(apiKey)
+		if err == services.ErrInvalidAPIKey {
+			http.Error(w, "Invalid API Key", http.StatusForbidden)
 			return
 		}
-
-		var role string
-		err := database.DB.QueryRow(`
-			SELECT role FROM project_members 
-			WHERE project_id = $1 AND user_id = $2
-			UNION
-			SELECT 'admin' as role FROM projects
-			WHERE id = $1 AND owner_id = $2
-		`, projectID, userID).Scan(&role)
-
 		if err != nil {
-			http.Error(w, "Access denied", http.StatusForbidden)
+			log.Printf("[APIKeyMiddleware] Error looking up key: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
 
-		if role != "admin" {
-			http.Error(w, "Admin access required", http.StatusForbidden)
+		origin := requestOrigin(r)
+		if !identity.AllowsOrigin(origin) {
+			switch {
+			case origin == "":
+				http.Error(w, "Public API keys only work from browsers on an allowed origin", http.StatusForbidden)
+			case len(identity.AllowedOrigins) == 0:
+				http.Error(w, "This API key has no allowed origins; use a public key in browsers", http.StatusForbidden)
+			default:
+				http.Error(w, "Origin not allowed for this API key", http.StatusForbidden)
+			}
 			return
 		}
+		// Only origins the key allows may read the response
+		if r.Header.Get("Origin") != "" {
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ProjectIDKey, identity.ProjectID)
+		ctx = context.WithValue(ctx, EnvironmentIDKey, identity.EnvironmentID)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -1,23 +1,38 @@
 package middleware
 
 import (
+	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/services"
 	"github.com/prabalesh/vigileye/utils"
 )
 
 func TestAuthMiddleware(t *testing.T) {
 	secret := "test-secret-at-least-32-chars-long"
 	userID := 1
-	token, _ := utils.GenerateJWT(userID, secret)
+	token, _ := utils.GenerateJWT(userID, "active-session", time.Minute, secret)
+	revoked, _ := utils.GenerateJWT(userID, "revoked-session", time.Minute, secret)
+
+	original := SessionActive
+	SessionActive = func(sessionID string, uid int) (bool, error) {
+		return sessionID == "active-session" && uid == userID, nil
+	}
+	defer func() { SessionActive = original }()
 
 	handler := AuthMiddleware(secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctxUserID := r.Context().Value(UserIDKey).(int)
 		if ctxUserID != userID {
 			t.Errorf("Expected userID %d in context, got %d", userID, ctxUserID)
 		}
+		if sid := r.Context().Value(SessionIDKey).(string); sid != "active-session" {
+			t.Errorf("Expected session active-session in context, got %q", sid)
+		}
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -59,4 +74,142 @@ func TestAuthMiddleware(t *testing.T) {
 	if rr.Code != http.StatusUnauthorized {
 		t.Errorf("Expected status Unauthorized, got %d", rr.Code)
 	}
+
+	// Test with a token whose session was revoked
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+revoked)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for a revoked session, got %d", rr.Code)
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	original := LookupAPIKey
+	LookupAPIKey = func(apiKey string) (*services.APIKeyIdentity, error) {
+		switch apiKey {
+		case "open":
+			return &services.APIKeyIdentity{KeyID: 1, ProjectID: 2, EnvironmentID: 3}, nil
+		case "restricted":
+			return &services.APIKeyIdentity{KeyID: 4, ProjectID: 2, EnvironmentID: 3, Kind: services.KeyKindSecret, AllowedOrigins: []string{"https://app.example.com"}}, nil
+		case "public":
+			return &services.APIKeyIdentity{KeyID: 5, ProjectID: 2, EnvironmentID: 3, Kind: services.KeyKindPublic, AllowedOrigins: []string{"https://app.example.com"}}, nil
+		}
+		return nil, services.ErrInvalidAPIKey
+	}
+	defer func() { LookupAPIKey = original }()
+
+	handler := APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(ProjectIDKey).(int) != 2 || r.Context().Value(EnvironmentIDKey).(int) != 3 {
+			t.Errorf("Expected project 2 and environment 3 in context")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	tests := []struct {
+		name, key, origin, referer string
+		want                       int
+	}{
+		{"missing key", "", "", "", http.StatusForbidden},
+		{"unknown key", "unknown", "", "", http.StatusForbidden},
+		{"valid key", "open", "", "", http.StatusCreated},
+		{"unrestricted secret key from browser", "open", "https://anywhere.test", "", http.StatusForbidden},
+		{"restricted key from server", "restricted", "", "", http.StatusCreated},
+		{"restricted key from allowed origin", "restricted", "https://app.example.com", "", http.StatusCreated},
+		{"restricted key from other origin", "restricted", "https://evil.test", "", http.StatusForbidden},
+		{"public key from allowed origin", "public", "https://app.example.com", "", http.StatusCreated},
+		{"public key from allowed referer", "public", "", "https://app.example.com/checkout?step=2", http.StatusCreated},
+		{"public key from other referer", "public", "", "https://evil.test/", http.StatusForbidden},
+		{"public key from server", "public", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/log", nil)
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rr.Code)
+		}
+		allowOrigin := rr.Header().Get("Access-Control-Allow-Origin")
+		if rr.Code == http.StatusCreated && allowOrigin != tt.origin {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, got %q", tt.name, tt.origin, allowOrigin)
+		}
+		if rr.Code != http.StatusCreated && allowOrigin != "" {
+			t.Errorf("%s: expected no CORS headers on a rejected request, got %q", tt.name, allowOrigin)
+		}
+	}
+}
+
+// BenchmarkAPIKeyMiddleware measures ingestion requests through APIKeyMiddleware with every key
+// looked up in the database and with the cache in front of it. It needs a migrated database with
+// an active secret key in TEST_DATABASE_URL:
+//
+//	TEST_DATABASE_URL=postgres://... go test ./middleware -run xxx -bench APIKeyMiddleware
+func BenchmarkAPIKeyMiddleware(b *testing.B) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		b.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	var apiKey string
+	err = db.QueryRow(`
+		SELECT k.api_key FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		JOIN projects p ON p.id = e.project_id
+		WHERE k.kind = 'secret' AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())
+		  AND e.is_active = TRUE AND p.deleted_at IS NULL
+		LIMIT 1
+	`).Scan(&apiKey)
+	if err == sql.ErrNoRows {
+		b.Skip("no active secret API key in the test database")
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	original := LookupAPIKey
+	defer func() { LookupAPIKey = original }()
+
+	handler := APIKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	run := func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				req := httptest.NewRequest("POST", "/api/log", nil)
+				req.Header.Set("X-API-Key", apiKey)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				if rr.Code != http.StatusCreated {
+					b.Errorf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+					return
+				}
+			}
+		})
+		b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		LookupAPIKey = services.NewEnvironmentKeyService(db).Authenticate
+		run(b)
+	})
+	b.Run("cached", func(b *testing.B) {
+		LookupAPIKey = services.NewAPIKeyCache(db, config.Config{APIKeyCacheSeconds: 30, APIKeyNegativeCacheSeconds: 10}).Get
+		run(b)
+	})
 }
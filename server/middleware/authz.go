@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+)
+
+const ProjectAccessKey contextKey = "project_access"
+
+// LoadProjectAccess loads the caller's role and environment overrides; it is a variable so tests
+// can stub the database
+var LoadProjectAccess = func(projectID, userID int) (*models.ProjectAccess, error) {
+	return services.LoadProjectAccess(database.DB, projectID, userID)
+}
+
+// ResourceEnvironment finds the environment a request targets through its env_id, group_id or
+// error_id path variable. ok is false for project-wide routes and for unknown resources, which
+// the handlers report as not found.
+var ResourceEnvironment = func(projectID int, vars map[string]string) (envID int, ok bool, err error) {
+	if id, err := strconv.Atoi(vars["env_id"]); err == nil {
+		return id, true, nil
+	}
+
+	var query string
+	var id int
+	if groupID, err := strconv.Atoi(vars["group_id"]); err == nil {
+		query, id = "SELECT environment_id FROM error_groups WHERE id = $1 AND project_id = $2", groupID
+	} else if errorID, err := strconv.Atoi(vars["error_id"]); err == nil {
+		query, id = "SELECT environment_id FROM error_logs WHERE id = $1 AND project_id = $2", errorID
+	} else {
+		return 0, false, nil
+	}
+
+	err = database.DB.QueryRow(query, id, projectID).Scan(&envID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return envID, err == nil, err
+}
+
+// RequirePermission resolves the project in the {id} path variable, loads the caller's access into
+// the request context and rejects the request unless it grants perm. Routes that target a single
+// environment are checked against that environment's override, if any. Non-members (and members
+// whose override hides the environment) get a 404 so that IDs can't be probed; members lacking
+// the permission get a 403. Soft-deleted projects are 404 except to project:delete routes.
+func RequirePermission(perm models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(UserIDKey).(int)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			vars := mux.Vars(r)
+			projectID, err := strconv.Atoi(vars["id"])
+			if err != nil || projectID == 0 {
+				http.Error(w, "Project not found or access denied", http.StatusNotFound)
+				return
+			}
+
+			access, err := LoadProjectAccess(projectID, userID)
+			if err != nil {
+				log.Printf("[RequirePermission] Error loading access: %v", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			// A project pending deletion only remains visible to whoever can restore it
+			if access == nil || (access.ProjectDeleted && perm != models.PermProjectDelete) {
+				http.Error(w, "Project not found or access denied", http.StatusNotFound)
+				return
+			}
+
+			if access.TwoFactorMissing {
+				http.Error(w, "This project requires two-factor authentication", http.StatusForbidden)
+				return
+			}
+
+			if scopes, ok := r.Context().Value(TokenScopesKey).([]string); ok {
+				// Copied so that a token without scopes is restricted to nothing rather than unrestricted
+				access.Scopes = append([]string{}, scopes...)
+			}
+
+			allowed := access.Can(perm)
+			if len(access.EnvironmentRoles) > 0 {
+				envID, scoped, err := ResourceEnvironment(projectID, vars)
+				if err != nil {
+					log.Printf("[RequirePermission] Error resolving environment: %v", err)
+					http.Error(w, "Database error", http.StatusInternalServerError)
+					return
+				}
+				if scoped {
+					if !access.CanIn(envID, models.PermProjectView) {
+						http.Error(w, "Not found", http.StatusNotFound)
+						return
+					}
+					allowed = access.CanIn(envID, perm)
+				}
+			}
+			if !allowed {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ProjectIDKey, projectID)
+			ctx = context.WithValue(ctx, ProjectAccessKey, access)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Access returns the caller's project access loaded by RequirePermission
+func Access(r *http.Request) *models.ProjectAccess {
+	if access, ok := r.Context().Value(ProjectAccessKey).(*models.ProjectAccess); ok {
+		return access
+	}
+	return &models.ProjectAccess{}
+}
+
+// Can reports whether the caller's project role grants perm
+func Can(r *http.Request, perm models.Permission) bool {
+	return Access(r).Can(perm)
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIngestionCORS(t *testing.T) {
+	reached := false
+	handler := IngestionCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/api/log", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if reached {
+		t.Error("Expected the preflight to be answered without reaching the handler")
+	}
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Expected the origin to be allowed to send, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, X-API-Key" {
+		t.Errorf("Expected the API key header to be allowed, got %q", got)
+	}
+
+	req = httptest.NewRequest("POST", "/api/log", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !reached {
+		t.Error("Expected other requests to reach the handler")
+	}
+}
@@ -7,32 +7,52 @@ import (
 	"golang.org/x/time/rate"
 )
 
-var (
-	ips   = make(map[string]*rate.Limiter)
-	mutex sync.Mutex
-)
+// clientRateLimiter keeps a token bucket per client, as identified by key
+type clientRateLimiter struct {
+	mu        sync.Mutex
+	limiters  map[string]*rate.Limiter
+	perMinute int
+	key       func(r *http.Request) string
+}
 
-func getLimiter(ip string) *rate.Limiter {
-	mutex.Lock()
-	defer mutex.Unlock()
+func newClientRateLimiter(perMinute int, key func(r *http.Request) string) *clientRateLimiter {
+	return &clientRateLimiter{limiters: make(map[string]*rate.Limiter), perMinute: perMinute, key: key}
+}
 
-	limiter, ok := ips[ip]
+func (l *clientRateLimiter) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[key]
 	if !ok {
-		limiter = rate.NewLimiter(rate.Limit(100.0/60.0), 100) // 100 requests per minute
-		ips[ip] = limiter
+		limiter = rate.NewLimiter(rate.Limit(float64(l.perMinute)/60.0), l.perMinute)
+		l.limiters[key] = limiter
 	}
 
 	return limiter
 }
 
-func RateLimitMiddleware(next http.Handler) http.Handler {
+func (l *clientRateLimiter) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		limiter := getLimiter(ip)
-		if !limiter.Allow() {
+		if !l.get(l.key(r)).Allow() {
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+var (
+	ingestLimiter = newClientRateLimiter(100, func(r *http.Request) string { return r.RemoteAddr }) // 100 requests per minute
+	// Sign-in, sign-up and reset endpoints; failed logins are also locked out per account
+	authLimiter = newClientRateLimiter(20, ClientIP)
+)
+
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return ingestLimiter.middleware(next)
+}
+
+// AuthRateLimitMiddleware limits the public authentication endpoints per client IP
+func AuthRateLimitMiddleware(next http.Handler) http.Handler {
+	return authLimiter.middleware(next)
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	limiter := newClientRateLimiter(3, ClientIP)
+	handler := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) int {
+		req := httptest.NewRequest("POST", "/api/auth/login", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Different source ports of the same client share a bucket
+	for i, addr := range []string{"10.0.0.1:1000", "10.0.0.1:1001", "10.0.0.1:1002"} {
+		if code := request(addr); code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, code)
+		}
+	}
+	if code := request("10.0.0.1:1003"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once the burst is used, got %d", code)
+	}
+	if code := request("10.0.0.2:1000"); code != http.StatusOK {
+		t.Errorf("expected another client to be unaffected, got %d", code)
+	}
+}
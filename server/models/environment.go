@@ -3,15 +3,17 @@ package models
 import "time"
 
 type Environment struct {
-	ID          int                 `json:"id"`
-	ProjectID   int                 `json:"project_id"`
-	Name        string              `json:"name"`
-	Description *string             `json:"description"`
-	APIKey      string              `json:"api_key"` // Keep APIKey for ingestion
-	Settings    EnvironmentSettings `json:"settings"`
-	IsActive    bool                `json:"is_active"`
-	CreatedAt   time.Time           `json:"created_at"`
-	UpdatedAt   *time.Time          `json:"updated_at"`
+	ID          int     `json:"id"`
+	ProjectID   int     `json:"project_id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	APIKey      string  `json:"api_key"` // Newest usable ingestion key; see EnvironmentKey for all of them
+	// Browser origins allowed to use keys that don't list their own
+	AllowedOrigins []string            `json:"allowed_origins"`
+	Settings       EnvironmentSettings `json:"settings"`
+	IsActive       bool                `json:"is_active"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      *time.Time          `json:"updated_at"`
 }
 
 type EnvironmentSettings struct {
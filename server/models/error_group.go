@@ -3,22 +3,52 @@ package models
 import "time"
 
 type ErrorGroup struct {
-	ID                int        `json:"id"`
-	ProjectID         int        `json:"project_id"`
-	EnvironmentID     int        `json:"environment_id"`
-	Fingerprint       string     `json:"fingerprint"`
-	Message           string     `json:"message"`
-	Stack             *string    `json:"stack,omitempty"`
-	URL               *string    `json:"url,omitempty"`
-	Source            string     `json:"source"`
-	Level             string     `json:"level"`
-	FirstSeen         time.Time  `json:"first_seen"`
-	LastSeen          time.Time  `json:"last_seen"`
-	OccurrenceCount   int        `json:"occurrence_count"`
-	Status            string     `json:"status"`
-	ResolvedAt        *time.Time `json:"resolved_at,omitempty"`
-	ResolvedBy        *int       `json:"resolved_by,omitempty"`
+	ID              int        `json:"id"`
+	ProjectID       int        `json:"project_id"`
+	EnvironmentID   int        `json:"environment_id"`
+	Fingerprint     string     `json:"fingerprint"`
+	Message         string     `json:"message"`
+	Stack           *string    `json:"stack,omitempty"`
+	URL             *string    `json:"url,omitempty"`
+	Source          string     `json:"source"`
+	Level           string     `json:"level"`
+	FirstSeen       time.Time  `json:"first_seen"`
+	LastSeen        time.Time  `json:"last_seen"`
+	OccurrenceCount int        `json:"occurrence_count"`
+	Status          string     `json:"status"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy      *int       `json:"resolved_by,omitempty"`
+	AssignedTo      *int       `json:"assigned_to,omitempty"`
+	AssignedAt      *time.Time `json:"assigned_at,omitempty"`
+	SnoozedUntil    *time.Time `json:"snoozed_until,omitempty"`
+	SnoozedAt       *time.Time `json:"snoozed_at,omitempty"`
+	// SnoozeUntilOccurrences is the occurrence count at which a snoozed group reopens
+	SnoozeUntilOccurrences *int `json:"snooze_until_occurrences,omitempty"`
+	// SnoozeUserLimit is the number of distinct affected users since SnoozedAt at which it reopens
+	SnoozeUserLimit   *int       `json:"snooze_user_limit,omitempty"`
 	LastNotifiedAt    *time.Time `json:"last_notified_at,omitempty"`
 	NotificationCount int        `json:"notification_count"`
+	NotificationStep  int        `json:"notification_step"`
+	StreakStartedAt   *time.Time `json:"streak_started_at,omitempty"`
+	NotifyPendingAt   *time.Time `json:"notify_pending_at,omitempty"`
+	EscalatedAt       *time.Time `json:"escalated_at,omitempty"`
 	CreatedAt         time.Time  `json:"created_at"`
 }
+
+// SnoozeEnded reports whether any of a snoozed group's wake conditions is met, given
+// its occurrence count and distinct affected users since it was snoozed
+func (g *ErrorGroup) SnoozeEnded(now time.Time, occurrenceCount, affectedUsers int) bool {
+	if g.Status != "snoozed" {
+		return false
+	}
+	if g.SnoozedUntil != nil && !now.Before(*g.SnoozedUntil) {
+		return true
+	}
+	if g.SnoozeUntilOccurrences != nil && occurrenceCount >= *g.SnoozeUntilOccurrences {
+		return true
+	}
+	if g.SnoozeUserLimit != nil && affectedUsers >= *g.SnoozeUserLimit {
+		return true
+	}
+	return false
+}
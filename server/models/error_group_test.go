@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorGroupSnoozeEnded(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	occurrences, users := 110, 5
+
+	tests := []struct {
+		name        string
+		group       ErrorGroup
+		occurrences int
+		users       int
+		want        bool
+	}{
+		{"not snoozed", ErrorGroup{Status: "ignored", SnoozedUntil: &past}, 0, 0, false},
+		{"time not reached", ErrorGroup{Status: "snoozed", SnoozedUntil: &future}, 0, 0, false},
+		{"time reached", ErrorGroup{Status: "snoozed", SnoozedUntil: &past}, 0, 0, true},
+		{"occurrences not reached", ErrorGroup{Status: "snoozed", SnoozeUntilOccurrences: &occurrences}, 109, 0, false},
+		{"occurrences reached", ErrorGroup{Status: "snoozed", SnoozeUntilOccurrences: &occurrences}, 110, 0, true},
+		{"users not reached", ErrorGroup{Status: "snoozed", SnoozeUserLimit: &users}, 500, 4, false},
+		{"users reached", ErrorGroup{Status: "snoozed", SnoozeUserLimit: &users}, 500, 5, true},
+		{"first condition wins", ErrorGroup{Status: "snoozed", SnoozedUntil: &future, SnoozeUserLimit: &users}, 1, 5, true},
+		{"no conditions", ErrorGroup{Status: "snoozed"}, 1000, 1000, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.group.SnoozeEnded(now, tt.occurrences, tt.users); got != tt.want {
+			t.Errorf("%s: SnoozeEnded() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,22 +1,48 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MaskedSecret replaces stored secrets in API responses
+const MaskedSecret = "********"
 
 type NotificationSettings struct {
 	Telegram TelegramNotification `json:"telegram"`
 }
 
 type TelegramNotification struct {
-	Enabled  bool                 `json:"enabled"`
-	BotToken string               `json:"bot_token"`
-	ChatID   string               `json:"chat_id"`
-	Triggers NotificationTriggers `json:"triggers"`
+	Enabled  bool   `json:"enabled"`
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+	// Interactive adds Resolve/Ignore/Snooze/Assign buttons to alerts
+	Interactive bool                 `json:"interactive"`
+	Triggers    NotificationTriggers `json:"triggers"`
+	Policy      DeliveryPolicy       `json:"policy"`
+	Escalation  EscalationPolicy     `json:"escalation"`
+	Digest      DigestSettings       `json:"digest"`
+	Templates   TelegramTemplates    `json:"templates"`
+}
+
+// TelegramTemplates overrides the built-in alert templates (Go text/template); empty fields use the defaults
+type TelegramTemplates struct {
+	// ParseMode is "MarkdownV2" (default) or "HTML"
+	ParseMode  string `json:"parse_mode"`
+	Error      string `json:"error"`
+	Spike      string `json:"spike"`
+	Escalation string `json:"escalation"`
+	Regression string `json:"regression"`
+	Digest     string `json:"digest"`
 }
 
 type NotificationTriggers struct {
 	NewError       bool             `json:"new_error"`
 	Threshold      ThresholdTrigger `json:"threshold"`
 	SpikeOnIgnored bool             `json:"spike_on_ignored"`
+	// SnoozeEnded alerts when a new occurrence wakes a snoozed group
+	SnoozeEnded bool `json:"snooze_ended"`
 }
 
 type ThresholdTrigger struct {
@@ -40,3 +66,144 @@ func (t ThresholdTrigger) GetWindowMinutes() int {
 	v, _ := t.WindowMinutes.Int64()
 	return int(v)
 }
+
+// DefaultBackoffMinutes is used when a channel has no explicit back-off schedule
+var DefaultBackoffMinutes = []int{1, 5, 30, 120}
+
+// DeliveryPolicy controls how often a single channel may be notified about the same error group
+type DeliveryPolicy struct {
+	DebounceMinutes json.Number   `json:"debounce_minutes"`
+	BackoffMinutes  []json.Number `json:"backoff_minutes"`
+	QuietHours      QuietHours    `json:"quiet_hours"`
+}
+
+// WindowFor returns how long to wait after the given number of notifications
+// already sent in the current streak. The debounce window is a floor for every step.
+func (p DeliveryPolicy) WindowFor(sent int) time.Duration {
+	if sent <= 0 {
+		return 0
+	}
+
+	schedule := DefaultBackoffMinutes
+	if len(p.BackoffMinutes) > 0 {
+		schedule = make([]int, 0, len(p.BackoffMinutes))
+		for _, n := range p.BackoffMinutes {
+			v, _ := n.Int64()
+			schedule = append(schedule, int(v))
+		}
+	}
+
+	step := sent - 1
+	if step >= len(schedule) {
+		step = len(schedule) - 1
+	}
+	minutes := schedule[step]
+
+	if p.DebounceMinutes != "" {
+		debounce, _ := p.DebounceMinutes.Int64()
+		if int(debounce) > minutes {
+			minutes = int(debounce)
+		}
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// QuietHours suppresses delivery during a daily window in the given timezone.
+// Start and End are "HH:MM"; a window where End is before Start wraps past midnight.
+type QuietHours struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+func (q QuietHours) Validate() error {
+	if !q.Enabled {
+		return nil
+	}
+	if _, err := parseClock(q.Start); err != nil {
+		return fmt.Errorf("quiet_hours.start: %w", err)
+	}
+	if _, err := parseClock(q.End); err != nil {
+		return fmt.Errorf("quiet_hours.end: %w", err)
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return fmt.Errorf("quiet_hours.timezone: %w", err)
+	}
+	return nil
+}
+
+// Active reports whether t falls inside the quiet window
+func (q QuietHours) Active(t time.Time) bool {
+	if !q.Enabled {
+		return false
+	}
+
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return false
+	}
+
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+
+	if start == end {
+		return false
+	}
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// EscalationPolicy notifies a second channel when a group stays unresolved
+// and keeps firing for longer than AfterMinutes after the first alert.
+// Escalations bypass quiet hours.
+type EscalationPolicy struct {
+	Enabled      bool        `json:"enabled"`
+	AfterMinutes json.Number `json:"after_minutes"`
+	BotToken     string      `json:"bot_token"`
+	ChatID       string      `json:"chat_id"`
+}
+
+func (e EscalationPolicy) GetAfterMinutes() int {
+	if e.AfterMinutes == "" {
+		return 0
+	}
+	v, _ := e.AfterMinutes.Int64()
+	return int(v)
+}
+
+// DigestSettings sends a periodic summary to the channel
+type DigestSettings struct {
+	Enabled       bool        `json:"enabled"`
+	IntervalHours json.Number `json:"interval_hours"`
+}
+
+func (d DigestSettings) GetIntervalHours() int {
+	if d.IntervalHours == "" {
+		return 24
+	}
+	v, _ := d.IntervalHours.Int64()
+	if v <= 0 {
+		return 24
+	}
+	return int(v)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDeliveryPolicyWindowFor(t *testing.T) {
+	policy := DeliveryPolicy{}
+
+	expected := []time.Duration{0, time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour, 2 * time.Hour}
+	for sent, want := range expected {
+		if got := policy.WindowFor(sent); got != want {
+			t.Errorf("WindowFor(%d) = %v, expected %v", sent, got, want)
+		}
+	}
+
+	policy = DeliveryPolicy{
+		DebounceMinutes: json.Number("10"),
+		BackoffMinutes:  []json.Number{"2", "20"},
+	}
+	if got := policy.WindowFor(1); got != 10*time.Minute {
+		t.Errorf("Expected debounce floor of 10m, got %v", got)
+	}
+	if got := policy.WindowFor(5); got != 20*time.Minute {
+		t.Errorf("Expected last back-off step of 20m, got %v", got)
+	}
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	q := QuietHours{Enabled: true, Start: "22:00", End: "07:00", Timezone: "UTC"}
+
+	cases := map[string]bool{
+		"2024-01-01T21:59:00Z": false,
+		"2024-01-01T22:00:00Z": true,
+		"2024-01-02T03:30:00Z": true,
+		"2024-01-02T07:00:00Z": false,
+		"2024-01-02T12:00:00Z": false,
+	}
+	for ts, want := range cases {
+		at, _ := time.Parse(time.RFC3339, ts)
+		if got := q.Active(at); got != want {
+			t.Errorf("Active(%s) = %v, expected %v", ts, got, want)
+		}
+	}
+
+	q.Enabled = false
+	at, _ := time.Parse(time.RFC3339, "2024-01-02T03:30:00Z")
+	if q.Active(at) {
+		t.Error("Disabled quiet hours should never be active")
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	if err := (QuietHours{Enabled: true, Start: "9am", End: "17:00", Timezone: "UTC"}).Validate(); err == nil {
+		t.Error("Expected error for invalid start time")
+	}
+	if err := (QuietHours{Enabled: true, Start: "09:00", End: "17:00", Timezone: "Mars/Olympus"}).Validate(); err == nil {
+		t.Error("Expected error for unknown timezone")
+	}
+	if err := (QuietHours{Enabled: true, Start: "09:00", End: "17:00", Timezone: "Europe/Berlin"}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
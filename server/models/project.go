@@ -7,4 +7,9 @@ type Project struct {
 	Name      string    `json:"name"`
 	OwnerID   int       `json:"owner_id"`
 	CreatedAt time.Time `json:"created_at"`
+	// Require2FA blocks members without two-factor authentication from the project
+	Require2FA bool `json:"require_2fa"`
+	// DeletedAt is set when the project is soft-deleted; it is purged after PurgeAfter
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	PurgeAfter *time.Time `json:"purge_after,omitempty"`
 }
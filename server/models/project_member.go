@@ -2,9 +2,151 @@ package models
 
 import "time"
 
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+	RoleNotifier  = "notifier"
+
+	// RoleNone is only valid as an environment override and hides the environment from the member
+	RoleNone = "none"
+)
+
+// Permission is an action a role may take on a project
+type Permission string
+
+const (
+	PermProjectView         Permission = "project:view"
+	PermProjectManage       Permission = "project:manage"
+	PermErrorsTriage        Permission = "errors:triage"
+	PermErrorsDelete        Permission = "errors:delete"
+	PermEnvironmentsManage  Permission = "environments:manage"
+	PermNotificationsManage Permission = "notifications:manage"
+	PermMembersManage       Permission = "members:manage"
+	PermAuditView           Permission = "audit:view"
+	// Deleting, restoring and handing over a project are reserved for its owner
+	PermProjectDelete   Permission = "project:delete"
+	PermProjectTransfer Permission = "project:transfer"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermProjectView, PermProjectManage, PermErrorsTriage, PermErrorsDelete,
+		PermEnvironmentsManage, PermNotificationsManage, PermMembersManage, PermAuditView,
+		PermProjectDelete, PermProjectTransfer,
+	},
+	RoleAdmin: {
+		PermProjectView, PermProjectManage, PermErrorsTriage, PermErrorsDelete,
+		PermEnvironmentsManage, PermNotificationsManage, PermMembersManage, PermAuditView,
+	},
+	RoleDeveloper: {PermProjectView, PermErrorsTriage},
+	RoleNotifier:  {PermProjectView, PermNotificationsManage},
+	RoleViewer:    {PermProjectView},
+}
+
+// Personal access token scopes; a token can only use permissions that both its scopes and
+// its owner's role grant
+const (
+	ScopeErrorsRead         = "errors:read"
+	ScopeErrorsTriage       = "errors:triage"
+	ScopeEnvironmentsManage = "environments:manage"
+)
+
+var scopePermissions = map[string][]Permission{
+	ScopeErrorsRead:         {PermProjectView},
+	ScopeErrorsTriage:       {PermProjectView, PermErrorsTriage},
+	ScopeEnvironmentsManage: {PermProjectView, PermEnvironmentsManage},
+}
+
+// IsValidScope reports whether s is a known token scope
+func IsValidScope(s string) bool {
+	return scopePermissions[s] != nil
+}
+
+// ScopesAllow reports whether any of the scopes grants perm
+func ScopesAllow(scopes []string, perm Permission) bool {
+	for _, s := range scopes {
+		for _, p := range scopePermissions[s] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoleHasPermission reports whether the role's permission matrix includes perm
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAssignableRole reports whether a member can be given the role directly; ownership only
+// changes hands through a transfer
+func IsAssignableRole(role string) bool {
+	return role != RoleOwner && rolePermissions[role] != nil
+}
+
+// IsEnvironmentRole reports whether the role is valid as a per-environment override
+func IsEnvironmentRole(role string) bool {
+	return role == RoleNone || IsAssignableRole(role)
+}
+
 type ProjectMember struct {
-	ProjectID int       `json:"project_id"`
-	UserID    int       `json:"user_id"`
-	Role      string    `json:"role"` // 'admin' or 'member'
-	CreatedAt time.Time `json:"created_at"`
+	ProjectID        int            `json:"project_id"`
+	UserID           int            `json:"user_id"`
+	Role             string         `json:"role"`
+	EnvironmentRoles map[int]string `json:"environment_roles,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
+
+// ProjectAccess is a member's project role plus any per-environment overrides
+type ProjectAccess struct {
+	Role             string
+	EnvironmentRoles map[int]string
+	// ProjectDeleted is set while the project is pending deletion
+	ProjectDeleted bool
+	// TwoFactorMissing is set when the project requires 2FA and the member hasn't enabled it
+	TwoFactorMissing bool
+	// Scopes limits requests made with a personal access token; nil for dashboard sessions
+	Scopes []string
+}
+
+func (a *ProjectAccess) scopeAllows(perm Permission) bool {
+	return a.Scopes == nil || ScopesAllow(a.Scopes, perm)
+}
+
+// RoleIn returns the member's effective role in an environment
+func (a *ProjectAccess) RoleIn(environmentID int) string {
+	if role, ok := a.EnvironmentRoles[environmentID]; ok {
+		return role
+	}
+	return a.Role
+}
+
+// Can reports whether the project role (and token scopes, if any) grant perm
+func (a *ProjectAccess) Can(perm Permission) bool {
+	return RoleHasPermission(a.Role, perm) && a.scopeAllows(perm)
+}
+
+// CanIn reports whether the effective role in an environment grants perm
+func (a *ProjectAccess) CanIn(environmentID int, perm Permission) bool {
+	return RoleHasPermission(a.RoleIn(environmentID), perm) && a.scopeAllows(perm)
+}
+
+// DeniedEnvironments lists environments whose override withholds perm; other environments
+// follow the project role
+func (a *ProjectAccess) DeniedEnvironments(perm Permission) []int {
+	denied := []int{}
+	for envID, role := range a.EnvironmentRoles {
+		if !RoleHasPermission(role, perm) {
+			denied = append(denied, envID)
+		}
+	}
+	return denied
 }
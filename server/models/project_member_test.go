@@ -0,0 +1,92 @@
+package models
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role    string
+		perm    Permission
+		allowed bool
+	}{
+		{RoleOwner, PermMembersManage, true},
+		{RoleAdmin, PermEnvironmentsManage, true},
+		{RoleOwner, PermProjectDelete, true},
+		{RoleAdmin, PermProjectDelete, false},
+		{RoleAdmin, PermProjectTransfer, false},
+		{RoleAdmin, PermAuditView, true},
+		{RoleDeveloper, PermAuditView, false},
+		{RoleDeveloper, PermErrorsTriage, true},
+		{RoleDeveloper, PermErrorsDelete, false},
+		{RoleDeveloper, PermEnvironmentsManage, false},
+		{RoleNotifier, PermNotificationsManage, true},
+		{RoleNotifier, PermErrorsTriage, false},
+		{RoleViewer, PermProjectView, true},
+		{RoleViewer, PermErrorsTriage, false},
+		{RoleNone, PermProjectView, false},
+		{"member", PermProjectView, false},
+	}
+
+	for _, tt := range tests {
+		if got := RoleHasPermission(tt.role, tt.perm); got != tt.allowed {
+			t.Errorf("RoleHasPermission(%q, %q) = %v, want %v", tt.role, tt.perm, got, tt.allowed)
+		}
+	}
+}
+
+func TestProjectAccessEnvironmentOverrides(t *testing.T) {
+	access := &ProjectAccess{
+		Role:             RoleDeveloper,
+		EnvironmentRoles: map[int]string{1: RoleNone, 2: RoleViewer, 3: RoleAdmin},
+	}
+
+	if access.CanIn(1, PermProjectView) {
+		t.Error("expected environment 1 to be hidden")
+	}
+	if access.CanIn(2, PermErrorsTriage) || !access.CanIn(2, PermProjectView) {
+		t.Error("expected read-only access to environment 2")
+	}
+	if !access.CanIn(3, PermEnvironmentsManage) {
+		t.Error("expected admin access to environment 3")
+	}
+	if !access.CanIn(4, PermErrorsTriage) || access.CanIn(4, PermErrorsDelete) {
+		t.Error("expected the project role to apply to environment 4")
+	}
+
+	denied := access.DeniedEnvironments(PermErrorsTriage)
+	if len(denied) != 2 {
+		t.Errorf("expected environments 1 and 2 to deny triage, got %v", denied)
+	}
+}
+
+func TestAssignableRoles(t *testing.T) {
+	if IsAssignableRole(RoleOwner) || !IsAssignableRole(RoleNotifier) || IsAssignableRole("member") {
+		t.Error("unexpected assignable roles")
+	}
+	if !IsEnvironmentRole(RoleNone) || IsEnvironmentRole(RoleOwner) {
+		t.Error("unexpected environment override roles")
+	}
+}
+
+func TestProjectAccessTokenScopes(t *testing.T) {
+	access := &ProjectAccess{Role: RoleAdmin, Scopes: []string{ScopeErrorsTriage}}
+
+	if !access.Can(PermErrorsTriage) || !access.Can(PermProjectView) {
+		t.Error("expected the triage scope to allow viewing and triage")
+	}
+	if access.Can(PermErrorsDelete) || access.Can(PermMembersManage) {
+		t.Error("expected permissions outside the scopes to be denied")
+	}
+
+	// Scopes never widen the owner's role
+	viewer := &ProjectAccess{Role: RoleViewer, Scopes: []string{ScopeEnvironmentsManage}}
+	if viewer.Can(PermEnvironmentsManage) {
+		t.Error("expected a viewer's token to be denied environment management")
+	}
+	if viewer.CanIn(1, PermEnvironmentsManage) {
+		t.Error("expected scopes to apply within environments too")
+	}
+
+	if !IsValidScope(ScopeErrorsRead) || IsValidScope("members:manage") {
+		t.Error("unexpected valid scopes")
+	}
+}
@@ -0,0 +1,208 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/database"
+	"github.com/prabalesh/vigileye/handlers"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+)
+
+// route is a project-scoped endpoint; path is relative to /api/projects/{id}
+type route struct {
+	method     string
+	path       string
+	handler    http.HandlerFunc
+	permission models.Permission
+}
+
+// auditActions names the audit log action of every project route that isn't a GET, keyed by
+// method and path. An empty action marks a route that changes nothing.
+var auditActions = map[string]string{
+	"PATCH ":         "project.update",
+	"DELETE ":        "project.delete",
+	"POST /restore":  "project.restore",
+	"POST /transfer": "project.transfer",
+	"PUT /ownership": "ownership.update",
+
+	"POST /members": "member.invite",
+	"POST /members/invitations/{invitation_id:[0-9]+}/resend":       "invitation.resend",
+	"DELETE /members/invitations/{invitation_id:[0-9]+}":            "invitation.revoke",
+	"PATCH /members/{user_id:[0-9]+}":                               "member.update_role",
+	"DELETE /members/{user_id:[0-9]+}":                              "member.remove",
+	"PUT /members/{user_id:[0-9]+}/environments/{env_id:[0-9]+}":    "member.set_environment_role",
+	"DELETE /members/{user_id:[0-9]+}/environments/{env_id:[0-9]+}": "member.remove_environment_role",
+
+	"POST /environments":                                             "environment.create",
+	"PATCH /environments/{env_id:[0-9]+}":                            "environment.update",
+	"PUT /environments/{env_id:[0-9]+}/notifications":                "environment.update_notifications",
+	"DELETE /environments/{env_id:[0-9]+}":                           "environment.delete",
+	"POST /environments/{env_id:[0-9]+}/regenerate-key":              "environment.regenerate_key",
+	"POST /environments/{env_id:[0-9]+}/keys":                        "api_key.create",
+	"PUT /environments/{env_id:[0-9]+}/keys/{key_id:[0-9]+}":         "api_key.update",
+	"POST /environments/{env_id:[0-9]+}/keys/{key_id:[0-9]+}/rotate": "api_key.rotate",
+	"DELETE /environments/{env_id:[0-9]+}/keys/{key_id:[0-9]+}":      "api_key.revoke",
+	"POST /environments/{env_id:[0-9]+}/notifications/test":          "notification.test",
+	"POST /environments/{env_id:[0-9]+}/notifications/preview":       "",
+	"POST /telegram/chat-link-code":                                  "telegram_chat.link_code",
+	"DELETE /telegram/chats/{chat_id:-?[0-9]+}":                      "telegram_chat.unlink",
+
+	"POST /error-groups/bulk":                       "error_group.bulk_update",
+	"PATCH /error-groups/{group_id:[0-9]+}/resolve": "error_group.resolve",
+	"PATCH /error-groups/{group_id:[0-9]+}/ignore":  "error_group.ignore",
+	"PATCH /error-groups/{group_id:[0-9]+}/reopen":  "error_group.reopen",
+	"PATCH /error-groups/{group_id:[0-9]+}/snooze":  "error_group.snooze",
+	"PATCH /error-groups/{group_id:[0-9]+}/assign":  "error_group.assign",
+	"POST /error-groups/{group_id:[0-9]+}/merge":    "error_group.merge",
+	"POST /error-groups/{group_id:[0-9]+}/unmerge":  "error_group.unmerge",
+	"POST /error-groups/{group_id:[0-9]+}/comments": "error_group.comment",
+	"PATCH /errors/{error_id:[0-9]+}/resolve":       "error.resolve",
+}
+
+func projectRoutes(notifHandler *handlers.NotificationHandler) []route {
+	return []route{
+		{"GET", "", handlers.GetProject, models.PermProjectView},
+		{"PATCH", "", handlers.UpdateProject, models.PermProjectManage},
+		{"DELETE", "", handlers.DeleteProject, models.PermProjectDelete},
+		{"POST", "/restore", handlers.RestoreProject, models.PermProjectDelete},
+		{"POST", "/transfer", handlers.TransferProject, models.PermProjectTransfer},
+
+		{"GET", "/members", handlers.GetProjectMembers, models.PermProjectView},
+		{"GET", "/members/check-last-admin/{user_id:[0-9]+}", handlers.CheckLastAdmin, models.PermProjectView},
+		{"POST", "/members", handlers.InviteMember, models.PermMembersManage},
+		{"GET", "/members/invitations", handlers.GetInvitations, models.PermMembersManage},
+		{"POST", "/members/invitations/{invitation_id:[0-9]+}/resend", handlers.ResendInvitation, models.PermMembersManage},
+		{"DELETE", "/members/invitations/{invitation_id:[0-9]+}", handlers.RevokeInvitation, models.PermMembersManage},
+		{"PATCH", "/members/{user_id:[0-9]+}", handlers.UpdateMemberRole, models.PermMembersManage},
+		{"DELETE", "/members/{user_id:[0-9]+}", handlers.RemoveMember, models.PermMembersManage},
+		{"PUT", "/members/{user_id:[0-9]+}/environments/{env_id:[0-9]+}", handlers.SetMemberEnvironmentRole, models.PermMembersManage},
+		{"DELETE", "/members/{user_id:[0-9]+}/environments/{env_id:[0-9]+}", handlers.DeleteMemberEnvironmentRole, models.PermMembersManage},
+
+		{"GET", "/environments", handlers.GetEnvironments, models.PermProjectView},
+		{"POST", "/environments", handlers.CreateEnvironment, models.PermEnvironmentsManage},
+		{"GET", "/environments/{env_id:[0-9]+}", handlers.GetEnvironment, models.PermProjectView},
+		{"PATCH", "/environments/{env_id:[0-9]+}", handlers.UpdateEnvironment, models.PermEnvironmentsManage},
+		{"DELETE", "/environments/{env_id:[0-9]+}", handlers.DeleteEnvironment, models.PermEnvironmentsManage},
+		{"POST", "/environments/{env_id:[0-9]+}/regenerate-key", handlers.RegenerateEnvironmentKey, models.PermEnvironmentsManage},
+		{"GET", "/environments/{env_id:[0-9]+}/keys", handlers.GetEnvironmentKeys, models.PermProjectView},
+		{"POST", "/environments/{env_id:[0-9]+}/keys", handlers.CreateEnvironmentKey, models.PermEnvironmentsManage},
+		{"PUT", "/environments/{env_id:[0-9]+}/keys/{key_id:[0-9]+}", handlers.UpdateEnvironmentKey, models.PermEnvironmentsManage},
+		{"POST", "/environments/{env_id:[0-9]+}/keys/{key_id:[0-9]+}/rotate", handlers.RotateEnvironmentKey, models.PermEnvironmentsManage},
+		{"DELETE", "/environments/{env_id:[0-9]+}/keys/{key_id:[0-9]+}", handlers.RevokeEnvironmentKey, models.PermEnvironmentsManage},
+
+		{"PUT", "/environments/{env_id:[0-9]+}/notifications", handlers.UpdateEnvironmentNotifications, models.PermNotificationsManage},
+		{"POST", "/environments/{env_id:[0-9]+}/notifications/test", notifHandler.TestTelegramNotification, models.PermNotificationsManage},
+		{"GET", "/environments/{env_id:[0-9]+}/notifications/history", notifHandler.GetNotificationHistory, models.PermNotificationsManage},
+		{"POST", "/environments/{env_id:[0-9]+}/notifications/preview", notifHandler.PreviewNotificationTemplate, models.PermNotificationsManage},
+
+		{"POST", "/telegram/chat-link-code", handlers.CreateTelegramChatLinkCode, models.PermNotificationsManage},
+		{"GET", "/telegram/chats", handlers.GetTelegramChats, models.PermNotificationsManage},
+		{"DELETE", "/telegram/chats/{chat_id:-?[0-9]+}", handlers.DeleteTelegramChat, models.PermNotificationsManage},
+
+		{"GET", "/error-groups", handlers.GetErrorGroups, models.PermProjectView},
+		{"POST", "/error-groups/bulk", handlers.BulkUpdateErrorGroups, models.PermErrorsTriage},
+		{"GET", "/error-groups/{group_id:[0-9]+}", handlers.GetErrorGroupDetail, models.PermProjectView},
+		{"GET", "/error-groups/{group_id:[0-9]+}/occurrences", handlers.GetErrorGroupOccurrences, models.PermProjectView},
+		{"PATCH", "/error-groups/{group_id:[0-9]+}/resolve", handlers.ResolveErrorGroup, models.PermErrorsTriage},
+		{"PATCH", "/error-groups/{group_id:[0-9]+}/ignore", handlers.IgnoreErrorGroup, models.PermErrorsTriage},
+		{"PATCH", "/error-groups/{group_id:[0-9]+}/reopen", handlers.ReopenErrorGroup, models.PermErrorsTriage},
+		{"PATCH", "/error-groups/{group_id:[0-9]+}/snooze", handlers.SnoozeErrorGroup, models.PermErrorsTriage},
+		{"PATCH", "/error-groups/{group_id:[0-9]+}/assign", handlers.AssignErrorGroup, models.PermErrorsTriage},
+		{"POST", "/error-groups/{group_id:[0-9]+}/merge", handlers.MergeErrorGroups, models.PermErrorsTriage},
+		{"POST", "/error-groups/{group_id:[0-9]+}/unmerge", handlers.UnmergeErrorGroup, models.PermErrorsTriage},
+		{"GET", "/error-groups/{group_id:[0-9]+}/fingerprints", handlers.GetErrorGroupFingerprints, models.PermProjectView},
+		{"GET", "/error-groups/{group_id:[0-9]+}/activity", handlers.GetErrorGroupActivity, models.PermProjectView},
+		{"POST", "/error-groups/{group_id:[0-9]+}/comments", handlers.CreateErrorGroupComment, models.PermErrorsTriage},
+
+		{"GET", "/audit-log", handlers.GetAuditLog, models.PermAuditView},
+		{"GET", "/audit-log/export", handlers.ExportAuditLog, models.PermAuditView},
+
+		{"GET", "/ownership", handlers.GetOwnership, models.PermProjectView},
+		{"PUT", "/ownership", handlers.UpdateOwnership, models.PermProjectManage},
+
+		{"GET", "/errors", handlers.GetErrors, models.PermProjectView},
+		{"GET", "/errors/{error_id:[0-9]+}", handlers.GetErrorDetail, models.PermProjectView},
+		{"PATCH", "/errors/{error_id:[0-9]+}/resolve", handlers.ResolveError, models.PermErrorsTriage},
+	}
+}
+
+// New builds the API router. Every route under /api/projects/{id} goes through
+// RequirePermission, so handlers can assume the caller may act on the project.
+func New(cfg config.Config) *mux.Router {
+	r := mux.NewRouter()
+
+	// Public routes
+	auth := r.PathPrefix("/api/auth").Subrouter()
+	auth.Use(middleware.AuthRateLimitMiddleware)
+	auth.HandleFunc("/register", handlers.Register).Methods("POST")
+	auth.HandleFunc("/login", handlers.Login).Methods("POST")
+	auth.HandleFunc("/refresh", handlers.RefreshSession).Methods("POST")
+	auth.HandleFunc("/logout", handlers.Logout).Methods("POST")
+	auth.HandleFunc("/2fa/verify", handlers.VerifyTwoFactor).Methods("POST")
+	auth.HandleFunc("/password/forgot", handlers.ForgotPassword).Methods("POST")
+	auth.HandleFunc("/password/reset", handlers.ResetPassword).Methods("POST")
+	auth.HandleFunc("/verify-email", handlers.VerifyEmail).Methods("POST")
+	auth.HandleFunc("/oidc/login", handlers.OIDCLogin).Methods("GET")
+	auth.HandleFunc("/oidc/callback", handlers.OIDCCallback).Methods("GET")
+	auth.HandleFunc("/invitations/{token}", handlers.LookupInvitation).Methods("GET")
+
+	// API Key Protected routes (Ingestion)
+	// We define this BEFORE the general /api prefix to ensure correct matching
+	logRouter := r.PathPrefix("/api/log").Subrouter()
+	logRouter.Use(middleware.RateLimitMiddleware)
+	logRouter.Use(middleware.APIKeyMiddleware)
+	logRouter.HandleFunc("", handlers.LogError).Methods("POST")
+
+	// Telegram webhook (verified by per-environment secret token)
+	r.HandleFunc("/api/telegram/webhook/{env_id:[0-9]+}", handlers.TelegramWebhook).Methods("POST")
+
+	// Protected routes (JWT - Dashboard)
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+
+	// Personal access tokens may read who they belong to and use project routes within their
+	// scopes; account and membership routes need a dashboard session
+	session := middleware.RequireSession
+
+	api.HandleFunc("/auth/me", handlers.Me).Methods("GET")
+	api.HandleFunc("/auth/me", session(handlers.UpdateProfile)).Methods("PATCH")
+	api.HandleFunc("/auth/me", session(handlers.DeleteAccount)).Methods("DELETE")
+	api.HandleFunc("/auth/password", session(handlers.ChangePassword)).Methods("PUT")
+	api.HandleFunc("/auth/verify-email/resend", session(handlers.ResendVerification)).Methods("POST")
+	api.HandleFunc("/auth/security-events", session(handlers.GetSecurityEvents)).Methods("GET")
+	api.HandleFunc("/auth/logout-all", session(handlers.LogoutAll)).Methods("POST")
+	api.HandleFunc("/auth/sessions", session(handlers.GetSessions)).Methods("GET")
+	api.HandleFunc("/auth/sessions/{session_id}", session(handlers.RevokeSession)).Methods("DELETE")
+	api.HandleFunc("/auth/2fa/setup", session(handlers.SetupTwoFactor)).Methods("POST")
+	api.HandleFunc("/auth/2fa/enable", session(handlers.EnableTwoFactor)).Methods("POST")
+	api.HandleFunc("/auth/2fa/disable", session(handlers.DisableTwoFactor)).Methods("POST")
+	api.HandleFunc("/auth/2fa/recovery-codes", session(handlers.RegenerateRecoveryCodes)).Methods("POST")
+	api.HandleFunc("/invitations/accept", session(handlers.AcceptInvitation)).Methods("POST")
+
+	api.HandleFunc("/tokens", session(handlers.GetPersonalTokens)).Methods("GET")
+	api.HandleFunc("/tokens", session(handlers.CreatePersonalToken)).Methods("POST")
+	api.HandleFunc("/tokens/{token_id:[0-9]+}", session(handlers.RevokePersonalToken)).Methods("DELETE")
+
+	api.HandleFunc("/telegram/link", session(handlers.GetTelegramLink)).Methods("GET")
+	api.HandleFunc("/telegram/link", session(handlers.DeleteTelegramLink)).Methods("DELETE")
+	api.HandleFunc("/telegram/link-code", session(handlers.CreateTelegramLinkCode)).Methods("POST")
+
+	api.HandleFunc("/projects", handlers.GetProjects).Methods("GET")
+	api.HandleFunc("/projects", session(handlers.CreateProject)).Methods("POST")
+
+	notifHandler := handlers.NewNotificationHandler(database.DB, services.NewSecretBox(cfg))
+	project := api.PathPrefix("/projects/{id:[0-9]+}").Subrouter()
+	for _, rt := range projectRoutes(notifHandler) {
+		var handler http.Handler = rt.handler
+		if action := auditActions[rt.method+" "+rt.path]; action != "" {
+			handler = middleware.Audit(action)(handler)
+		}
+		project.Handle(rt.path, middleware.RequirePermission(rt.permission)(handler)).Methods(rt.method)
+	}
+
+	return r
+}
@@ -0,0 +1,261 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/middleware"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/services"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const testSecret = "test-secret-at-least-32-chars-long"
+
+type projectRequest struct {
+	method string
+	url    string
+	route  string
+}
+
+// registeredProjectRoutes walks the router and builds a concrete request for every project route
+func registeredProjectRoutes(t *testing.T, r *mux.Router) []projectRequest {
+	requests := []projectRequest{}
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tpl, "/api/projects/{id") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		u, err := route.URL("id", "1", "env_id", "2", "group_id", "3", "user_id", "4", "error_id", "5", "chat_id", "-6", "invitation_id", "7", "key_id", "8")
+		if err != nil {
+			t.Fatalf("building URL for %s: %v", tpl, err)
+		}
+		for _, m := range methods {
+			requests = append(requests, projectRequest{method: m, url: u.String(), route: m + " " + tpl})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requests
+}
+
+func stubAccess(t *testing.T, access *models.ProjectAccess) {
+	originalAccess, originalEnv, originalSession := middleware.LoadProjectAccess, middleware.ResourceEnvironment, middleware.SessionActive
+	middleware.SessionActive = func(sessionID string, userID int) (bool, error) {
+		return true, nil
+	}
+	middleware.LoadProjectAccess = func(projectID, userID int) (*models.ProjectAccess, error) {
+		if projectID != 1 || userID != 42 {
+			t.Errorf("access loaded for project %d user %d", projectID, userID)
+		}
+		return access, nil
+	}
+	// Every environment-scoped route in these tests targets environment 2
+	middleware.ResourceEnvironment = func(projectID int, vars map[string]string) (int, bool, error) {
+		for _, key := range []string{"env_id", "group_id", "error_id"} {
+			if vars[key] != "" {
+				return 2, true, nil
+			}
+		}
+		return 0, false, nil
+	}
+	t.Cleanup(func() {
+		middleware.LoadProjectAccess, middleware.ResourceEnvironment, middleware.SessionActive = originalAccess, originalEnv, originalSession
+	})
+}
+
+func TestProjectRoutesEnforcePermissions(t *testing.T) {
+	r := New(config.Config{JWTSecret: testSecret, SecretKey: testSecret})
+	token, _ := utils.GenerateJWT(42, "session", time.Minute, testSecret)
+
+	type declared struct {
+		permission models.Permission
+		envScoped  bool
+	}
+	routes := map[string]declared{}
+	for _, rt := range projectRoutes(nil) {
+		scoped := strings.Contains(rt.path, "{env_id") || strings.Contains(rt.path, "{group_id") || strings.Contains(rt.path, "{error_id")
+		routes[rt.method+" /api/projects/{id:[0-9]+}"+rt.path] = declared{rt.permission, scoped}
+	}
+
+	requests := registeredProjectRoutes(t, r)
+	if len(requests) != len(routes) {
+		t.Fatalf("expected %d project routes, found %d registered", len(routes), len(requests))
+	}
+
+	// Each case's denied func returns the status a route must answer with, or 0 if the request
+	// may reach the handler
+	roleDenied := func(role string) func(declared) int {
+		return func(d declared) int {
+			if models.RoleHasPermission(role, d.permission) {
+				return 0
+			}
+			return http.StatusForbidden
+		}
+	}
+
+	tests := []struct {
+		name   string
+		access *models.ProjectAccess
+		denied func(declared) int
+	}{
+		{"non-member", nil, func(declared) int { return http.StatusNotFound }},
+		{"viewer", &models.ProjectAccess{Role: models.RoleViewer}, roleDenied(models.RoleViewer)},
+		{"notifier", &models.ProjectAccess{Role: models.RoleNotifier}, roleDenied(models.RoleNotifier)},
+		{"developer", &models.ProjectAccess{Role: models.RoleDeveloper}, roleDenied(models.RoleDeveloper)},
+		{
+			"developer hidden from environment",
+			&models.ProjectAccess{Role: models.RoleDeveloper, EnvironmentRoles: map[int]string{2: models.RoleNone}},
+			func(d declared) int {
+				if d.envScoped {
+					return http.StatusNotFound
+				}
+				return roleDenied(models.RoleDeveloper)(d)
+			},
+		},
+		{
+			"owner of a project pending deletion",
+			&models.ProjectAccess{Role: models.RoleOwner, ProjectDeleted: true},
+			func(d declared) int {
+				if d.permission == models.PermProjectDelete {
+					return 0
+				}
+				return http.StatusNotFound
+			},
+		},
+		{
+			"admin without required two-factor authentication",
+			&models.ProjectAccess{Role: models.RoleAdmin, TwoFactorMissing: true},
+			func(declared) int { return http.StatusForbidden },
+		},
+		{
+			"viewer promoted in environment",
+			&models.ProjectAccess{Role: models.RoleViewer, EnvironmentRoles: map[int]string{2: models.RoleAdmin}},
+			func(d declared) int {
+				if d.envScoped {
+					return roleDenied(models.RoleAdmin)(d)
+				}
+				return roleDenied(models.RoleViewer)(d)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubAccess(t, tt.access)
+			for _, req := range requests {
+				d, ok := routes[req.route]
+				if !ok {
+					t.Errorf("%s is registered without a declared permission", req.route)
+					continue
+				}
+				want := tt.denied(d)
+				if want == 0 {
+					continue
+				}
+
+				httpReq := httptest.NewRequest(req.method, req.url, nil)
+				httpReq.Header.Set("Authorization", "Bearer "+token)
+				rr := httptest.NewRecorder()
+				r.ServeHTTP(rr, httpReq)
+
+				if rr.Code != want {
+					t.Errorf("%s: expected %d, got %d", req.route, want, rr.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestProjectRoutesRequireAuthentication(t *testing.T) {
+	r := New(config.Config{JWTSecret: testSecret, SecretKey: testSecret})
+	stubAccess(t, &models.ProjectAccess{Role: models.RoleOwner})
+
+	for _, req := range registeredProjectRoutes(t, r) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(req.method, req.url, nil))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 without a token, got %d", req.route, rr.Code)
+		}
+	}
+}
+
+func TestPersonalTokensAreLimitedToTheirScopes(t *testing.T) {
+	r := New(config.Config{JWTSecret: testSecret, SecretKey: testSecret})
+	stubAccess(t, &models.ProjectAccess{Role: models.RoleOwner})
+
+	scopes := []string{models.ScopeErrorsTriage}
+	original := middleware.AuthenticateToken
+	middleware.AuthenticateToken = func(token, ip string) (*services.TokenIdentity, error) {
+		if token != "vgl_pat_test" {
+			return nil, services.ErrInvalidAccessToken
+		}
+		return &services.TokenIdentity{TokenID: 1, UserID: 42, Scopes: scopes}, nil
+	}
+	t.Cleanup(func() { middleware.AuthenticateToken = original })
+
+	permissions := map[string]models.Permission{}
+	for _, rt := range projectRoutes(nil) {
+		permissions[rt.method+" /api/projects/{id:[0-9]+}"+rt.path] = rt.permission
+	}
+
+	serve := func(method, url, token string) int {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, req := range registeredProjectRoutes(t, r) {
+		if models.ScopesAllow(scopes, permissions[req.route]) {
+			continue
+		}
+		if code := serve(req.method, req.url, "vgl_pat_test"); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a token without the scope, got %d", req.route, code)
+		}
+	}
+
+	for _, route := range []string{"GET /api/tokens", "POST /api/tokens", "GET /api/auth/sessions", "POST /api/projects"} {
+		method, url, _ := strings.Cut(route, " ")
+		if code := serve(method, url, "vgl_pat_test"); code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a personal access token, got %d", route, code)
+		}
+	}
+
+	if code := serve("GET", "/api/projects/1/members", "vgl_pat_unknown"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an unknown token, got %d", code)
+	}
+}
+
+func TestMutatingProjectRoutesAreAudited(t *testing.T) {
+	seen := map[string]bool{}
+	for _, rt := range projectRoutes(nil) {
+		key := rt.method + " " + rt.path
+		seen[key] = true
+		_, ok := auditActions[key]
+		if rt.method == "GET" && ok {
+			t.Errorf("%s: GET routes change nothing and shouldn't be audited", key)
+		}
+		if rt.method != "GET" && !ok {
+			t.Errorf("%s: add an audit action to auditActions", key)
+		}
+	}
+	for key := range auditActions {
+		if !seen[key] {
+			t.Errorf("%s: audit action for a route that doesn't exist", key)
+		}
+	}
+}
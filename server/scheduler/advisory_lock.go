@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+)
+
+// AdvisoryLocker holds a session-level Postgres advisory lock on a dedicated
+// connection. The lock is released automatically if the connection dies,
+// letting another replica take over.
+type AdvisoryLocker struct {
+	db   *sql.DB
+	key  int64
+	conn *sql.Conn
+}
+
+func NewAdvisoryLocker(db *sql.DB, key int64) *AdvisoryLocker {
+	return &AdvisoryLocker{db: db, key: key}
+}
+
+func (l *AdvisoryLocker) TryLock(ctx context.Context) (bool, error) {
+	// Already leader: make sure the session holding the lock is still alive
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+func (l *AdvisoryLocker) Unlock(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() {
+		l.conn.Close()
+		l.conn = nil
+	}()
+
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	return err
+}
@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// LeaderLockKey is the Postgres advisory lock key held by the active scheduler replica
+const LeaderLockKey int64 = 0x76696769 // "vigi"
+
+// Job is a periodic task run by the leader replica
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Locker elects a single leader across server replicas
+type Locker interface {
+	TryLock(ctx context.Context) (bool, error)
+	Unlock(ctx context.Context) error
+}
+
+type Scheduler struct {
+	locker  Locker
+	tick    time.Duration
+	jobs    []Job
+	lastRun map[string]time.Time
+	leader  bool
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+func New(db *sql.DB) *Scheduler {
+	return NewWithLocker(NewAdvisoryLocker(db, LeaderLockKey), 15*time.Second)
+}
+
+func NewWithLocker(locker Locker, tick time.Duration) *Scheduler {
+	return &Scheduler{
+		locker:  locker,
+		tick:    tick,
+		lastRun: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Register adds a job; it must be called before Start
+func (s *Scheduler) Register(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+}
+
+// Start runs registered jobs until ctx is cancelled (blocking)
+func (s *Scheduler) Start(ctx context.Context) {
+	log.Printf("⏱️  Scheduler started with %d jobs", len(s.jobs))
+
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			if s.leader {
+				s.locker.Unlock(context.Background())
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context) {
+	leader, err := s.locker.TryLock(ctx)
+	if err != nil {
+		log.Printf("[Scheduler] Leader election error: %v", err)
+		leader = false
+	}
+
+	if leader != s.leader {
+		if leader {
+			log.Printf("[Scheduler] Acquired leadership")
+		} else {
+			log.Printf("[Scheduler] Lost leadership")
+		}
+		s.leader = leader
+	}
+
+	if !leader {
+		return
+	}
+
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		now := s.now()
+		if last, ok := s.lastRun[job.Name]; ok && now.Sub(last) < job.Interval {
+			continue
+		}
+		s.lastRun[job.Name] = now
+
+		start := time.Now()
+		if err := s.runJob(ctx, job); err != nil {
+			log.Printf("[Scheduler] Job %s failed: %v", job.Name, err)
+			continue
+		}
+		log.Printf("[Scheduler] Job %s completed in %s", job.Name, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	jobCtx, cancel := context.WithTimeout(ctx, job.Interval)
+	defer cancel()
+
+	return job.Run(jobCtx)
+}
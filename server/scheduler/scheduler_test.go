@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeLocker struct {
+	leader bool
+}
+
+func (l *fakeLocker) TryLock(ctx context.Context) (bool, error) { return l.leader, nil }
+func (l *fakeLocker) Unlock(ctx context.Context) error          { return nil }
+
+func TestSchedulerRunsJobsOnlyOnLeader(t *testing.T) {
+	locker := &fakeLocker{}
+	s := NewWithLocker(locker, time.Second)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	runs := 0
+	s.Register(Job{Name: "count", Interval: time.Minute, Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}})
+
+	s.runOnce(context.Background())
+	if runs != 0 {
+		t.Fatalf("Expected no runs as follower, got %d", runs)
+	}
+
+	locker.leader = true
+	s.runOnce(context.Background())
+	if runs != 1 {
+		t.Fatalf("Expected 1 run as leader, got %d", runs)
+	}
+
+	// Not due yet
+	now = now.Add(30 * time.Second)
+	s.runOnce(context.Background())
+	if runs != 1 {
+		t.Fatalf("Expected job to wait for its interval, got %d runs", runs)
+	}
+
+	now = now.Add(30 * time.Second)
+	s.runOnce(context.Background())
+	if runs != 2 {
+		t.Fatalf("Expected 2 runs after interval elapsed, got %d", runs)
+	}
+}
+
+func TestSchedulerRecoversFromPanickingJob(t *testing.T) {
+	s := NewWithLocker(&fakeLocker{leader: true}, time.Second)
+
+	ran := false
+	s.Register(Job{Name: "panic", Interval: time.Minute, Run: func(ctx context.Context) error {
+		panic("boom")
+	}})
+	s.Register(Job{Name: "after", Interval: time.Minute, Run: func(ctx context.Context) error {
+		ran = true
+		return nil
+	}})
+
+	s.runOnce(context.Background())
+	if !ran {
+		t.Error("Expected jobs after a panicking job to still run")
+	}
+
+	if err := s.runJob(context.Background(), s.jobs[0]); err == nil {
+		t.Error("Expected a panicking job to be reported as failed")
+	}
+}
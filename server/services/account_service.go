@@ -0,0 +1,348 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const (
+	tokenPurposeVerifyEmail   = "verify_email"
+	tokenPurposePasswordReset = "password_reset"
+	// Another reset or verification email for the same account is only sent after this long
+	accountEmailInterval = time.Minute
+)
+
+var (
+	ErrInvalidAccountToken = errors.New("this link is invalid or has expired")
+	ErrEmailVerified       = errors.New("email address is already verified")
+	ErrWrongPassword       = errors.New("current password is incorrect")
+	ErrOwnsSharedProjects  = errors.New("transfer or delete the projects you own with other members first")
+)
+
+// AccountService handles email verification, password changes and account deletion
+type AccountService struct {
+	db              *sql.DB
+	mailer          Mailer
+	baseURL         string
+	verificationTTL time.Duration
+	resetTTL        time.Duration
+}
+
+func NewAccountService(db *sql.DB, cfg config.Config) *AccountService {
+	return &AccountService{
+		db:              db,
+		mailer:          NewMailer(cfg),
+		baseURL:         strings.TrimRight(cfg.BaseURL, "/"),
+		verificationTTL: time.Duration(cfg.EmailVerificationHours) * time.Hour,
+		resetTTL:        time.Duration(cfg.PasswordResetMinutes) * time.Minute,
+	}
+}
+
+// SendVerification emails a link that confirms the user owns their address
+func (s *AccountService) SendVerification(userID int) error {
+	var email string
+	var verified bool
+	err := s.db.QueryRow(
+		"SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1", userID,
+	).Scan(&email, &verified)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return ErrEmailVerified
+	}
+
+	token, err := s.issue(userID, email, tokenPurposeVerifyEmail, s.verificationTTL)
+	if err != nil || token == "" {
+		return err
+	}
+	body := fmt.Sprintf(
+		"Confirm your email address for Vigil Eye:\n%s/verify-email?token=%s\n\n"+
+			"This link expires in %s. If you didn't create an account, you can ignore this email.\n",
+		s.baseURL, token, formatTTL(s.verificationTTL),
+	)
+	return s.mailer.Send(email, "Confirm your email address", body)
+}
+
+// VerifyEmail redeems a verification link
+func (s *AccountService) VerifyEmail(token string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, email, err := consumeAccountToken(tx, token, tokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $1 AND LOWER(email) = LOWER($2)
+	`, userID, email)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidAccountToken
+	}
+	return tx.Commit()
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to an account. It reports
+// success either way so the endpoint can't be used to find out who has an account.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	var userID int
+	var addr string
+	err := s.db.QueryRow(
+		"SELECT id, email FROM users WHERE LOWER(email) = LOWER($1)", strings.TrimSpace(email),
+	).Scan(&userID, &addr)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issue(userID, addr, tokenPurposePasswordReset, s.resetTTL)
+	if err != nil || token == "" {
+		return err
+	}
+	body := fmt.Sprintf(
+		"Someone asked to reset the password for your Vigil Eye account.\n\n"+
+			"Choose a new password:\n%s/reset-password?token=%s\n\n"+
+			"This link expires in %s and can only be used once. If you didn't ask for it, you can "+
+			"ignore this email; your password won't change.\n",
+		s.baseURL, token, formatTTL(s.resetTTL),
+	)
+	if err := s.mailer.Send(addr, "Reset your password", body); err != nil {
+		log.Printf("[AccountService] Error sending password reset to user %d: %v", userID, err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password from a reset link and signs the user out everywhere. It
+// returns the user whose password was reset.
+func (s *AccountService) ResetPassword(token, passwordHash string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, _, err := consumeAccountToken(tx, token, tokenPurposePasswordReset)
+	if err != nil {
+		return 0, err
+	}
+	// Receiving the link proves the address, too
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $2
+	`, passwordHash, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := revokeCredentials(tx, userID, ""); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// ChangePassword replaces the password after checking the current one. Every other session is
+// signed out; the one making the change stays.
+func (s *AccountService) ChangePassword(userID int, sessionID, current, passwordHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing string
+	if err := tx.QueryRow("SELECT password_hash FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&existing); err != nil {
+		return err
+	}
+	// Accounts created through single sign-on have no password to check; they use a reset link
+	if existing == "" || !utils.CheckPasswordHash(current, existing) {
+		return ErrWrongPassword
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID); err != nil {
+		return err
+	}
+	if err := revokeCredentials(tx, userID, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SharedOwnedProjects lists the names of projects the user owns that have other members
+func (s *AccountService) SharedOwnedProjects(userID int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT p.name FROM projects p
+		WHERE p.owner_id = $1 AND p.deleted_at IS NULL
+		  AND EXISTS (SELECT 1 FROM project_members pm WHERE pm.project_id = p.id AND pm.user_id <> $1)
+		ORDER BY p.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// HasPassword reports whether the user can sign in with a password, as opposed to only through
+// single sign-on
+func (s *AccountService) HasPassword(userID int) (bool, error) {
+	var hasPassword bool
+	err := s.db.QueryRow("SELECT password_hash <> '' FROM users WHERE id = $1", userID).Scan(&hasPassword)
+	return hasPassword, err
+}
+
+// DeleteAccount removes the user. Projects they own alone are scheduled for immediate purge;
+// projects shared with others must be transferred or deleted first. Accounts with a password
+// must confirm it; callers re-authenticate passwordless accounts before.
+func (s *AccountService) DeleteAccount(userID int, password string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing string
+	if err := tx.QueryRow("SELECT password_hash FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&existing); err != nil {
+		return err
+	}
+	if existing != "" && !utils.CheckPasswordHash(password, existing) {
+		return ErrWrongPassword
+	}
+
+	var shared bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM projects p
+			JOIN project_members pm ON pm.project_id = p.id AND pm.user_id <> $1
+			WHERE p.owner_id = $1 AND p.deleted_at IS NULL
+		)
+	`, userID).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return ErrOwnsSharedProjects
+	}
+
+	// Nobody else could restore these, so skip the grace period and let the purge job remove
+	// them with their errors
+	_, err = tx.Exec(`
+		UPDATE projects SET deleted_at = COALESCE(deleted_at, NOW()), purge_after = NOW()
+		WHERE owner_id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// issue creates a single-use token, replacing any outstanding one for the same purpose. It
+// returns "" without error when a token was sent too recently.
+func (s *AccountService) issue(userID int, email, purpose string, ttl time.Duration) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Locking the user serializes concurrent requests for the same account
+	if _, err := tx.Exec("SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return "", err
+	}
+	var recent bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM account_tokens
+			WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL AND created_at > NOW() - make_interval(secs => $3)
+		)
+	`, userID, purpose, accountEmailInterval.Seconds()).Scan(&recent)
+	if err != nil {
+		return "", err
+	}
+	if recent {
+		return "", nil
+	}
+
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2", userID, purpose); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO account_tokens (user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5))
+	`, userID, purpose, utils.HashToken(token), email, ttl.Seconds())
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+func consumeAccountToken(tx *sql.Tx, token, purpose string) (int, string, error) {
+	var userID int
+	var email string
+	err := tx.QueryRow(`
+		UPDATE account_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, email
+	`, utils.HashToken(token), purpose).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrInvalidAccountToken
+	}
+	return userID, email, err
+}
+
+// revokeCredentials signs the user out of every session except keepSessionID and voids any
+// outstanding reset links
+func revokeCredentials(tx *sql.Tx, userID int, keepSessionID string) error {
+	_, err := tx.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE account_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, tokenPurposePasswordReset)
+	return err
+}
+
+func formatTTL(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const (
+	ActivityStatusChanged    = "status_changed"
+	ActivityReopened         = "reopened"
+	ActivityAssigned         = "assigned"
+	ActivitySnoozed          = "snoozed"
+	ActivityNotificationSent = "notification_sent"
+	ActivityMerged           = "merged"
+	ActivityUnmerged         = "unmerged"
+	ActivityComment          = "comment"
+
+	maxCommentLength = 10000
+)
+
+var (
+	ErrEmptyComment  = errors.New("comment body is required (max 10000 characters)")
+	ErrCommentParent = errors.New("parent comment not found on this error group")
+)
+
+// ActivityActor is the user behind an activity entry; nil entries are system events
+type ActivityActor struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Comment struct {
+	ID        int       `json:"id"`
+	ParentID  *int      `json:"parent_id,omitempty"`
+	AuthorID  *int      `json:"author_id,omitempty"`
+	Body      string    `json:"body"`
+	Mentions  []int     `json:"mentions"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Activity struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Actor     *ActivityActor  `json:"actor"`
+	Data      json.RawMessage `json:"data"`
+	Comment   *Comment        `json:"comment,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// RecordActivity appends an entry to a group's timeline
+func RecordActivity(q execer, groupID int, actorID *int, kind string, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO error_group_activity (error_group_id, actor_id, type, data) VALUES ($1, $2, $3, $4)
+	`, groupID, actorID, kind, dataJSON)
+	return err
+}
+
+type ActivityService struct {
+	db *sql.DB
+}
+
+func NewActivityService(db *sql.DB) *ActivityService {
+	return &ActivityService{db: db}
+}
+
+// List returns a group's timeline, oldest first
+func (s *ActivityService) List(projectID, groupID, limit, offset int) ([]Activity, error) {
+	rows, err := s.db.Query(`
+		SELECT a.id, a.type, a.data, a.created_at, u.id, u.name,
+		       c.id, c.parent_id, c.author_id, c.body, c.mentions, c.created_at
+		FROM error_group_activity a
+		JOIN error_groups eg ON eg.id = a.error_group_id
+		LEFT JOIN users u ON u.id = a.actor_id
+		LEFT JOIN error_group_comments c ON c.id = a.comment_id
+		WHERE a.error_group_id = $1 AND eg.project_id = $2
+		ORDER BY a.id
+		LIMIT $3 OFFSET $4
+	`, groupID, projectID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity := []Activity{}
+	for rows.Next() {
+		var a Activity
+		var actorID sql.NullInt64
+		var actorName sql.NullString
+		var commentID sql.NullInt64
+		var comment Comment
+		var body sql.NullString
+		var commentCreatedAt sql.NullTime
+		var mentions pq.Int64Array
+
+		err := rows.Scan(&a.ID, &a.Type, &a.Data, &a.CreatedAt, &actorID, &actorName,
+			&commentID, &comment.ParentID, &comment.AuthorID, &body, &mentions, &commentCreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if actorID.Valid {
+			a.Actor = &ActivityActor{ID: int(actorID.Int64), Name: actorName.String}
+		}
+		if commentID.Valid {
+			comment.ID = int(commentID.Int64)
+			comment.Body = body.String
+			comment.CreatedAt = commentCreatedAt.Time
+			comment.Mentions = make([]int, len(mentions))
+			for i, id := range mentions {
+				comment.Mentions[i] = int(id)
+			}
+			a.Comment = &comment
+		}
+		activity = append(activity, a)
+	}
+	return activity, rows.Err()
+}
+
+// AddComment stores a comment (optionally replying to parentID) and its timeline entry.
+// Mentions are resolved against project members; mentions of non-members are ignored.
+func (s *ActivityService) AddComment(projectID, groupID, authorID int, parentID *int, body string) (*Comment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > maxCommentLength {
+		return nil, ErrEmptyComment
+	}
+
+	emails := utils.ParseMentions(body)
+	members, err := projectMemberEmails(s.db, projectID, emails)
+	if err != nil {
+		return nil, err
+	}
+	mentions := []int{}
+	for _, email := range emails {
+		if id, ok := members[email]; ok {
+			mentions = append(mentions, id)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM error_groups WHERE id = $1 AND project_id = $2)
+	`, groupID, projectID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrGroupNotFound
+	}
+
+	if parentID != nil {
+		if err := tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM error_group_comments WHERE id = $1 AND error_group_id = $2)
+		`, *parentID, groupID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrCommentParent
+		}
+	}
+
+	c := &Comment{ParentID: parentID, AuthorID: &authorID, Body: body, Mentions: mentions}
+	err = tx.QueryRow(`
+		INSERT INTO error_group_comments (error_group_id, parent_id, author_id, body, mentions)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, groupID, parentID, authorID, body, pq.Array(mentions)).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO error_group_activity (error_group_id, actor_id, type, comment_id) VALUES ($1, $2, $3, $4)
+	`, groupID, authorID, ActivityComment, c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, tx.Commit()
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/config"
+)
+
+const (
+	// APIKeyChannel is the Postgres channel every replica listens on to drop cached key lookups
+	APIKeyChannel = "api_key_changes"
+
+	// keyUsageResolution is how often last_used_at is refreshed for a busy key
+	keyUsageResolution = time.Minute
+	// Unknown keys are cheap to make up, so the cache stops growing here and evicts instead
+	maxAPIKeyCacheEntries = 10000
+)
+
+type apiKeyCacheEntry struct {
+	identity  *APIKeyIdentity // nil for a key that doesn't work
+	expiresAt time.Time
+}
+
+// APIKeyCache remembers ingestion key lookups, including keys that don't work, so events don't
+// each cost a database query. Changes are announced with NotifyAPIKeyChange and picked up by
+// every replica's Listen; the TTLs bound how stale a replica can be if a notification is lost.
+type APIKeyCache struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]apiKeyCacheEntry
+	// generation changes on every Clear, so a lookup that started before it isn't stored
+	generation uint64
+
+	load  func(apiKey string) (*APIKeyIdentity, error)
+	touch func(keyID int) error
+	now   func() time.Time
+}
+
+func NewAPIKeyCache(db *sql.DB, cfg config.Config) *APIKeyCache {
+	keys := NewEnvironmentKeyService(db)
+	return &APIKeyCache{
+		ttl:         time.Duration(cfg.APIKeyCacheSeconds) * time.Second,
+		negativeTTL: time.Duration(cfg.APIKeyNegativeCacheSeconds) * time.Second,
+		entries:     make(map[string]apiKeyCacheEntry),
+		load:        keys.Authenticate,
+		touch:       keys.MarkUsed,
+		now:         time.Now,
+	}
+}
+
+// Get resolves an ingestion key, from the cache when possible, and records its use
+func (c *APIKeyCache) Get(apiKey string) (*APIKeyIdentity, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[apiKey]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if !ok || !now.Before(entry.expiresAt) {
+		identity, err := c.load(apiKey)
+		switch {
+		case err == ErrInvalidAPIKey:
+			entry = apiKeyCacheEntry{expiresAt: now.Add(c.negativeTTL)}
+		case err != nil:
+			// Database errors are not remembered
+			return nil, err
+		default:
+			entry = apiKeyCacheEntry{identity: identity, expiresAt: now.Add(c.ttl)}
+			// A key that expires sooner must not outlive its expiry in the cache
+			if identity.ExpiresAt != nil && identity.ExpiresAt.Before(entry.expiresAt) {
+				entry.expiresAt = *identity.ExpiresAt
+			}
+		}
+		c.store(apiKey, entry, generation)
+	}
+
+	if entry.identity == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	c.recordUse(entry.identity, now)
+	return entry.identity, nil
+}
+
+// store caches entry unless the cache was cleared since generation, when the entry may predate
+// the change that cleared it
+func (c *APIKeyCache) store(apiKey string, entry apiKeyCacheEntry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+
+	if _, ok := c.entries[apiKey]; !ok && len(c.entries) >= maxAPIKeyCacheEntries {
+		// Map iteration order is random, so this evicts an arbitrary entry
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[apiKey] = entry
+}
+
+func (c *APIKeyCache) recordUse(identity *APIKeyIdentity, now time.Time) {
+	c.mu.Lock()
+	due := identity.LastUsedAt == nil || now.Sub(*identity.LastUsedAt) >= keyUsageResolution
+	if due {
+		identity.LastUsedAt = &now
+	}
+	c.mu.Unlock()
+
+	if due {
+		if err := c.touch(identity.KeyID); err != nil {
+			log.Printf("[APIKeyCache] Error recording use of key %d: %v", identity.KeyID, err)
+		}
+	}
+}
+
+// Clear forgets every lookup, so the next request for each key sees current data
+func (c *APIKeyCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]apiKeyCacheEntry)
+	c.generation++
+}
+
+// NotifyAPIKeyChange tells every replica to drop its cached key lookups. Call it after changing
+// keys, environments' is_active or allowed origins, or deleting or restoring projects.
+func NotifyAPIKeyChange(q execer) error {
+	_, err := q.Exec("SELECT pg_notify($1, '')", APIKeyChannel)
+	return err
+}
+
+// Listen clears the cache whenever another replica (or this one) announces a change, until ctx
+// is done. Notifications sent while the connection was down are lost, so reconnecting clears it
+// too.
+func (c *APIKeyCache) Listen(ctx context.Context, databaseURL string) {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[APIKeyCache] Listener error: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(APIKeyChannel); err != nil {
+		log.Printf("[APIKeyCache] Error listening on %s, relying on the cache TTL: %v", APIKeyChannel, err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// A nil notification means the connection was re-established
+			c.Clear()
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
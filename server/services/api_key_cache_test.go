@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prabalesh/vigileye/models"
+)
+
+func newTestAPIKeyCache(now *time.Time, loads *int, touches *int) *APIKeyCache {
+	expiry := now.Add(90 * time.Second)
+	return &APIKeyCache{
+		ttl:         time.Minute,
+		negativeTTL: 10 * time.Second,
+		entries:     make(map[string]apiKeyCacheEntry),
+		load: func(apiKey string) (*APIKeyIdentity, error) {
+			*loads++
+			switch apiKey {
+			case "valid":
+				return &APIKeyIdentity{KeyID: 1, ProjectID: 2, EnvironmentID: 3}, nil
+			case "expiring":
+				return &APIKeyIdentity{KeyID: 4, ProjectID: 2, EnvironmentID: 3, ExpiresAt: &expiry}, nil
+			case "broken":
+				return nil, errors.New("connection refused")
+			}
+			return nil, ErrInvalidAPIKey
+		},
+		touch: func(keyID int) error {
+			*touches++
+			return nil
+		},
+		now: func() time.Time { return *now },
+	}
+}
+
+func TestAPIKeyCache(t *testing.T) {
+	now := time.Now()
+	var loads, touches int
+	c := newTestAPIKeyCache(&now, &loads, &touches)
+
+	id, err := c.Get("valid")
+	if err != nil || id.ProjectID != 2 || id.EnvironmentID != 3 {
+		t.Fatalf("Get(valid) = %+v, %v", id, err)
+	}
+	c.Get("valid")
+	if loads != 1 {
+		t.Errorf("Expected the second lookup to be cached, loaded %d times", loads)
+	}
+	if touches != 1 {
+		t.Errorf("Expected last use to be recorded once per minute, recorded %d times", touches)
+	}
+
+	now = now.Add(61 * time.Second)
+	c.Get("valid")
+	if loads != 2 || touches != 2 {
+		t.Errorf("Expected a reload and a new use after the TTL, got %d loads and %d uses", loads, touches)
+	}
+
+	c.Clear()
+	c.Get("valid")
+	if loads != 3 {
+		t.Errorf("Expected Clear to force a reload, loaded %d times", loads)
+	}
+
+}
+
+func TestAPIKeyCacheRemembersInvalidKeys(t *testing.T) {
+	now := time.Now()
+	var loads, touches int
+	c := newTestAPIKeyCache(&now, &loads, &touches)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("unknown"); err != ErrInvalidAPIKey {
+			t.Fatalf("Get(unknown) = %v, want ErrInvalidAPIKey", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("Expected an unknown key to be looked up once, loaded %d times", loads)
+	}
+
+	now = now.Add(11 * time.Second)
+	c.Get("unknown")
+	if loads != 2 {
+		t.Errorf("Expected a reload after the negative TTL, loaded %d times", loads)
+	}
+
+	c.Clear()
+	c.Get("unknown")
+	if loads != 3 {
+		t.Errorf("Expected Clear to forget invalid keys too, loaded %d times", loads)
+	}
+
+	// Database errors aren't remembered
+	c.Get("broken")
+	c.Get("broken")
+	if loads != 5 {
+		t.Errorf("Expected every failed lookup to be retried, loaded %d times", loads)
+	}
+	if touches != 0 {
+		t.Errorf("Expected no use recorded for keys that don't work, recorded %d", touches)
+	}
+}
+
+func TestAPIKeyCacheDropsLookupsRacingClear(t *testing.T) {
+	now := time.Now()
+	var loads, touches int
+	c := newTestAPIKeyCache(&now, &loads, &touches)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	load := c.load
+	c.load = func(apiKey string) (*APIKeyIdentity, error) {
+		started <- struct{}{}
+		<-release
+		return load(apiKey)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Get("good")
+		close(done)
+	}()
+	<-started
+	// The key is revoked while its old identity is being loaded
+	c.Clear()
+	close(release)
+	<-done
+
+	c.load = load
+	c.Get("good")
+	if loads != 2 {
+		t.Errorf("Expected a lookup that raced Clear not to be cached, loaded %d times", loads)
+	}
+}
+
+func TestAPIKeyCacheIsBounded(t *testing.T) {
+	now := time.Now()
+	var loads, touches int
+	c := newTestAPIKeyCache(&now, &loads, &touches)
+
+	for i := 0; i < maxAPIKeyCacheEntries+100; i++ {
+		c.Get(fmt.Sprintf("made-up-%d", i))
+	}
+	if len(c.entries) != maxAPIKeyCacheEntries {
+		t.Errorf("Expected the cache to stop at %d entries, has %d", maxAPIKeyCacheEntries, len(c.entries))
+	}
+}
+
+func TestAPIKeyCacheHonoursKeyExpiry(t *testing.T) {
+	now := time.Now()
+	var loads, touches int
+	c := newTestAPIKeyCache(&now, &loads, &touches)
+	c.ttl = time.Hour
+
+	// The key expires 90 seconds from now, long before the TTL
+	c.Get("expiring")
+	now = now.Add(60 * time.Second)
+	c.Get("expiring")
+	if loads != 1 {
+		t.Errorf("Expected a cached lookup before the key expires, loaded %d times", loads)
+	}
+
+	now = now.Add(31 * time.Second)
+	c.Get("expiring")
+	if loads != 2 {
+		t.Errorf("Expected a reload once the key expired, loaded %d times", loads)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.example.com", "https://*.preview.example.com", "http://localhost:3000"}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com/", true},
+		{"http://app.example.com", false},
+		{"https://evil.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://pr-12.preview.example.com", true},
+		{"https://preview.example.com", false},
+		{"http://pr-12.preview.example.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+	}
+	for _, tt := range tests {
+		if got := OriginAllowed(tt.origin, allowed); got != tt.want {
+			t.Errorf("OriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	if !OriginAllowed("https://anything.test", nil) {
+		t.Error("Expected an empty list to allow every origin")
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	valid := map[string]string{
+		"https://App.Example.com/": "https://app.example.com",
+		"http://localhost:3000":    "http://localhost:3000",
+		" https://*.example.com ":  "https://*.example.com",
+	}
+	for in, want := range valid {
+		if got, err := NormalizeOrigin(in); err != nil || got != want {
+			t.Errorf("NormalizeOrigin(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "example.com", "ftp://example.com", "https://example.com/path", "https://user@example.com", "https://example.com?x=1"} {
+		if _, err := NormalizeOrigin(in); err == nil {
+			t.Errorf("NormalizeOrigin(%q) succeeded", in)
+		}
+	}
+}
+
+func TestAPIKeyIdentityAllowsOrigin(t *testing.T) {
+	origins := []string{"https://app.example.com"}
+	tests := []struct {
+		name     string
+		identity APIKeyIdentity
+		origin   string
+		want     bool
+	}{
+		{"secret key from server", APIKeyIdentity{Kind: KeyKindSecret, AllowedOrigins: origins}, "", true},
+		{"unrestricted secret key from server", APIKeyIdentity{Kind: KeyKindSecret}, "", true},
+		{"unrestricted secret key from browser", APIKeyIdentity{Kind: KeyKindSecret}, "https://evil.test", false},
+		{"secret key from allowed origin", APIKeyIdentity{Kind: KeyKindSecret, AllowedOrigins: origins}, "https://app.example.com", true},
+		{"secret key from other origin", APIKeyIdentity{Kind: KeyKindSecret, AllowedOrigins: origins}, "https://evil.test", false},
+		{"public key from allowed origin", APIKeyIdentity{Kind: KeyKindPublic, AllowedOrigins: origins}, "https://app.example.com", true},
+		{"public key from other origin", APIKeyIdentity{Kind: KeyKindPublic, AllowedOrigins: origins}, "https://evil.test", false},
+		{"public key from server", APIKeyIdentity{Kind: KeyKindPublic, AllowedOrigins: origins}, "", false},
+		{"public key without origins", APIKeyIdentity{Kind: KeyKindPublic}, "https://app.example.com", false},
+		{"public key from opaque origin", APIKeyIdentity{Kind: KeyKindPublic, AllowedOrigins: origins}, "null", false},
+	}
+	for _, tt := range tests {
+		if got := tt.identity.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("%s: AllowsOrigin(%q) = %v, want %v", tt.name, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestEnvironmentKeyMask(t *testing.T) {
+	secret := EnvironmentKey{Kind: KeyKindSecret, APIKey: "0c6f1f64-6a4e-4b0e-9a43-0e4f2ab1c9d7"}
+	secret.Mask()
+	if secret.APIKey != "0c6f1f64"+models.MaskedSecret {
+		t.Errorf("Expected the secret key to be masked, got %q", secret.APIKey)
+	}
+
+	public := EnvironmentKey{Kind: KeyKindPublic, APIKey: "0c6f1f64-6a4e-4b0e-9a43-0e4f2ab1c9d7"}
+	public.Mask()
+	if public.APIKey != "0c6f1f64-6a4e-4b0e-9a43-0e4f2ab1c9d7" {
+		t.Errorf("Expected the public key to be left alone, got %q", public.APIKey)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEntry is a change about to be written to a project's audit log
+type AuditEntry struct {
+	ProjectID  int
+	ActorID    int
+	TokenID    *int
+	Action     string
+	TargetType string
+	TargetID   string
+	// Changes maps changed fields to their before and after values, secrets already redacted
+	Changes interface{}
+	// Request is the redacted request body, if it was JSON
+	Request   interface{}
+	Method    string
+	Path      string
+	Status    int
+	IPAddress string
+	UserAgent string
+}
+
+// AuditLog is a stored audit log entry
+type AuditLog struct {
+	ID         int64           `json:"id"`
+	ActorID    *int            `json:"actor_id"`
+	ActorEmail string          `json:"actor_email"`
+	TokenID    *int            `json:"token_id,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Changes    json.RawMessage `json:"changes"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Status     int             `json:"status"`
+	IPAddress  string          `json:"ip_address"`
+	UserAgent  string          `json:"user_agent"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows an audit log query; zero values match everything
+type AuditFilter struct {
+	Action     string
+	ActorID    int
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+}
+
+// RecordAuditLog appends an entry to a project's audit log
+func RecordAuditLog(q execer, e *AuditEntry) error {
+	changes := e.Changes
+	if changes == nil {
+		changes = map[string]interface{}{}
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	var requestJSON []byte
+	if e.Request != nil {
+		if requestJSON, err = json.Marshal(e.Request); err != nil {
+			return err
+		}
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO audit_logs (
+			project_id, actor_id, actor_email, token_id, action, target_type, target_id,
+			changes, request, method, path, status, ip_address, user_agent
+		)
+		VALUES ($1, $2, COALESCE((SELECT email FROM users WHERE id = $2), ''), $3, $4, $5, $6,
+			$7, $8, $9, $10, $11, $12, $13)
+	`, e.ProjectID, e.ActorID, e.TokenID, e.Action, e.TargetType, e.TargetID,
+		changesJSON, requestJSON, e.Method, e.Path, e.Status, e.IPAddress, truncate(e.UserAgent, 512))
+	return err
+}
+
+type AuditLogService struct {
+	db *sql.DB
+}
+
+func NewAuditLogService(db *sql.DB) *AuditLogService {
+	return &AuditLogService{db: db}
+}
+
+func (f AuditFilter) where(projectID int) (string, []interface{}) {
+	conditions := []string{"project_id = $1"}
+	args := []interface{}{projectID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Action != "" {
+		// "member" matches every member.* action
+		if strings.Contains(f.Action, ".") {
+			add("action = $%d", f.Action)
+		} else {
+			add("action LIKE $%d || '.%%'", f.Action)
+		}
+	}
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// List returns a page of a project's audit log, newest first, and the total number of matching
+// entries
+func (s *AuditLogService) List(projectID int, filter AuditFilter, limit, offset int) ([]AuditLog, int, error) {
+	where, args := filter.where(projectID)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM audit_logs WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	logs := []AuditLog{}
+	err := s.each(where, append(args, limit, offset), func(l *AuditLog) error {
+		logs = append(logs, *l)
+		return nil
+	})
+	return logs, total, err
+}
+
+// Each streams up to max matching entries, newest first, for exports
+func (s *AuditLogService) Each(projectID int, filter AuditFilter, max int, fn func(*AuditLog) error) error {
+	where, args := filter.where(projectID)
+	return s.each(where, append(args, max, 0), fn)
+}
+
+// each runs the query with LIMIT and OFFSET taken from the last two args
+func (s *AuditLogService) each(where string, args []interface{}, fn func(*AuditLog) error) error {
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT id, actor_id, actor_email, token_id, action, target_type, target_id, changes, request,
+		       method, path, status, ip_address, user_agent, created_at
+		FROM audit_logs
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l AuditLog
+		var request []byte
+		err := rows.Scan(&l.ID, &l.ActorID, &l.ActorEmail, &l.TokenID, &l.Action, &l.TargetType, &l.TargetID,
+			&l.Changes, &request, &l.Method, &l.Path, &l.Status, &l.IPAddress, &l.UserAgent, &l.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if request != nil {
+			l.Request = request
+		}
+		if err := fn(&l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
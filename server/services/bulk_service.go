@@ -0,0 +1,214 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+const (
+	BulkResolve = "resolve"
+	BulkIgnore  = "ignore"
+	BulkReopen  = "reopen"
+	BulkAssign  = "assign"
+	BulkDelete  = "delete"
+
+	// MaxBulkGroups bounds one bulk operation; filter matches beyond it are left for a follow-up call
+	MaxBulkGroups = 1000
+)
+
+var (
+	ErrInvalidBulkAction = errors.New("action must be one of resolve, ignore, reopen, assign, delete")
+	ErrBulkTarget        = errors.New("specify either group_ids or filter")
+	ErrTooManyGroups     = errors.New("too many group_ids (max 1000)")
+)
+
+// BulkRequest targets either explicit GroupIDs or every group matching Filter
+type BulkRequest struct {
+	Action     string       `json:"action"`
+	GroupIDs   []int        `json:"group_ids"`
+	Filter     *GroupFilter `json:"filter"`
+	AssignedTo *int         `json:"assigned_to"`
+	// ExcludeEnvironments lists environments the caller may not apply the action in
+	ExcludeEnvironments []int `json:"-"`
+}
+
+type BulkItemResult struct {
+	GroupID int    `json:"group_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BulkResult struct {
+	OperationID int    `json:"operation_id"`
+	Action      string `json:"action"`
+	Succeeded   int    `json:"succeeded"`
+	Failed      int    `json:"failed"`
+	// HasMore is set when a filter matched more than MaxBulkGroups groups
+	HasMore bool             `json:"has_more"`
+	Results []BulkItemResult `json:"results"`
+}
+
+type BulkService struct {
+	db     *sql.DB
+	groups *ErrorGroupService
+}
+
+func NewBulkService(db *sql.DB) *BulkService {
+	return &BulkService{db: db, groups: NewErrorGroupService(db)}
+}
+
+// Apply runs a bulk action in one transaction. Groups that are missing are reported per item;
+// any database error rolls the whole operation back.
+func (s *BulkService) Apply(projectID, userID int, req BulkRequest) (*BulkResult, error) {
+	switch req.Action {
+	case BulkResolve, BulkIgnore, BulkReopen, BulkAssign, BulkDelete:
+	default:
+		return nil, ErrInvalidBulkAction
+	}
+	if (len(req.GroupIDs) == 0) == (req.Filter == nil) {
+		return nil, ErrBulkTarget
+	}
+	if len(req.GroupIDs) > MaxBulkGroups {
+		return nil, ErrTooManyGroups
+	}
+
+	// Validate the assignee once rather than per group
+	if req.Action == BulkAssign && req.AssignedTo != nil {
+		member, err := s.groups.HasProjectAccess(projectID, *req.AssignedTo)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, ErrNotMember
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &BulkResult{Action: req.Action, Results: []BulkItemResult{}}
+
+	groupIDs := req.GroupIDs
+	excluded := map[int]bool{}
+	if req.Filter != nil {
+		filter := *req.Filter
+		filter.ExcludeEnvironments = req.ExcludeEnvironments
+		groupIDs, result.HasMore, err = s.matchingGroups(tx, projectID, userID, filter)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(req.ExcludeEnvironments) > 0 {
+		excluded, err = s.groupsInEnvironments(tx, groupIDs, req.ExcludeEnvironments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, groupID := range groupIDs {
+		err := ErrGroupNotFound
+		if !excluded[groupID] {
+			err = s.applyOne(tx, projectID, userID, groupID, req)
+		}
+		if err == ErrGroupNotFound {
+			result.Failed++
+			result.Results = append(result.Results, BulkItemResult{GroupID: groupID, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Succeeded++
+		result.Results = append(result.Results, BulkItemResult{GroupID: groupID, Success: true})
+	}
+
+	var filterJSON []byte
+	if req.Filter != nil {
+		filterJSON, _ = json.Marshal(req.Filter)
+	}
+	resultsJSON, _ := json.Marshal(result.Results)
+	err = tx.QueryRow(`
+		INSERT INTO bulk_operations (project_id, user_id, action, filter, group_ids, succeeded, failed, results)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, projectID, userID, req.Action, filterJSON, pq.Array(groupIDs), result.Succeeded, result.Failed, resultsJSON).Scan(&result.OperationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BulkService) applyOne(tx *sql.Tx, projectID, userID, groupID int, req BulkRequest) error {
+	switch req.Action {
+	case BulkResolve:
+		return updateGroupStatus(tx, projectID, groupID, userID, "resolved")
+	case BulkIgnore:
+		return updateGroupStatus(tx, projectID, groupID, userID, "ignored")
+	case BulkReopen:
+		return updateGroupStatus(tx, projectID, groupID, userID, "unresolved")
+	case BulkAssign:
+		return assignGroup(tx, projectID, groupID, req.AssignedTo, &userID)
+	default:
+		return deleteGroup(tx, projectID, groupID)
+	}
+}
+
+// groupsInEnvironments reports which of the groups belong to the given environments
+func (s *BulkService) groupsInEnvironments(tx *sql.Tx, groupIDs, environmentIDs []int) (map[int]bool, error) {
+	rows, err := tx.Query(`
+		SELECT id FROM error_groups WHERE id = ANY($1) AND environment_id = ANY($2)
+	`, pq.Array(groupIDs), pq.Array(environmentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		found[id] = true
+	}
+	return found, rows.Err()
+}
+
+// matchingGroups locks the groups matched by a filter, most recently seen first
+func (s *BulkService) matchingGroups(tx *sql.Tx, projectID, userID int, filter GroupFilter) ([]int, bool, error) {
+	query, args, err := filter.Apply("SELECT eg.id FROM error_groups eg WHERE eg.project_id = $1", []interface{}{projectID}, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	query += " ORDER BY eg.last_seen DESC LIMIT $" + strconv.Itoa(len(args)+1) + " FOR UPDATE"
+	args = append(args, MaxBulkGroups+1)
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, false, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) > MaxBulkGroups {
+		return ids[:MaxBulkGroups], true, rows.Err()
+	}
+	return ids, false, rows.Err()
+}
@@ -0,0 +1,413 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/models"
+)
+
+const (
+	// Public keys go in browser bundles and only work from allowed origins; secret keys are for
+	// servers
+	KeyKindPublic = "public"
+	KeyKindSecret = "secret"
+
+	// A rotated key can keep working alongside its replacement for at most this long
+	maxKeyRotationOverlap = 30 * 24 * time.Hour
+	maxAllowedOrigins     = 20
+)
+
+var (
+	ErrInvalidKeyInput = errors.New("name (max 100 characters) is required, origins look like https://app.example.com or https://*.example.com, and expiry must be in the future")
+	ErrInvalidOverlap  = errors.New("overlap must be between 0 and 720 hours")
+	ErrPublicKeyOrigin = errors.New("public keys need allowed origins, on the key or on its environment")
+	ErrKeyNotFound     = errors.New("API key not found")
+	ErrInvalidAPIKey   = errors.New("invalid, expired or revoked API key")
+)
+
+// activeKeyCondition matches keys that may still be used for ingestion
+const activeKeyCondition = "k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > NOW())"
+
+// EnvironmentKey is an ingestion key of an environment
+type EnvironmentKey struct {
+	ID             int        `json:"id"`
+	EnvironmentID  int        `json:"environment_id"`
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`
+	APIKey         string     `json:"api_key"`
+	AllowedOrigins []string   `json:"allowed_origins"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	CreatedBy      *int       `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// MaskAPIKey keeps just enough of a key to recognise it, for members who may not use it
+func MaskAPIKey(key string) string {
+	if len(key) <= 8 {
+		return key
+	}
+	return key[:8] + models.MaskedSecret
+}
+
+// Mask hides a secret key; public keys are embedded in web pages anyway
+func (k *EnvironmentKey) Mask() {
+	if k.Kind != KeyKindPublic {
+		k.APIKey = MaskAPIKey(k.APIKey)
+	}
+}
+
+// EnvironmentKeyInput is the editable part of a key. An empty AllowedOrigins falls back to the
+// environment's; a nil ExpiresAt never expires. Kind defaults to secret and can't be changed
+// once a key exists.
+type EnvironmentKeyInput struct {
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`
+	AllowedOrigins []string   `json:"allowed_origins"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// APIKeyIdentity is what an ingestion key grants access to. AllowedOrigins are the key's own, or
+// the environment's when it has none.
+type APIKeyIdentity struct {
+	KeyID          int
+	ProjectID      int
+	EnvironmentID  int
+	Kind           string
+	AllowedOrigins []string
+	ExpiresAt      *time.Time
+	LastUsedAt     *time.Time
+}
+
+// NormalizeOrigin checks that s is a bare origin, optionally with a wildcard subdomain, and
+// returns it lower-cased without a trailing slash
+func NormalizeOrigin(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "/"))
+	u, err := url.Parse(strings.Replace(s, "://*.", "://wildcard.", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", ErrInvalidKeyInput
+	}
+	return s, nil
+}
+
+// OriginAllowed reports whether origin matches one of the allowed origins. An empty list allows
+// every origin.
+func OriginAllowed(origin string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	for _, a := range allowed {
+		if a == origin {
+			return true
+		}
+		// https://*.example.com matches any subdomain, but not example.com itself
+		if scheme, suffix, ok := strings.Cut(a, "://*."); ok && strings.HasPrefix(origin, scheme+"://") &&
+			strings.HasSuffix(origin, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrigin reports whether a request from origin may use the key; origin is "" for requests
+// that didn't come from a browser. Browsers need an explicitly allowed origin whatever the kind,
+// so a secret key without origins can't be lifted from a bundle and used from any website.
+func (id *APIKeyIdentity) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return id.Kind != KeyKindPublic
+	}
+	return len(id.AllowedOrigins) > 0 && OriginAllowed(origin, id.AllowedOrigins)
+}
+
+// NormalizeOrigins validates and normalizes a list of allowed origins
+func NormalizeOrigins(origins []string) ([]string, error) {
+	if len(origins) > maxAllowedOrigins {
+		return nil, ErrInvalidKeyInput
+	}
+	normalized := make([]string, 0, len(origins))
+	for _, o := range origins {
+		n, err := NormalizeOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, n)
+	}
+	return normalized, nil
+}
+
+func (in *EnvironmentKeyInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Kind == "" {
+		in.Kind = KeyKindSecret
+	}
+	if in.Name == "" || len(in.Name) > 100 || (in.Kind != KeyKindPublic && in.Kind != KeyKindSecret) {
+		return ErrInvalidKeyInput
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return ErrInvalidKeyInput
+	}
+	origins, err := NormalizeOrigins(in.AllowedOrigins)
+	if err != nil {
+		return err
+	}
+	in.AllowedOrigins = origins
+	return nil
+}
+
+// CreateEnvironmentKey adds a key to an environment, for callers that are already in a
+// transaction; input must have been validated
+func CreateEnvironmentKey(q queryer, environmentID int, createdBy *int, input EnvironmentKeyInput) (*EnvironmentKey, error) {
+	if input.AllowedOrigins == nil {
+		input.AllowedOrigins = []string{}
+	}
+	if input.Kind == "" {
+		input.Kind = KeyKindSecret
+	}
+	k := &EnvironmentKey{
+		EnvironmentID:  environmentID,
+		Name:           input.Name,
+		Kind:           input.Kind,
+		AllowedOrigins: input.AllowedOrigins,
+		ExpiresAt:      input.ExpiresAt,
+		CreatedBy:      createdBy,
+	}
+	err := q.QueryRow(`
+		INSERT INTO environment_api_keys (environment_id, name, kind, allowed_origins, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, api_key, created_at
+	`, environmentID, input.Name, input.Kind, pq.Array(input.AllowedOrigins), input.ExpiresAt, createdBy).Scan(&k.ID, &k.APIKey, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// EnvironmentKeyService manages the ingestion keys of a project's environments
+type EnvironmentKeyService struct {
+	db *sql.DB
+}
+
+func NewEnvironmentKeyService(db *sql.DB) *EnvironmentKeyService {
+	return &EnvironmentKeyService{db: db}
+}
+
+const environmentKeyColumns = `k.id, k.environment_id, k.name, k.kind, k.api_key, k.allowed_origins, k.expires_at,
+	k.last_used_at, k.created_by, k.created_at`
+
+func scanEnvironmentKey(row interface{ Scan(...interface{}) error }) (*EnvironmentKey, error) {
+	var k EnvironmentKey
+	err := row.Scan(&k.ID, &k.EnvironmentID, &k.Name, &k.Kind, &k.APIKey, pq.Array(&k.AllowedOrigins), &k.ExpiresAt,
+		&k.LastUsedAt, &k.CreatedBy, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	return &k, err
+}
+
+// List returns an environment's keys that haven't been revoked, including expired ones, newest
+// first
+func (s *EnvironmentKeyService) List(projectID, environmentID int) ([]EnvironmentKey, error) {
+	rows, err := s.db.Query(`
+		SELECT `+environmentKeyColumns+`
+		FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		WHERE e.project_id = $1 AND k.environment_id = $2 AND k.revoked_at IS NULL
+		ORDER BY k.id DESC
+	`, projectID, environmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []EnvironmentKey{}
+	for rows.Next() {
+		k, err := scanEnvironmentKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns a key that hasn't been revoked
+func (s *EnvironmentKeyService) Get(projectID, environmentID, keyID int) (*EnvironmentKey, error) {
+	return scanEnvironmentKey(s.db.QueryRow(`
+		SELECT `+environmentKeyColumns+`
+		FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		WHERE e.project_id = $1 AND k.environment_id = $2 AND k.id = $3 AND k.revoked_at IS NULL
+	`, projectID, environmentID, keyID))
+}
+
+func (s *EnvironmentKeyService) Create(projectID, environmentID, userID int, input EnvironmentKeyInput) (*EnvironmentKey, error) {
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+	var envOrigins []string
+	err := s.db.QueryRow(
+		"SELECT allowed_origins FROM environments WHERE id = $1 AND project_id = $2", environmentID, projectID,
+	).Scan(pq.Array(&envOrigins))
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if input.Kind == KeyKindPublic && len(input.AllowedOrigins) == 0 && len(envOrigins) == 0 {
+		return nil, ErrPublicKeyOrigin
+	}
+	return CreateEnvironmentKey(s.db, environmentID, &userID, input)
+}
+
+// Update replaces a key's name, allowed origins and expiry
+func (s *EnvironmentKeyService) Update(projectID, environmentID, keyID int, input EnvironmentKeyInput) (*EnvironmentKey, error) {
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+	var kind string
+	var envOrigins []string
+	err := s.db.QueryRow(`
+		SELECT k.kind, e.allowed_origins
+		FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		WHERE e.project_id = $1 AND k.environment_id = $2 AND k.id = $3 AND k.revoked_at IS NULL
+	`, projectID, environmentID, keyID).Scan(&kind, pq.Array(&envOrigins))
+	if err == sql.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kind == KeyKindPublic && len(input.AllowedOrigins) == 0 && len(envOrigins) == 0 {
+		return nil, ErrPublicKeyOrigin
+	}
+
+	return scanEnvironmentKey(s.db.QueryRow(`
+		UPDATE environment_api_keys k SET name = $4, allowed_origins = $5, expires_at = $6
+		FROM environments e
+		WHERE e.id = k.environment_id AND e.project_id = $1 AND k.environment_id = $2 AND k.id = $3
+		  AND k.revoked_at IS NULL
+		RETURNING `+environmentKeyColumns,
+		projectID, environmentID, keyID, input.Name, pq.Array(input.AllowedOrigins), input.ExpiresAt))
+}
+
+// PublicKeysUseEnvironmentOrigins reports whether any usable public key of the environment relies
+// on the environment's allowed origins, having none of its own
+func (s *EnvironmentKeyService) PublicKeysUseEnvironmentOrigins(environmentID int) (bool, error) {
+	var used bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM environment_api_keys k
+			WHERE k.environment_id = $1 AND k.kind = 'public' AND cardinality(k.allowed_origins) = 0
+			  AND `+activeKeyCondition+`
+		)
+	`, environmentID).Scan(&used)
+	return used, err
+}
+
+// Revoke stops a key from working straight away
+func (s *EnvironmentKeyService) Revoke(projectID, environmentID, keyID int) (*EnvironmentKey, error) {
+	return scanEnvironmentKey(s.db.QueryRow(`
+		UPDATE environment_api_keys k SET revoked_at = NOW()
+		FROM environments e
+		WHERE e.id = k.environment_id AND e.project_id = $1 AND k.environment_id = $2 AND k.id = $3
+		  AND k.revoked_at IS NULL
+		RETURNING `+environmentKeyColumns,
+		projectID, environmentID, keyID))
+}
+
+// Rotate replaces a key with a new one of the same name and origins. The old key keeps working
+// for overlap, so clients can be redeployed with the new one; with no overlap it is revoked.
+func (s *EnvironmentKeyService) Rotate(projectID, environmentID, keyID, userID int, overlap time.Duration) (*EnvironmentKey, error) {
+	if overlap < 0 || overlap > maxKeyRotationOverlap {
+		return nil, ErrInvalidOverlap
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := scanEnvironmentKey(tx.QueryRow(`
+		SELECT `+environmentKeyColumns+`
+		FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		WHERE e.project_id = $1 AND k.environment_id = $2 AND k.id = $3 AND `+activeKeyCondition+`
+		FOR UPDATE OF k
+	`, projectID, environmentID, keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	if overlap == 0 {
+		_, err = tx.Exec("UPDATE environment_api_keys SET revoked_at = NOW() WHERE id = $1", keyID)
+	} else {
+		// An expiry that was already sooner than the overlap stays
+		_, err = tx.Exec(`
+			UPDATE environment_api_keys
+			SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), NOW() + make_interval(secs => $2))
+			WHERE id = $1
+		`, keyID, overlap.Seconds())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := CreateEnvironmentKey(tx, environmentID, &userID, EnvironmentKeyInput{
+		Name:           old.Name,
+		Kind:           old.Kind,
+		AllowedOrigins: old.AllowedOrigins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k, tx.Commit()
+}
+
+// Primary returns the newest usable key of an environment, the one the dashboard shows
+func (s *EnvironmentKeyService) Primary(projectID, environmentID int) (*EnvironmentKey, error) {
+	return scanEnvironmentKey(s.db.QueryRow(`
+		SELECT `+environmentKeyColumns+`
+		FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		WHERE e.project_id = $1 AND k.environment_id = $2 AND `+activeKeyCondition+`
+		ORDER BY k.id DESC
+		LIMIT 1
+	`, projectID, environmentID))
+}
+
+// Authenticate resolves an ingestion key. Keys of inactive environments and deleted projects
+// don't work.
+func (s *EnvironmentKeyService) Authenticate(apiKey string) (*APIKeyIdentity, error) {
+	id := &APIKeyIdentity{}
+	err := s.db.QueryRow(`
+		SELECT k.id, e.project_id, e.id, k.kind,
+		       CASE WHEN cardinality(k.allowed_origins) > 0 THEN k.allowed_origins ELSE e.allowed_origins END,
+		       k.expires_at, k.last_used_at
+		FROM environment_api_keys k
+		JOIN environments e ON e.id = k.environment_id
+		JOIN projects p ON p.id = e.project_id
+		WHERE k.api_key = $1 AND `+activeKeyCondition+` AND e.is_active = TRUE AND p.deleted_at IS NULL
+	`, apiKey).Scan(&id.KeyID, &id.ProjectID, &id.EnvironmentID, &id.Kind, pq.Array(&id.AllowedOrigins), &id.ExpiresAt, &id.LastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// MarkUsed records that a key was just used
+func (s *EnvironmentKeyService) MarkUsed(keyID int) error {
+	_, err := s.db.Exec("UPDATE environment_api_keys SET last_used_at = NOW() WHERE id = $1", keyID)
+	return err
+}
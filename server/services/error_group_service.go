@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/models"
+)
+
+var (
+	ErrGroupNotFound       = errors.New("error group not found")
+	ErrNotMember           = errors.New("user is not a project member")
+	ErrEnvironmentNotFound = errors.New("environment not found")
+	ErrInvalidSnooze       = errors.New("snooze needs a future time, a positive occurrence count or a positive user count")
+)
+
+// GroupSummary is a compact view of an error group for chat replies
+type GroupSummary struct {
+	ID              int
+	Message         string
+	Level           string
+	Status          string
+	EnvironmentName string
+	OccurrenceCount int
+	FirstSeen       time.Time
+	LastSeen        time.Time
+	SnoozedUntil    *time.Time
+}
+
+// EnvironmentStats are event counts for one environment over a period
+type EnvironmentStats struct {
+	EnvironmentName string
+	Events          int
+	NewGroups       int
+	Unresolved      int
+}
+
+// ErrorGroupService holds triage operations shared by the dashboard API and Telegram actions
+type ErrorGroupService struct {
+	db *sql.DB
+}
+
+func NewErrorGroupService(db *sql.DB) *ErrorGroupService {
+	return &ErrorGroupService{db: db}
+}
+
+// HasProjectAccess reports whether the user owns or is a member of the project
+func (s *ErrorGroupService) HasProjectAccess(projectID, userID int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM projects p
+			LEFT JOIN project_members pm ON p.id = pm.project_id
+			WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id = $2)
+		)
+	`, projectID, userID).Scan(&exists)
+	return exists, err
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// UpdateStatus resolves, ignores or reopens a group on behalf of a user
+func (s *ErrorGroupService) UpdateStatus(projectID, groupID, userID int, status string) error {
+	return updateGroupStatus(s.db, projectID, groupID, userID, status)
+}
+
+func updateGroupStatus(q queryer, projectID, groupID, userID int, status string) error {
+	var query string
+	var args []interface{}
+
+	// Any status change starts a new notification streak and ends a snooze
+	resetStreak := "notification_step = 0, streak_started_at = NULL, notify_pending_at = NULL, escalated_at = NULL, " + ClearSnooze
+
+	if status == "resolved" {
+		query = "UPDATE error_groups SET status = $1, resolved_at = NOW(), resolved_by = $2, " + resetStreak + " WHERE id = $3 AND project_id = $4 RETURNING id"
+		args = append(args, status, userID, groupID, projectID)
+	} else if status == "ignored" {
+		query = "UPDATE error_groups SET status = $1, resolved_at = NULL, resolved_by = NULL, " + resetStreak + " WHERE id = $2 AND project_id = $3 RETURNING id"
+		args = append(args, status, groupID, projectID)
+	} else { // unresolved
+		query = "UPDATE error_groups SET status = $1, resolved_at = NULL, resolved_by = NULL, " + resetStreak + " WHERE id = $2 AND project_id = $3 RETURNING id"
+		args = append(args, status, groupID, projectID)
+	}
+
+	var id int
+	err := q.QueryRow(query, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return RecordActivity(q, groupID, &userID, ActivityStatusChanged, map[string]interface{}{"status": status})
+}
+
+// SnoozeOptions are the wake conditions of a snooze; the group reopens when the first one is met
+type SnoozeOptions struct {
+	Until *time.Time
+	// Occurrences is the number of further occurrences to stay quiet for
+	Occurrences *int
+	// Users is the number of distinct affected users (error_logs.user_id) to stay quiet for
+	Users *int
+}
+
+// ClearSnooze is the SET fragment that drops all snooze state
+const ClearSnooze = "snoozed_until = NULL, snoozed_at = NULL, snooze_until_occurrences = NULL, snooze_user_limit = NULL"
+
+// Snooze silences a group until the given time, after which it reopens
+func (s *ErrorGroupService) Snooze(projectID, groupID, userID int, until time.Time) error {
+	return s.SnoozeWith(projectID, groupID, userID, SnoozeOptions{Until: &until})
+}
+
+// SnoozeWith silences a group until any of the given conditions is met
+func (s *ErrorGroupService) SnoozeWith(projectID, groupID, userID int, opts SnoozeOptions) error {
+	if opts.Until == nil && opts.Occurrences == nil && opts.Users == nil {
+		return ErrInvalidSnooze
+	}
+	if (opts.Until != nil && !opts.Until.After(time.Now())) ||
+		(opts.Occurrences != nil && *opts.Occurrences <= 0) ||
+		(opts.Users != nil && *opts.Users <= 0) {
+		return ErrInvalidSnooze
+	}
+
+	var id int
+	err := s.db.QueryRow(`
+		UPDATE error_groups
+		SET status = 'snoozed', snoozed_at = NOW(), snoozed_until = $1,
+		    snooze_until_occurrences = occurrence_count + $2::int, snooze_user_limit = $3,
+		    resolved_at = NULL, resolved_by = NULL, notify_pending_at = NULL
+		WHERE id = $4 AND project_id = $5
+		RETURNING id
+	`, opts.Until, opts.Occurrences, opts.Users, groupID, projectID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return RecordActivity(s.db, groupID, &userID, ActivitySnoozed, map[string]interface{}{
+		"until":       opts.Until,
+		"occurrences": opts.Occurrences,
+		"users":       opts.Users,
+	})
+}
+
+// Assign sets the group's assignee; nil clears it. actorID is nil for automatic assignment.
+func (s *ErrorGroupService) Assign(projectID, groupID int, assigneeID, actorID *int) error {
+	if assigneeID != nil {
+		member, err := s.HasProjectAccess(projectID, *assigneeID)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return ErrNotMember
+		}
+	}
+
+	return assignGroup(s.db, projectID, groupID, assigneeID, actorID)
+}
+
+func assignGroup(q queryer, projectID, groupID int, assigneeID, actorID *int) error {
+	var id int
+	err := q.QueryRow(`
+		UPDATE error_groups
+		SET assigned_to = $1, assigned_at = CASE WHEN $1::int IS NULL THEN NULL ELSE NOW() END
+		WHERE id = $2 AND project_id = $3 RETURNING id
+	`, assigneeID, groupID, projectID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return RecordActivity(q, groupID, actorID, ActivityAssigned, map[string]interface{}{"assigned_to": assigneeID})
+}
+
+func deleteGroup(q queryer, projectID, groupID int) error {
+	var id int
+	err := q.QueryRow("DELETE FROM error_groups WHERE id = $1 AND project_id = $2 RETURNING id", groupID, projectID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	return err
+}
+
+// ExpireSnoozes reopens groups whose snooze has run out
+func (s *ErrorGroupService) ExpireSnoozes(ctx context.Context) error {
+	res, err := s.db.ExecContext(ctx, `
+		WITH reopened AS (
+			UPDATE error_groups
+			SET status = 'unresolved', `+ClearSnooze+`,
+			    notification_step = 0, streak_started_at = NULL, escalated_at = NULL
+			WHERE status = 'snoozed' AND snoozed_until <= NOW()
+			RETURNING id
+		)
+		INSERT INTO error_group_activity (error_group_id, type, data)
+		SELECT id, $1, '{"reason": "snooze_expired"}' FROM reopened
+	`, ActivityReopened)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[Snooze] Reopened %d error groups after snooze expiry", n)
+	}
+	return nil
+}
+
+// TopUnresolved returns the most recently active unresolved groups, optionally for one environment,
+// leaving out excluded environments
+func (s *ErrorGroupService) TopUnresolved(projectID int, environmentName string, exclude []int, limit int) ([]GroupSummary, error) {
+	rows, err := s.db.Query(`
+		SELECT eg.id, eg.message, eg.level, eg.status, e.name, eg.occurrence_count,
+		       eg.first_seen, eg.last_seen, eg.snoozed_until
+		FROM error_groups eg
+		JOIN environments e ON e.id = eg.environment_id
+		WHERE eg.project_id = $1 AND eg.status = 'unresolved' AND ($2 = '' OR e.name = $2)
+		  AND e.id <> ALL(COALESCE($4::int[], '{}'))
+		ORDER BY eg.last_seen DESC
+		LIMIT $3
+	`, projectID, environmentName, limit, pq.Array(exclude))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []GroupSummary{}
+	for rows.Next() {
+		var g GroupSummary
+		if err := rows.Scan(&g.ID, &g.Message, &g.Level, &g.Status, &g.EnvironmentName, &g.OccurrenceCount,
+			&g.FirstSeen, &g.LastSeen, &g.SnoozedUntil); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroupSummary loads a single group of the project; groups in excluded environments are not found
+func (s *ErrorGroupService) GetGroupSummary(projectID, groupID int, exclude []int) (*GroupSummary, error) {
+	var g GroupSummary
+	err := s.db.QueryRow(`
+		SELECT eg.id, eg.message, eg.level, eg.status, e.name, eg.occurrence_count,
+		       eg.first_seen, eg.last_seen, eg.snoozed_until
+		FROM error_groups eg
+		JOIN environments e ON e.id = eg.environment_id
+		WHERE eg.id = $1 AND eg.project_id = $2 AND e.id <> ALL(COALESCE($3::int[], '{}'))
+	`, groupID, projectID, pq.Array(exclude)).Scan(&g.ID, &g.Message, &g.Level, &g.Status, &g.EnvironmentName, &g.OccurrenceCount,
+		&g.FirstSeen, &g.LastSeen, &g.SnoozedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// RecentOccurrences returns the latest events of a group
+func (s *ErrorGroupService) RecentOccurrences(projectID, groupID, limit int) ([]models.ErrorLog, error) {
+	rows, err := s.db.Query(`
+		SELECT id, timestamp, url, method, status_code, user_id
+		FROM error_logs
+		WHERE error_group_id = $1 AND project_id = $2
+		ORDER BY created_at DESC LIMIT $3
+	`, groupID, projectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []models.ErrorLog{}
+	for rows.Next() {
+		var l models.ErrorLog
+		if err := rows.Scan(&l.ID, &l.Timestamp, &l.URL, &l.Method, &l.StatusCode, &l.UserID); err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+// Stats returns per-environment counts since the given time, leaving out excluded environments
+func (s *ErrorGroupService) Stats(projectID int, since time.Time, exclude []int) ([]EnvironmentStats, error) {
+	rows, err := s.db.Query(`
+		SELECT e.name,
+		       (SELECT COUNT(*) FROM error_logs el WHERE el.environment_id = e.id AND el.created_at >= $2),
+		       (SELECT COUNT(*) FROM error_groups eg WHERE eg.environment_id = e.id AND eg.first_seen >= $2),
+		       (SELECT COUNT(*) FROM error_groups eg WHERE eg.environment_id = e.id AND eg.status = 'unresolved')
+		FROM environments e
+		WHERE e.project_id = $1 AND e.id <> ALL(COALESCE($3::int[], '{}'))
+		ORDER BY e.created_at ASC
+	`, projectID, since, pq.Array(exclude))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []EnvironmentStats{}
+	for rows.Next() {
+		var st EnvironmentStats
+		if err := rows.Scan(&st.EnvironmentName, &st.Events, &st.NewGroups, &st.Unresolved); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidFilter = errors.New("invalid assigned_to filter: use a user ID, \"me\" or \"none\"")
+
+// GroupFilter narrows error group queries; it is shared by the list and bulk endpoints
+type GroupFilter struct {
+	EnvironmentID *int   `json:"environment_id"`
+	Status        string `json:"status"`
+	// AssignedTo accepts a user ID, "me" or "none"
+	AssignedTo string `json:"assigned_to"`
+	// ExcludeEnvironments hides environments the caller may not act on; it is set by the
+	// handler from the caller's environment overrides, never from the request
+	ExcludeEnvironments []int `json:"-"`
+}
+
+// Apply appends the filter's conditions on the "eg" alias to query, numbering
+// placeholders after the existing args
+func (f GroupFilter) Apply(query string, args []interface{}, userID int) (string, []interface{}, error) {
+	placeholder := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if f.EnvironmentID != nil {
+		query += " AND eg.environment_id = " + placeholder(*f.EnvironmentID)
+	}
+	if len(f.ExcludeEnvironments) > 0 {
+		query += " AND eg.environment_id <> ALL(" + placeholder(pq.Array(f.ExcludeEnvironments)) + ")"
+	}
+	if f.Status != "" {
+		query += " AND eg.status = " + placeholder(f.Status)
+	}
+
+	switch f.AssignedTo {
+	case "":
+	case "none":
+		query += " AND eg.assigned_to IS NULL"
+	case "me":
+		query += " AND eg.assigned_to = " + placeholder(userID)
+	default:
+		assigneeID, err := strconv.Atoi(f.AssignedTo)
+		if err != nil {
+			return "", nil, ErrInvalidFilter
+		}
+		query += " AND eg.assigned_to = " + placeholder(assigneeID)
+	}
+
+	return query, args, nil
+}
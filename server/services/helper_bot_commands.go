@@ -0,0 +1,327 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxMuteDuration = 30 * 24 * time.Hour
+
+func (s *HelperBotService) handleLink(message *tgbotapi.Message) string {
+	code := strings.ToUpper(strings.TrimSpace(message.CommandArguments()))
+	if code == "" {
+		return "Usage: /link &lt;code&gt;\n\nGenerate a code in Vigil Eye: your account settings link your Telegram account, project settings link this chat."
+	}
+	if message.From == nil {
+		return "❌ Could not identify your Telegram account."
+	}
+
+	result, err := s.store.Redeem(code, message.Chat.ID, message.Chat.Title, message.From.ID, message.From.UserName)
+	if err == ErrInvalidLinkCode {
+		return "❌ That code is invalid or has expired. Generate a new one in Vigil Eye."
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error redeeming link code: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+
+	if result.Purpose == LinkPurposeChat {
+		return fmt.Sprintf("✅ <b>Chat linked!</b>\n\nThis chat is now linked to project <b>%s</b>. Try /errors or /subscribe &lt;env&gt;.", html.EscapeString(result.ProjectName))
+	}
+
+	_, name, err := s.store.LookupUser(message.From.ID)
+	if err != nil {
+		name = fmt.Sprintf("user #%d", result.UserID)
+	}
+	return fmt.Sprintf("✅ <b>Telegram linked!</b>\n\nThis Telegram account is now linked to %s. You can act on alerts with the inline buttons.", html.EscapeString(name))
+}
+
+// linkedProject resolves the chat's project or returns the reply explaining how to link it
+func (s *HelperBotService) linkedProject(chatID int64) (int, string, string) {
+	projectID, name, err := s.store.LinkedProject(chatID)
+	if err == ErrChatNotLinked {
+		return 0, "", "This chat is not linked to a project yet. Generate a chat link code in your Vigil Eye project settings and send <code>/link CODE</code> here."
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error loading linked project: %v", err)
+		return 0, "", "❌ Something went wrong, please try again."
+	}
+	return projectID, name, ""
+}
+
+// hiddenEnvironments returns the environments the chat and sender may not see, like the overrides
+// that hide environments from the dashboard, or the reply explaining the failure
+func (s *HelperBotService) hiddenEnvironments(message *tgbotapi.Message, projectID int) ([]int, string) {
+	var telegramUserID int64
+	if message.From != nil {
+		telegramUserID = message.From.ID
+	}
+	hidden, err := s.store.HiddenEnvironments(message.Chat.ID, projectID, telegramUserID)
+	if err == ErrChatNotLinked {
+		return nil, "This chat's link is no longer valid because whoever linked it has left the project. Generate a new chat link code in your Vigil Eye project settings and send <code>/link CODE</code> here."
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error loading environment access: %v", err)
+		return nil, "❌ Something went wrong, please try again."
+	}
+	return hidden, ""
+}
+
+func (s *HelperBotService) handleErrors(message *tgbotapi.Message) string {
+	projectID, projectName, reply := s.linkedProject(message.Chat.ID)
+	if reply != "" {
+		return reply
+	}
+	hidden, reply := s.hiddenEnvironments(message, projectID)
+	if reply != "" {
+		return reply
+	}
+
+	env := strings.TrimSpace(message.CommandArguments())
+	groups, err := s.store.TopUnresolved(projectID, env, hidden, 10)
+	if err != nil {
+		log.Printf("[Helper Bot] Error loading groups: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+
+	scope := html.EscapeString(projectName)
+	if env != "" {
+		scope += " / " + html.EscapeString(env)
+	}
+	if len(groups) == 0 {
+		return fmt.Sprintf("🎉 No unresolved errors in <b>%s</b>.", scope)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🔴 <b>Top unresolved errors</b> in %s\n\n", scope)
+	for _, g := range groups {
+		fmt.Fprintf(&b, "<code>#%d</code> [%s] %s\n    %d events, last seen %s\n",
+			g.ID, html.EscapeString(g.EnvironmentName), html.EscapeString(truncate(g.Message, 80)),
+			g.OccurrenceCount, humanizeSince(g.LastSeen))
+	}
+	b.WriteString("\nUse /group &lt;id&gt; for details.")
+	return b.String()
+}
+
+func (s *HelperBotService) handleGroup(message *tgbotapi.Message) string {
+	projectID, _, reply := s.linkedProject(message.Chat.ID)
+	if reply != "" {
+		return reply
+	}
+	hidden, reply := s.hiddenEnvironments(message, projectID)
+	if reply != "" {
+		return reply
+	}
+
+	groupID, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), "#"))
+	if err != nil {
+		return "Usage: /group &lt;id&gt;"
+	}
+
+	g, err := s.store.GetGroupSummary(projectID, groupID, hidden)
+	if err == ErrGroupNotFound {
+		return fmt.Sprintf("Error group #%d not found in this project.", groupID)
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error loading group: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>#%d</b> %s\n\n", g.ID, html.EscapeString(truncate(g.Message, 300)))
+	fmt.Fprintf(&b, "<b>Environment:</b> %s\n", html.EscapeString(g.EnvironmentName))
+	fmt.Fprintf(&b, "<b>Level:</b> %s\n", html.EscapeString(g.Level))
+	fmt.Fprintf(&b, "<b>Status:</b> %s", html.EscapeString(g.Status))
+	if g.SnoozedUntil != nil {
+		fmt.Fprintf(&b, " until %s", g.SnoozedUntil.UTC().Format("Jan 2 15:04 MST"))
+	}
+	fmt.Fprintf(&b, "\n<b>Occurrences:</b> %d\n", g.OccurrenceCount)
+	fmt.Fprintf(&b, "<b>First seen:</b> %s\n", humanizeSince(g.FirstSeen))
+	fmt.Fprintf(&b, "<b>Last seen:</b> %s\n", humanizeSince(g.LastSeen))
+
+	occurrences, err := s.store.RecentOccurrences(projectID, groupID, 5)
+	if err != nil {
+		log.Printf("[Helper Bot] Error loading occurrences: %v", err)
+	}
+	if len(occurrences) > 0 {
+		b.WriteString("\n<b>Recent occurrences:</b>\n")
+		for _, o := range occurrences {
+			line := o.Timestamp.UTC().Format("Jan 2 15:04:05")
+			if o.Method != nil && o.URL != nil {
+				line += " " + *o.Method + " " + *o.URL
+			} else if o.URL != nil {
+				line += " " + *o.URL
+			}
+			if o.StatusCode != nil {
+				line += fmt.Sprintf(" → %d", *o.StatusCode)
+			}
+			fmt.Fprintf(&b, "• %s\n", html.EscapeString(truncate(line, 120)))
+		}
+	}
+
+	return b.String()
+}
+
+func (s *HelperBotService) handleStats(message *tgbotapi.Message) string {
+	projectID, projectName, reply := s.linkedProject(message.Chat.ID)
+	if reply != "" {
+		return reply
+	}
+	hidden, reply := s.hiddenEnvironments(message, projectID)
+	if reply != "" {
+		return reply
+	}
+
+	stats, err := s.store.Stats(projectID, time.Now().Add(-24*time.Hour), hidden)
+	if err != nil {
+		log.Printf("[Helper Bot] Error loading stats: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 <b>Last 24h</b> in %s\n\n", html.EscapeString(projectName))
+	for _, st := range stats {
+		fmt.Fprintf(&b, "<b>%s</b>: %d events, %d new, %d unresolved\n",
+			html.EscapeString(st.EnvironmentName), st.Events, st.NewGroups, st.Unresolved)
+	}
+	return b.String()
+}
+
+func (s *HelperBotService) handleMute(message *tgbotapi.Message) string {
+	projectID, _, reply := s.linkedProject(message.Chat.ID)
+	if reply != "" {
+		return reply
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 {
+		return "Usage: /mute &lt;id&gt; &lt;duration&gt; (e.g. 30m, 2h, 1d)"
+	}
+	groupID, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		return "Usage: /mute &lt;id&gt; &lt;duration&gt; (e.g. 30m, 2h, 1d)"
+	}
+	duration, err := ParseMuteDuration(args[1])
+	if err != nil {
+		return html.EscapeString(err.Error())
+	}
+
+	// Muting changes state, so it is done as the linked Vigil Eye user
+	if message.From == nil {
+		return "❌ Could not identify your Telegram account."
+	}
+	userID, _, err := s.store.LookupUser(message.From.ID)
+	if err == ErrTelegramNotLinked {
+		return "Link your Telegram account first: generate a code in your Vigil Eye account settings and send <code>/link CODE</code> to me in a private chat."
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error looking up user: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+	allowed, err := s.store.CanTriage(projectID, groupID, userID)
+	if err == ErrGroupNotFound {
+		return fmt.Sprintf("Error group #%d not found in this project.", groupID)
+	}
+	if err != nil || !allowed {
+		return "You are not a member of this project or can't triage its errors."
+	}
+
+	until := time.Now().Add(duration)
+	err = s.store.Snooze(projectID, groupID, userID, until)
+	if err == ErrGroupNotFound {
+		return fmt.Sprintf("Error group #%d not found in this project.", groupID)
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error snoozing group: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+
+	return fmt.Sprintf("🔕 Muted <code>#%d</code> until %s.", groupID, until.UTC().Format("Jan 2 15:04 MST"))
+}
+
+func (s *HelperBotService) handleSubscribe(message *tgbotapi.Message, subscribed bool) string {
+	projectID, _, reply := s.linkedProject(message.Chat.ID)
+	if reply != "" {
+		return reply
+	}
+
+	env := strings.TrimSpace(message.CommandArguments())
+	if env == "" {
+		if subscribed {
+			return "Usage: /subscribe &lt;environment&gt;"
+		}
+		return "Usage: /unsubscribe &lt;environment&gt;"
+	}
+
+	var hidden []int
+	if subscribed {
+		hidden, reply = s.hiddenEnvironments(message, projectID)
+		if reply != "" {
+			return reply
+		}
+	}
+
+	err := s.store.SetSubscription(message.Chat.ID, projectID, env, subscribed, hidden)
+	if err == ErrEnvironmentNotFound {
+		return fmt.Sprintf("Environment <b>%s</b> not found in this project.", html.EscapeString(env))
+	}
+	if err != nil {
+		log.Printf("[Helper Bot] Error updating subscription: %v", err)
+		return "❌ Something went wrong, please try again."
+	}
+
+	if subscribed {
+		return fmt.Sprintf("🔔 This chat will now receive alerts for <b>%s</b>.", html.EscapeString(env))
+	}
+	return fmt.Sprintf("🔕 This chat will no longer receive alerts for <b>%s</b>.", html.EscapeString(env))
+}
+
+// ParseMuteDuration accepts Go durations plus a "d" suffix for days
+func ParseMuteDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q, use e.g. 30m, 2h or 1d", s)
+	}
+	if d > maxMuteDuration {
+		return 0, fmt.Errorf("duration %q is too long, the maximum is 30d", s)
+	}
+	return d, nil
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(strings.SplitN(s, "\n", 2)[0])
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func humanizeSince(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
@@ -1,19 +1,43 @@
 package services
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prabalesh/vigileye/models"
 )
 
+// HelperBotStore is the data the helper bot reads and changes on behalf of linked chats
+type HelperBotStore interface {
+	Redeem(code string, chatID int64, chatTitle string, telegramUserID int64, telegramUsername string) (*LinkResult, error)
+	LookupUser(telegramUserID int64) (int, string, error)
+	LinkedProject(chatID int64) (int, string, error)
+	HiddenEnvironments(chatID int64, projectID int, telegramUserID int64) ([]int, error)
+	SetSubscription(chatID int64, projectID int, environmentName string, subscribed bool, exclude []int) error
+	CanTriage(projectID, groupID, userID int) (bool, error)
+	TopUnresolved(projectID int, environmentName string, exclude []int, limit int) ([]GroupSummary, error)
+	GetGroupSummary(projectID, groupID int, exclude []int) (*GroupSummary, error)
+	RecentOccurrences(projectID, groupID, limit int) ([]models.ErrorLog, error)
+	Stats(projectID int, since time.Time, exclude []int) ([]EnvironmentStats, error)
+	Snooze(projectID, groupID, userID int, until time.Time) error
+}
+
+type helperBotStore struct {
+	*TelegramLinkService
+	*ErrorGroupService
+}
+
 type HelperBotService struct {
-	bot *tgbotapi.BotAPI
+	bot   *tgbotapi.BotAPI
+	store HelperBotStore
 }
 
-func NewHelperBotService() (*HelperBotService, error) {
+func NewHelperBotService(db *sql.DB) (*HelperBotService, error) {
 	botToken := os.Getenv("TELEGRAM_HELPER_BOT_TOKEN")
 	if botToken == "" {
 		return nil, fmt.Errorf("TELEGRAM_HELPER_BOT_TOKEN not set")
@@ -26,7 +50,16 @@ func NewHelperBotService() (*HelperBotService, error) {
 
 	log.Printf("✅ Vigil Eye Helper Bot started: @%s", bot.Self.UserName)
 
-	return &HelperBotService{bot: bot}, nil
+	store := &helperBotStore{
+		TelegramLinkService: NewTelegramLinkService(db),
+		ErrorGroupService:   NewErrorGroupService(db),
+	}
+	return NewHelperBotServiceWithBot(bot, store), nil
+}
+
+// NewHelperBotServiceWithBot wires an existing bot client, e.g. one pointed at a fake Bot API server
+func NewHelperBotServiceWithBot(bot *tgbotapi.BotAPI, store HelperBotStore) *HelperBotService {
+	return &HelperBotService{bot: bot, store: store}
 }
 
 // Start begins listening for Telegram commands (blocking)
@@ -36,30 +69,49 @@ func (s *HelperBotService) Start() {
 
 	updates := s.bot.GetUpdatesChan(u)
 
-	log.Println("📱 Helper bot listening for /chatid commands...")
+	log.Println("📱 Helper bot listening for commands...")
 
 	for update := range updates {
-		if update.Message == nil || !update.Message.IsCommand() {
-			continue
-		}
-
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
-		msg.ParseMode = "Markdown"
-
-		switch update.Message.Command() {
-		case "start":
-			msg.Text = s.handleStart()
-		case "help":
-			msg.Text = s.handleHelp()
-		case "chatid":
-			msg.Text = s.handleChatID(update.Message.Chat)
-		default:
-			msg.Text = "Unknown command. Use /help to see available commands."
-		}
-
-		if _, err := s.bot.Send(msg); err != nil {
-			log.Printf("[Helper Bot] Error sending message: %v", err)
-		}
+		s.HandleUpdate(update)
+	}
+}
+
+// HandleUpdate answers a single command message
+func (s *HelperBotService) HandleUpdate(update tgbotapi.Update) {
+	if update.Message == nil || !update.Message.IsCommand() {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+	msg.ParseMode = "Markdown"
+
+	switch update.Message.Command() {
+	case "start":
+		msg.Text = s.handleStart()
+	case "help":
+		msg.Text = s.handleHelp()
+	case "chatid":
+		msg.Text = s.handleChatID(update.Message.Chat)
+	case "link":
+		msg.ParseMode, msg.Text = "HTML", s.handleLink(update.Message)
+	case "errors":
+		msg.ParseMode, msg.Text = "HTML", s.handleErrors(update.Message)
+	case "group":
+		msg.ParseMode, msg.Text = "HTML", s.handleGroup(update.Message)
+	case "stats":
+		msg.ParseMode, msg.Text = "HTML", s.handleStats(update.Message)
+	case "mute":
+		msg.ParseMode, msg.Text = "HTML", s.handleMute(update.Message)
+	case "subscribe":
+		msg.ParseMode, msg.Text = "HTML", s.handleSubscribe(update.Message, true)
+	case "unsubscribe":
+		msg.ParseMode, msg.Text = "HTML", s.handleSubscribe(update.Message, false)
+	default:
+		msg.Text = "Unknown command. Use /help to see available commands."
+	}
+
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("[Helper Bot] Error sending message: %v", err)
 	}
 }
 
@@ -82,6 +134,13 @@ func (s *HelperBotService) handleHelp() string {
 
 *Available Commands:*
 • /chatid - Get the chat ID of current group/channel
+• /link <code> - Link your account or this chat to Vigil Eye
+• /errors [env] - Top unresolved errors
+• /group <id> - Error group details
+• /stats - Event counts for the last 24h
+• /mute <id> <duration> - Snooze a group (e.g. 30m, 2h, 1d)
+• /subscribe <env> - Mirror alerts for an environment here
+• /unsubscribe <env> - Stop mirroring alerts
 • /help - Show this help message
 • /start - Show welcome message
 
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prabalesh/vigileye/models"
+)
+
+type sentMessage struct {
+	ChatID    string
+	Text      string
+	ParseMode string
+}
+
+// fakeTelegramAPI answers getMe and records sendMessage calls
+type fakeTelegramAPI struct {
+	mu   sync.Mutex
+	sent []sentMessage
+}
+
+func (f *fakeTelegramAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getMe"):
+		w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Vigil","username":"vigil_helper_bot"}}`))
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		r.ParseForm()
+		f.mu.Lock()
+		f.sent = append(f.sent, sentMessage{
+			ChatID:    r.FormValue("chat_id"),
+			Text:      r.FormValue("text"),
+			ParseMode: r.FormValue("parse_mode"),
+		})
+		f.mu.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"group"}}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"ok":false,"description":"Not Found"}`))
+	}
+}
+
+func (f *fakeTelegramAPI) last(t *testing.T) sentMessage {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.sent) == 0 {
+		t.Fatal("Expected a message to be sent")
+	}
+	return f.sent[len(f.sent)-1]
+}
+
+type fakeHelperBotStore struct {
+	linkedChats   map[int64]int
+	users         map[int64]int
+	members       map[int]bool
+	hidden        []int
+	groups        []GroupSummary
+	snoozed       map[int]time.Time
+	subscriptions map[string]bool
+	redeemed      string
+}
+
+func newFakeHelperBotStore() *fakeHelperBotStore {
+	return &fakeHelperBotStore{
+		linkedChats:   map[int64]int{-100: 7},
+		users:         map[int64]int{42: 3},
+		members:       map[int]bool{3: true},
+		snoozed:       map[int]time.Time{},
+		subscriptions: map[string]bool{},
+		groups: []GroupSummary{
+			{ID: 11, Message: "TypeError: <nil> is not a function", Level: "error", Status: "unresolved", EnvironmentName: "production", OccurrenceCount: 5, FirstSeen: time.Now().Add(-2 * time.Hour), LastSeen: time.Now()},
+		},
+	}
+}
+
+func (f *fakeHelperBotStore) Redeem(code string, chatID int64, chatTitle string, telegramUserID int64, telegramUsername string) (*LinkResult, error) {
+	if code != "ABC123" {
+		return nil, ErrInvalidLinkCode
+	}
+	f.redeemed = code
+	f.linkedChats[chatID] = 7
+	return &LinkResult{Purpose: LinkPurposeChat, UserID: 3, ProjectID: 7, ProjectName: "Shop"}, nil
+}
+
+func (f *fakeHelperBotStore) LookupUser(telegramUserID int64) (int, string, error) {
+	if id, ok := f.users[telegramUserID]; ok {
+		return id, "dev@example.com", nil
+	}
+	return 0, "", ErrTelegramNotLinked
+}
+
+func (f *fakeHelperBotStore) LinkedProject(chatID int64) (int, string, error) {
+	if id, ok := f.linkedChats[chatID]; ok {
+		return id, "Shop", nil
+	}
+	return 0, "", ErrChatNotLinked
+}
+
+func (f *fakeHelperBotStore) SetSubscription(chatID int64, projectID int, environmentName string, subscribed bool, exclude []int) error {
+	if environmentName != "production" || (subscribed && excluded(environmentName, exclude)) {
+		return ErrEnvironmentNotFound
+	}
+	f.subscriptions[environmentName] = subscribed
+	return nil
+}
+
+func (f *fakeHelperBotStore) CanTriage(projectID, groupID, userID int) (bool, error) {
+	return f.members[userID], nil
+}
+
+func (f *fakeHelperBotStore) HiddenEnvironments(chatID int64, projectID int, telegramUserID int64) ([]int, error) {
+	return f.hidden, nil
+}
+
+// fakeEnvironmentIDs gives the fake store's environments the IDs used to hide them
+var fakeEnvironmentIDs = map[string]int{"production": 1}
+
+func excluded(environmentName string, exclude []int) bool {
+	for _, id := range exclude {
+		if fakeEnvironmentIDs[environmentName] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeHelperBotStore) TopUnresolved(projectID int, environmentName string, exclude []int, limit int) ([]GroupSummary, error) {
+	groups := []GroupSummary{}
+	for _, g := range f.groups {
+		if !excluded(g.EnvironmentName, exclude) {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func (f *fakeHelperBotStore) GetGroupSummary(projectID, groupID int, exclude []int) (*GroupSummary, error) {
+	for _, g := range f.groups {
+		if g.ID == groupID && !excluded(g.EnvironmentName, exclude) {
+			return &g, nil
+		}
+	}
+	return nil, ErrGroupNotFound
+}
+
+func (f *fakeHelperBotStore) RecentOccurrences(projectID, groupID, limit int) ([]models.ErrorLog, error) {
+	url, method := "/checkout", "POST"
+	return []models.ErrorLog{{Timestamp: time.Now(), URL: &url, Method: &method}}, nil
+}
+
+func (f *fakeHelperBotStore) Stats(projectID int, since time.Time, exclude []int) ([]EnvironmentStats, error) {
+	if excluded("production", exclude) {
+		return []EnvironmentStats{}, nil
+	}
+	return []EnvironmentStats{{EnvironmentName: "production", Events: 12, NewGroups: 1, Unresolved: 4}}, nil
+}
+
+func (f *fakeHelperBotStore) Snooze(projectID, groupID, userID int, until time.Time) error {
+	if groupID != 11 {
+		return ErrGroupNotFound
+	}
+	f.snoozed[groupID] = until
+	return nil
+}
+
+func newTestHelperBot(t *testing.T) (*HelperBotService, *fakeTelegramAPI, *fakeHelperBotStore) {
+	t.Helper()
+	api := &fakeTelegramAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("test-token", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	store := newFakeHelperBotStore()
+	return NewHelperBotServiceWithBot(bot, store), api, store
+}
+
+func command(chatID, fromID int64, text string) tgbotapi.Update {
+	name := strings.Fields(text)[0]
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		Text:     text,
+		Chat:     &tgbotapi.Chat{ID: chatID, Type: "group", Title: "Team"},
+		From:     &tgbotapi.User{ID: fromID, UserName: "dev"},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(name)}},
+	}}
+}
+
+func TestHelperBotErrorsEscapesHTML(t *testing.T) {
+	bot, api, _ := newTestHelperBot(t)
+
+	bot.HandleUpdate(command(-100, 42, "/errors"))
+
+	msg := api.last(t)
+	if msg.ChatID != "-100" || msg.ParseMode != "HTML" {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+	if !strings.Contains(msg.Text, "#11") || !strings.Contains(msg.Text, "&lt;nil&gt;") {
+		t.Errorf("Expected escaped group listing, got %q", msg.Text)
+	}
+}
+
+func TestHelperBotRequiresLinkedChat(t *testing.T) {
+	bot, api, _ := newTestHelperBot(t)
+
+	for _, cmd := range []string{"/errors", "/stats", "/group 11", "/mute 11 1h", "/subscribe production"} {
+		bot.HandleUpdate(command(-200, 42, cmd))
+		if !strings.Contains(api.last(t).Text, "not linked") {
+			t.Errorf("%s: expected not linked reply, got %q", cmd, api.last(t).Text)
+		}
+	}
+}
+
+func TestHelperBotLinkChat(t *testing.T) {
+	bot, api, store := newTestHelperBot(t)
+
+	bot.HandleUpdate(command(-300, 42, "/link nope"))
+	if !strings.Contains(api.last(t).Text, "invalid") {
+		t.Errorf("Expected invalid code reply, got %q", api.last(t).Text)
+	}
+
+	bot.HandleUpdate(command(-300, 42, "/link abc123"))
+	if store.redeemed != "ABC123" || !strings.Contains(api.last(t).Text, "Chat linked") {
+		t.Errorf("Expected chat to be linked, got %q", api.last(t).Text)
+	}
+
+	bot.HandleUpdate(command(-300, 42, "/stats"))
+	if !strings.Contains(api.last(t).Text, "12 events") {
+		t.Errorf("Expected stats after linking, got %q", api.last(t).Text)
+	}
+}
+
+func TestHelperBotGroup(t *testing.T) {
+	bot, api, _ := newTestHelperBot(t)
+
+	bot.HandleUpdate(command(-100, 42, "/group 11"))
+	if text := api.last(t).Text; !strings.Contains(text, "POST /checkout") || !strings.Contains(text, "Occurrences:</b> 5") {
+		t.Errorf("Unexpected group reply: %q", text)
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/group 99"))
+	if !strings.Contains(api.last(t).Text, "not found") {
+		t.Errorf("Expected not found reply, got %q", api.last(t).Text)
+	}
+}
+
+func TestHelperBotHidesDeniedEnvironments(t *testing.T) {
+	bot, api, store := newTestHelperBot(t)
+	store.hidden = []int{fakeEnvironmentIDs["production"]}
+
+	bot.HandleUpdate(command(-100, 42, "/errors"))
+	if text := api.last(t).Text; strings.Contains(text, "#11") {
+		t.Errorf("Expected production groups to be hidden, got %q", text)
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/group 11"))
+	if !strings.Contains(api.last(t).Text, "not found") {
+		t.Errorf("Expected a hidden group to be not found, got %q", api.last(t).Text)
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/stats"))
+	if strings.Contains(api.last(t).Text, "production") {
+		t.Errorf("Expected production stats to be hidden, got %q", api.last(t).Text)
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/subscribe production"))
+	if store.subscriptions["production"] || !strings.Contains(api.last(t).Text, "not found") {
+		t.Errorf("Expected a hidden environment to refuse subscriptions, got %q", api.last(t).Text)
+	}
+}
+
+func TestHelperBotMute(t *testing.T) {
+	bot, api, store := newTestHelperBot(t)
+
+	bot.HandleUpdate(command(-100, 99, "/mute 11 1h"))
+	if !strings.Contains(api.last(t).Text, "Link your Telegram account") {
+		t.Errorf("Expected unlinked user to be refused, got %q", api.last(t).Text)
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/mute 11 forever"))
+	if !strings.Contains(api.last(t).Text, "invalid duration") {
+		t.Errorf("Expected invalid duration reply, got %q", api.last(t).Text)
+	}
+
+	before := time.Now()
+	bot.HandleUpdate(command(-100, 42, "/mute 11 2h"))
+	until, ok := store.snoozed[11]
+	if !ok || until.Before(before.Add(2*time.Hour)) {
+		t.Fatalf("Expected group to be snoozed for 2h, got %v", until)
+	}
+	if !strings.Contains(api.last(t).Text, "Muted") {
+		t.Errorf("Unexpected mute reply: %q", api.last(t).Text)
+	}
+
+	store.members[3] = false
+	bot.HandleUpdate(command(-100, 42, "/mute 11 2h"))
+	if !strings.Contains(api.last(t).Text, "not a member") {
+		t.Errorf("Expected non-member to be refused, got %q", api.last(t).Text)
+	}
+}
+
+func TestHelperBotSubscribe(t *testing.T) {
+	bot, api, store := newTestHelperBot(t)
+
+	bot.HandleUpdate(command(-100, 42, "/subscribe production"))
+	if !store.subscriptions["production"] {
+		t.Error("Expected subscription to production")
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/unsubscribe production"))
+	if store.subscriptions["production"] {
+		t.Error("Expected production to be unsubscribed")
+	}
+
+	bot.HandleUpdate(command(-100, 42, "/subscribe staging"))
+	if !strings.Contains(api.last(t).Text, "not found") {
+		t.Errorf("Expected unknown environment reply, got %q", api.last(t).Text)
+	}
+}
+
+func TestParseMuteDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30m", 30 * time.Minute, false},
+		{"2h", 2 * time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"31d", 0, true},
+		{"-1h", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMuteDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMuteDuration(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
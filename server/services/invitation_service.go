@@ -0,0 +1,341 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const invitationTokenPurpose = "invitation"
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrInvitationExists   = errors.New("an invitation is already pending for this email")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExpired  = errors.New("invitation has expired")
+	ErrInvitationEmail    = errors.New("invitation was sent to a different email address")
+	ErrInvitationDelivery = errors.New("failed to send invitation email")
+	ErrAlreadyMember      = errors.New("already a member of this project")
+)
+
+// Invitation is a pending offer to join a project, addressed to an email rather than a user
+type Invitation struct {
+	ID          int       `json:"id"`
+	ProjectID   int       `json:"project_id"`
+	ProjectName string    `json:"project_name,omitempty"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	InvitedBy   *int      `json:"invited_by"`
+	InviterName string    `json:"inviter_name,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	LastSentAt  time.Time `json:"last_sent_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	Expired     bool      `json:"expired"`
+}
+
+// InvitationService issues, mails and redeems project invitations. Tokens are signed with the
+// server secret and only their hash is stored, so a database leak doesn't expose usable links.
+type InvitationService struct {
+	db      *sql.DB
+	mailer  Mailer
+	secret  string
+	baseURL string
+	ttl     time.Duration
+}
+
+func NewInvitationService(db *sql.DB, cfg config.Config) *InvitationService {
+	return &InvitationService{
+		db:      db,
+		mailer:  NewMailer(cfg),
+		secret:  cfg.SecretKey,
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		ttl:     time.Duration(cfg.InvitationExpiryDays) * 24 * time.Hour,
+	}
+}
+
+// Create records an invitation and emails its link. Nothing is stored if the email can't be sent.
+func (s *InvitationService) Create(projectID, invitedBy int, email, role string) (*Invitation, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+	email = strings.ToLower(addr.Address)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var member bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM project_members pm JOIN users u ON u.id = pm.user_id
+			WHERE pm.project_id = $1 AND LOWER(u.email) = $2
+		)
+	`, projectID, email).Scan(&member)
+	if err != nil {
+		return nil, err
+	}
+	if member {
+		return nil, ErrAlreadyMember
+	}
+
+	var pending bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM project_invitations
+			WHERE project_id = $1 AND LOWER(email) = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+		)
+	`, projectID, email).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, ErrInvitationExists
+	}
+
+	inv := &Invitation{ProjectID: projectID, Email: email, Role: role, InvitedBy: &invitedBy}
+	inv.ExpiresAt = time.Now().Add(s.ttl)
+	err = tx.QueryRow(`
+		INSERT INTO project_invitations (project_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, '', $4, $5)
+		RETURNING id, last_sent_at, created_at
+	`, projectID, email, role, invitedBy, inv.ExpiresAt).Scan(&inv.ID, &inv.LastSentAt, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.issue(tx, inv); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Resend mails a fresh link and extends the expiry. The previous link stops working.
+func (s *InvitationService) Resend(projectID, invitationID int) (*Invitation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv := &Invitation{}
+	err = tx.QueryRow(`
+		SELECT id, project_id, email, role, invited_by, created_at
+		FROM project_invitations
+		WHERE id = $1 AND project_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+		FOR UPDATE
+	`, invitationID, projectID).Scan(&inv.ID, &inv.ProjectID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	inv.ExpiresAt = time.Now().Add(s.ttl)
+	if err := s.issue(tx, inv); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// issue signs a new token for inv, stores its hash and emails the link
+func (s *InvitationService) issue(tx *sql.Tx, inv *Invitation) error {
+	token := utils.SignToken(invitationTokenPurpose, strconv.Itoa(inv.ID), inv.ExpiresAt, s.secret)
+
+	err := tx.QueryRow(`
+		UPDATE project_invitations SET token_hash = $1, expires_at = $2, last_sent_at = NOW()
+		WHERE id = $3
+		RETURNING last_sent_at
+	`, utils.HashToken(token), inv.ExpiresAt, inv.ID).Scan(&inv.LastSentAt)
+	if err != nil {
+		return err
+	}
+
+	var inviter sql.NullString
+	err = tx.QueryRow(`
+		SELECT p.name, u.name
+		FROM projects p
+		LEFT JOIN users u ON u.id = $2
+		WHERE p.id = $1
+	`, inv.ProjectID, inv.InvitedBy).Scan(&inv.ProjectName, &inviter)
+	if err != nil {
+		return err
+	}
+	inv.InviterName = inviter.String
+	if inv.InviterName == "" {
+		inv.InviterName = "A teammate"
+	}
+
+	subject := fmt.Sprintf("You're invited to join %s on Vigil Eye", inv.ProjectName)
+	body := fmt.Sprintf(
+		"%s invited you to join %s on Vigil Eye as %s.\n\n"+
+			"Accept the invitation:\n%s/invite?token=%s\n\n"+
+			"This link expires on %s. If you weren't expecting this email, you can ignore it.\n",
+		inv.InviterName, inv.ProjectName, inv.Role,
+		s.baseURL, token,
+		inv.ExpiresAt.UTC().Format("Jan 2, 2006 15:04 MST"),
+	)
+	if err := s.mailer.Send(inv.Email, subject, body); err != nil {
+		log.Printf("[InvitationService] Error sending invitation %d: %v", inv.ID, err)
+		return ErrInvitationDelivery
+	}
+	return nil
+}
+
+// Revoke cancels a pending invitation
+func (s *InvitationService) Revoke(projectID, invitationID int) error {
+	res, err := s.db.Exec(`
+		UPDATE project_invitations SET revoked_at = NOW()
+		WHERE id = $1 AND project_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+	`, invitationID, projectID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// ListPending returns the project's open invitations, including expired ones that can be resent
+func (s *InvitationService) ListPending(projectID int) ([]Invitation, error) {
+	rows, err := s.db.Query(`
+		SELECT i.id, i.project_id, i.email, i.role, i.invited_by, COALESCE(u.name, ''),
+		       i.expires_at, i.last_sent_at, i.created_at
+		FROM project_invitations i
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.project_id = $1 AND i.accepted_at IS NULL AND i.revoked_at IS NULL
+		ORDER BY i.created_at DESC
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.ID, &inv.ProjectID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.InviterName,
+			&inv.ExpiresAt, &inv.LastSentAt, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		inv.Expired = !now.Before(inv.ExpiresAt)
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// Lookup resolves a token to its pending invitation, so the invite page can show what's on offer
+func (s *InvitationService) Lookup(token string) (*Invitation, error) {
+	return s.lookup(s.db, token, false)
+}
+
+// Accept adds userID to the invited project. The user's email must match the invitation.
+func (s *InvitationService) Accept(token string, userID int) (*Invitation, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv, err := s.AcceptTx(tx, token, userID)
+	if err != nil {
+		return nil, err
+	}
+	return inv, tx.Commit()
+}
+
+// AcceptTx is Accept within the caller's transaction, so registration and acceptance
+// succeed or fail together
+func (s *InvitationService) AcceptTx(tx *sql.Tx, token string, userID int) (*Invitation, error) {
+	inv, err := s.lookup(tx, token, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var email string
+	if err := tx.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(email, inv.Email) {
+		return nil, ErrInvitationEmail
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO project_members (project_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (project_id, user_id) DO NOTHING
+	`, inv.ProjectID, userID, inv.Role)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	_, err = tx.Exec(`
+		UPDATE project_invitations SET accepted_at = NOW(), accepted_by = $1 WHERE id = $2
+	`, userID, inv.ID)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (s *InvitationService) lookup(q queryer, token string, forUpdate bool) (*Invitation, error) {
+	subject, err := utils.VerifySignedToken(token, invitationTokenPurpose, s.secret, time.Now())
+	if err == utils.ErrTokenExpired {
+		return nil, ErrInvitationExpired
+	}
+	if err != nil {
+		return nil, ErrInvitationNotFound
+	}
+	id, err := strconv.Atoi(subject)
+	if err != nil {
+		return nil, ErrInvitationNotFound
+	}
+
+	query := `
+		SELECT i.id, i.project_id, p.name, i.email, i.role, i.invited_by, COALESCE(u.name, ''),
+		       i.expires_at, i.last_sent_at, i.created_at
+		FROM project_invitations i
+		JOIN projects p ON p.id = i.project_id
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.id = $1 AND i.token_hash = $2 AND i.accepted_at IS NULL AND i.revoked_at IS NULL
+		  AND p.deleted_at IS NULL
+	`
+	if forUpdate {
+		query += " FOR UPDATE OF i"
+	}
+
+	inv := &Invitation{}
+	err = q.QueryRow(query, id, utils.HashToken(token)).Scan(&inv.ID, &inv.ProjectID, &inv.ProjectName,
+		&inv.Email, &inv.Role, &inv.InvitedBy, &inv.InviterName, &inv.ExpiresAt, &inv.LastSentAt, &inv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(inv.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+	return inv, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	// Failed sign-ins allowed before an account or client is locked out
+	accountLoginThreshold = 5
+	ipLoginThreshold      = 20
+	// The first lockout lasts loginLockoutBase and doubles with every further failure
+	loginLockoutBase = time.Minute
+	loginLockoutMax  = time.Hour
+	// Counters start over after this long without failures
+	loginFailureWindow = 24 * time.Hour
+)
+
+// LoginThrottle locks out accounts and client IPs after repeated failed sign-ins
+type LoginThrottle struct {
+	db *sql.DB
+}
+
+func NewLoginThrottle(db *sql.DB) *LoginThrottle {
+	return &LoginThrottle{db: db}
+}
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginLockout is how long to lock out after the given number of consecutive failures
+func loginLockout(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	lockout := loginLockoutBase
+	for i := threshold; i < failures && lockout < loginLockoutMax; i++ {
+		lockout *= 2
+	}
+	return min(lockout, loginLockoutMax)
+}
+
+// RetryAfter reports how long the account or client is still locked out; zero means sign-in may
+// be attempted
+func (t *LoginThrottle) RetryAfter(email, ip string) (time.Duration, error) {
+	var seconds sql.NullFloat64
+	err := t.db.QueryRow(`
+		SELECT EXTRACT(EPOCH FROM MAX(locked_until) - NOW())
+		FROM login_throttles
+		WHERE key IN ($1, $2) AND locked_until > NOW()
+	`, accountThrottleKey(email), ipThrottleKey(ip)).Scan(&seconds)
+	if err != nil || !seconds.Valid {
+		return 0, err
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
+
+// RecordFailure counts a failed sign-in and returns the lockout it triggered, if any
+func (t *LoginThrottle) RecordFailure(email, ip string) (time.Duration, error) {
+	var locked time.Duration
+	for _, k := range []struct {
+		key       string
+		threshold int
+	}{
+		{accountThrottleKey(email), accountLoginThreshold},
+		{ipThrottleKey(ip), ipLoginThreshold},
+	} {
+		var failures int
+		err := t.db.QueryRow(`
+			INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, NOW())
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_throttles.last_failure_at < NOW() - make_interval(secs => $2)
+					THEN 1 ELSE login_throttles.failures + 1 END,
+				last_failure_at = NOW()
+			RETURNING failures
+		`, k.key, loginFailureWindow.Seconds()).Scan(&failures)
+		if err != nil {
+			return 0, err
+		}
+
+		lockout := loginLockout(failures, k.threshold)
+		if lockout == 0 {
+			continue
+		}
+		_, err = t.db.Exec(`
+			UPDATE login_throttles SET locked_until = NOW() + make_interval(secs => $2) WHERE key = $1
+		`, k.key, lockout.Seconds())
+		if err != nil {
+			return 0, err
+		}
+		locked = max(locked, lockout)
+	}
+	return locked, nil
+}
+
+// RecordSuccess clears the account's failures. The client's counter is left alone so one valid
+// login can't be used to keep guessing at other accounts.
+func (t *LoginThrottle) RecordSuccess(email string) error {
+	_, err := t.db.Exec("DELETE FROM login_throttles WHERE key = $1", accountThrottleKey(email))
+	return err
+}
+
+// PruneLoginThrottles removes counters that have expired
+func (t *LoginThrottle) PruneLoginThrottles(ctx context.Context) error {
+	_, err := t.db.ExecContext(ctx, `
+		DELETE FROM login_throttles
+		WHERE last_failure_at < NOW() - make_interval(secs => $1)
+		  AND (locked_until IS NULL OR locked_until < NOW())
+	`, loginFailureWindow.Seconds())
+	return err
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLockout(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{11, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := loginLockout(tt.failures, accountLoginThreshold); got != tt.want {
+			t.Errorf("loginLockout(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
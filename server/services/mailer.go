@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/prabalesh/vigileye/config"
+)
+
+var ErrInvalidMailHeader = errors.New("mail headers must not contain line breaks")
+
+// Mailer sends plain-text email
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer returns an SMTP mailer, or one that only logs messages when SMTP isn't configured.
+// Bodies carry invitation and password reset links, so they are only logged in development.
+func NewMailer(cfg config.Config) Mailer {
+	if cfg.SMTPHost == "" {
+		return logMailer{logBody: cfg.Env == "development"}
+	}
+	return &SMTPMailer{
+		Addr:     net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	}
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return ErrInvalidMailHeader
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", m.From, err)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.Addr, auth, from.Address, []string{to}, []byte(msg.String()))
+}
+
+type logMailer struct {
+	logBody bool
+}
+
+func (m logMailer) Send(to, subject, body string) error {
+	if m.logBody {
+		log.Printf("[Mailer] SMTP not configured, not sending %q to %s:\n%s", subject, to, body)
+	} else {
+		log.Printf("[Mailer] SMTP not configured, not sending %q to %s", subject, to)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prabalesh/vigileye/config"
+)
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer accepts one message on a local port, speaking just enough SMTP for net/smtp
+func fakeSMTPServer(t *testing.T) (string, <-chan receivedMail) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan receivedMail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		var m receivedMail
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				m.from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<> ")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				m.to = append(m.to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<> "))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				m.data = data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				received <- m
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	mailer := &SMTPMailer{Addr: addr, From: "Vigil Eye <no-reply@example.com>"}
+
+	err := mailer.Send("dev@example.com", "Join Checkout on Vigil Eye", "Hello,\nAccept: https://example.com/invite?token=abc")
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	m := <-received
+	if m.from != "no-reply@example.com" {
+		t.Errorf("expected envelope sender no-reply@example.com, got %q", m.from)
+	}
+	if len(m.to) != 1 || m.to[0] != "dev@example.com" {
+		t.Errorf("expected recipient dev@example.com, got %v", m.to)
+	}
+	for _, want := range []string{
+		"To: dev@example.com\r\n",
+		"Subject: Join Checkout on Vigil Eye\r\n",
+		"Hello,\r\nAccept: https://example.com/invite?token=abc",
+	} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("expected message to contain %q, got:\n%s", want, m.data)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	mailer := &SMTPMailer{Addr: "127.0.0.1:1", From: "no-reply@example.com"}
+	if err := mailer.Send("dev@example.com\r\nBcc: victim@example.com", "hi", "body"); err != ErrInvalidMailHeader {
+		t.Errorf("expected ErrInvalidMailHeader, got %v", err)
+	}
+}
+
+func TestLogMailerOnlyLogsBodiesInDevelopment(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	NewMailer(config.Config{Env: "production"}).Send("dev@example.com", "Reset your password", "token=secret")
+	if out := buf.String(); !strings.Contains(out, "dev@example.com") || strings.Contains(out, "token=secret") {
+		t.Errorf("Expected only the recipient and subject in production, logged %q", out)
+	}
+
+	buf.Reset()
+	NewMailer(config.Config{Env: "development"}).Send("dev@example.com", "Reset your password", "token=secret")
+	if !strings.Contains(buf.String(), "token=secret") {
+		t.Errorf("Expected the body to be logged in development, logged %q", buf.String())
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrMergeTargets         = errors.New("select at least one other group to merge")
+	ErrMergeEnvironments    = errors.New("only groups from the same environment can be merged")
+	ErrFingerprintNotMerged = errors.New("fingerprint is not merged into this group")
+)
+
+// GroupFingerprint is one fingerprint routed to a group; Primary is the group's own
+type GroupFingerprint struct {
+	Fingerprint string     `json:"fingerprint"`
+	Primary     bool       `json:"primary"`
+	Occurrences int        `json:"occurrences"`
+	MergedBy    *int       `json:"merged_by,omitempty"`
+	MergedAt    *time.Time `json:"merged_at,omitempty"`
+}
+
+// Merge folds sourceIDs into targetID: logs move over, counts are summed, first/last seen widen,
+// and the sources' fingerprints keep routing new events to the target
+func (s *ErrorGroupService) Merge(projectID, targetID int, sourceIDs []int, userID int) error {
+	sources := []int{}
+	for _, id := range sourceIDs {
+		if id != targetID {
+			sources = append(sources, id)
+		}
+	}
+	if len(sources) == 0 {
+		return ErrMergeTargets
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, environment_id FROM error_groups
+		WHERE project_id = $1 AND id = ANY($2)
+		ORDER BY id
+		FOR UPDATE
+	`, projectID, pq.Array(append([]int{targetID}, sources...)))
+	if err != nil {
+		return err
+	}
+	environments := map[int]int{}
+	for rows.Next() {
+		var id, envID int
+		if err := rows.Scan(&id, &envID); err != nil {
+			rows.Close()
+			return err
+		}
+		environments[id] = envID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(environments) != len(sources)+1 {
+		return ErrGroupNotFound
+	}
+	for _, id := range sources {
+		if environments[id] != environments[targetID] {
+			return ErrMergeEnvironments
+		}
+	}
+
+	// Earlier merges into the sources follow them to the target
+	if _, err := tx.Exec(`
+		UPDATE error_group_fingerprints SET error_group_id = $1 WHERE error_group_id = ANY($2)
+	`, targetID, pq.Array(sources)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO error_group_fingerprints (project_id, environment_id, fingerprint, error_group_id, merged_by)
+		SELECT project_id, environment_id, fingerprint, $1, $2 FROM error_groups WHERE id = ANY($3)
+	`, targetID, userID, pq.Array(sources)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE error_logs SET error_group_id = $1 WHERE error_group_id = ANY($2)
+	`, targetID, pq.Array(sources)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE error_groups t
+		SET occurrence_count = t.occurrence_count + s.occurrences,
+		    first_seen = LEAST(t.first_seen, s.first_seen),
+		    last_seen = GREATEST(t.last_seen, s.last_seen)
+		FROM (
+			SELECT SUM(occurrence_count) AS occurrences, MIN(first_seen) AS first_seen, MAX(last_seen) AS last_seen
+			FROM error_groups WHERE id = ANY($2)
+		) s
+		WHERE t.id = $1
+	`, targetID, pq.Array(sources)); err != nil {
+		return err
+	}
+
+	// Keep the sources' history and discussion on the surviving group
+	for _, table := range []string{"error_group_activity", "error_group_comments"} {
+		if _, err := tx.Exec(`
+			UPDATE `+table+` SET error_group_id = $1 WHERE error_group_id = ANY($2)
+		`, targetID, pq.Array(sources)); err != nil {
+			return err
+		}
+	}
+	if err := RecordActivity(tx, targetID, &userID, ActivityMerged, map[string]interface{}{"group_ids": sources}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM error_groups WHERE id = ANY($1)", pq.Array(sources)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unmerge splits a merged fingerprint back out of a group. Its logs move to a new group, which is
+// returned; if none are left (e.g. purged by retention) only the routing is removed and nil is returned.
+func (s *ErrorGroupService) Unmerge(projectID, groupID, userID int, fingerprint string) (*int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var environmentID int
+	err = tx.QueryRow(`
+		DELETE FROM error_group_fingerprints
+		WHERE project_id = $1 AND error_group_id = $2 AND fingerprint = $3
+		RETURNING environment_id
+	`, projectID, groupID, fingerprint).Scan(&environmentID)
+	if err == sql.ErrNoRows {
+		return nil, ErrFingerprintNotMerged
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock the surviving group so concurrent ingestion doesn't race the recount
+	if _, err := tx.Exec("SELECT id FROM error_groups WHERE id = $1 FOR UPDATE", groupID); err != nil {
+		return nil, err
+	}
+
+	var newGroupID int
+	err = tx.QueryRow(`
+		INSERT INTO error_groups (
+			project_id, environment_id, fingerprint, message, stack, url,
+			source, level, first_seen, last_seen, occurrence_count, status
+		)
+		SELECT $1, $2, $3, latest.message, latest.stack, latest.url, latest.source, latest.level,
+		       agg.first_seen, agg.last_seen, agg.occurrences, 'unresolved'
+		FROM (
+			SELECT MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen, COUNT(*) AS occurrences
+			FROM error_logs WHERE error_group_id = $4 AND fingerprint = $3
+		) agg,
+		LATERAL (
+			SELECT message, stack, url, source, level FROM error_logs
+			WHERE error_group_id = $4 AND fingerprint = $3
+			ORDER BY timestamp DESC LIMIT 1
+		) latest
+		RETURNING id
+	`, projectID, environmentID, fingerprint, groupID).Scan(&newGroupID)
+	if err == sql.ErrNoRows {
+		err = RecordActivity(tx, groupID, &userID, ActivityUnmerged, map[string]interface{}{"fingerprint": fingerprint})
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE error_logs SET error_group_id = $1 WHERE error_group_id = $2 AND fingerprint = $3
+	`, newGroupID, groupID, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	moved, _ := res.RowsAffected()
+
+	if _, err := tx.Exec(`
+		UPDATE error_groups eg
+		SET occurrence_count = GREATEST(eg.occurrence_count - $2, 0),
+		    first_seen = COALESCE(remaining.first_seen, eg.first_seen),
+		    last_seen = COALESCE(remaining.last_seen, eg.last_seen)
+		FROM (
+			SELECT MIN(timestamp) AS first_seen, MAX(timestamp) AS last_seen
+			FROM error_logs WHERE error_group_id = $1
+		) remaining
+		WHERE eg.id = $1
+	`, groupID, moved); err != nil {
+		return nil, err
+	}
+
+	for _, id := range []int{groupID, newGroupID} {
+		err := RecordActivity(tx, id, &userID, ActivityUnmerged, map[string]interface{}{
+			"fingerprint":  fingerprint,
+			"new_group_id": newGroupID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &newGroupID, nil
+}
+
+// Fingerprints lists the group's own fingerprint followed by the ones merged into it
+func (s *ErrorGroupService) Fingerprints(projectID, groupID int) ([]GroupFingerprint, error) {
+	rows, err := s.db.Query(`
+		SELECT eg.fingerprint, TRUE, NULL::int, NULL::timestamptz,
+		       (SELECT COUNT(*) FROM error_logs el WHERE el.error_group_id = eg.id AND el.fingerprint = eg.fingerprint)
+		FROM error_groups eg WHERE eg.id = $1 AND eg.project_id = $2
+		UNION ALL
+		SELECT f.fingerprint, FALSE, f.merged_by, f.merged_at,
+		       (SELECT COUNT(*) FROM error_logs el WHERE el.error_group_id = f.error_group_id AND el.fingerprint = f.fingerprint)
+		FROM error_group_fingerprints f WHERE f.error_group_id = $1 AND f.project_id = $2
+	`, groupID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := []GroupFingerprint{}
+	for rows.Next() {
+		var f GroupFingerprint
+		if err := rows.Scan(&f.Fingerprint, &f.Primary, &f.MergedBy, &f.MergedAt, &f.Occurrences); err != nil {
+			return nil, err
+		}
+		fingerprints = append(fingerprints, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(fingerprints) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	return fingerprints, nil
+}
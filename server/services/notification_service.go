@@ -1,26 +1,36 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/utils"
 )
 
 type NotificationService struct {
 	db              *sql.DB
 	telegramService *TelegramService
+	secrets         *utils.SecretBox
+	links           *TelegramLinkService
 	baseURL         string
+	helperBotToken  string
 }
 
-func NewNotificationService(db *sql.DB, baseURL string) *NotificationService {
+func NewNotificationService(db *sql.DB, baseURL, helperBotToken string, secrets *utils.SecretBox) *NotificationService {
 	return &NotificationService{
 		db:              db,
 		telegramService: NewTelegramService(),
+		secrets:         secrets,
+		links:           NewTelegramLinkService(db),
 		baseURL:         baseURL,
+		helperBotToken:  helperBotToken,
 	}
 }
 
@@ -43,6 +53,12 @@ func (s *NotificationService) ShouldNotify(errorGroup *models.ErrorGroup, settin
 		return false
 	}
 
+	// Snoozed errors stay quiet until the snooze ends
+	if errorGroup.Status == "snoozed" {
+		log.Printf("[ShouldNotify] Skipping snoozed error: error_group_id=%d", errorGroup.ID)
+		return false
+	}
+
 	// New error (first occurrence) or reopened error
 	if triggers.NewError && errorGroup.OccurrenceCount == 1 {
 		log.Printf("[ShouldNotify] New error detected: error_group_id=%d", errorGroup.ID)
@@ -61,32 +77,39 @@ func (s *NotificationService) ShouldNotify(errorGroup *models.ErrorGroup, settin
 	return false
 }
 
+// ShouldNotifySnoozeEnd reports whether a group waking from a snooze should alert right away
+func (s *NotificationService) ShouldNotifySnoozeEnd(settings *models.NotificationSettings) bool {
+	return settings != nil && settings.Telegram.Enabled && settings.Telegram.Triggers.SnoozeEnded
+}
+
 // SendNotification sends notification based on error group status
 func (s *NotificationService) SendNotification(
 	errorGroup *models.ErrorGroup,
 	environment *models.Environment,
 	settings *models.NotificationSettings,
 ) error {
-	// Check debounce (don't spam)
-	if !s.canSendNotification(errorGroup) {
-		log.Printf("[Notification] Debounced: error_group_id=%d", errorGroup.ID)
+	// Check debounce, back-off and quiet hours (don't spam)
+	if !s.canSendNotification(errorGroup, settings.Telegram.Policy, time.Now()) {
+		log.Printf("[Notification] Deferred by delivery policy: error_group_id=%d", errorGroup.ID)
+		s.markPending(errorGroup.ID)
 		return nil
 	}
 
-	// Prepare notification data
-	data := &ErrorNotificationData{
-		Message:         errorGroup.Message,
-		Environment:     environment.Name,
-		Level:           errorGroup.Level,
-		OccurrenceCount: errorGroup.OccurrenceCount,
-		FirstSeen:       errorGroup.FirstSeen,
-		StackPreview:    s.getStackPreview(errorGroup.Stack, 3),
-		ViewURL:         fmt.Sprintf("%s/projects/%d/error-groups/%d", s.baseURL, errorGroup.ProjectID, errorGroup.ID),
-	}
+	return s.deliver(errorGroup, environment, settings)
+}
+
+func (s *NotificationService) deliver(
+	errorGroup *models.ErrorGroup,
+	environment *models.Environment,
+	settings *models.NotificationSettings,
+) error {
+	data := s.buildNotificationData(errorGroup, environment)
 
 	// Send appropriate notification type
 	var err error
+	kind := TemplateError
 	if errorGroup.Status == "ignored" && s.hasSpike(errorGroup) {
+		kind = TemplateSpike
 		err = s.telegramService.SendSpikeAlert(
 			settings.Telegram.BotToken,
 			settings.Telegram.ChatID,
@@ -107,11 +130,343 @@ func (s *NotificationService) SendNotification(
 
 	// Update notification tracking
 	s.updateNotificationTracking(errorGroup.ID)
+	s.recordNotification(errorGroup.ID, kind, "telegram")
+	s.mirrorToSubscribedChats(environment.ID, data)
 
 	log.Printf("[Notification] Sent successfully: error_group_id=%d", errorGroup.ID)
 	return nil
 }
 
+// mirrorToSubscribedChats forwards an alert through the helper bot to chats subscribed via /subscribe
+func (s *NotificationService) mirrorToSubscribedChats(environmentID int, data *ErrorNotificationData) {
+	if s.helperBotToken == "" {
+		return
+	}
+
+	chats, err := s.links.SubscribedChats(environmentID)
+	if err != nil {
+		log.Printf("[Notification] Error loading subscribed chats: %v", err)
+		return
+	}
+
+	// Action buttons only work on the environment's own bot webhook
+	mirrored := *data
+	mirrored.Interactive = false
+	for _, chatID := range chats {
+		if err := s.telegramService.SendErrorNotification(s.helperBotToken, strconv.FormatInt(chatID, 10), &mirrored); err != nil {
+			log.Printf("[Notification] Failed to mirror to chat %d: %v", chatID, err)
+		}
+	}
+}
+
+// NotifyAssigneeOfRegression sends the assignee a direct message through the helper bot when
+// a resolved group starts occurring again. Assignees without a linked Telegram account are skipped.
+func (s *NotificationService) NotifyAssigneeOfRegression(errorGroup *models.ErrorGroup, environment *models.Environment) error {
+	if errorGroup.AssignedTo == nil || s.helperBotToken == "" {
+		return nil
+	}
+
+	telegramUserID, _, err := s.links.GetUserLink(*errorGroup.AssignedTo)
+	if err == ErrTelegramNotLinked {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data := s.buildNotificationData(errorGroup, environment)
+	data.Interactive = false
+	if err := s.telegramService.SendRegressionAlert(s.helperBotToken, strconv.FormatInt(telegramUserID, 10), data); err != nil {
+		return err
+	}
+
+	s.recordNotification(errorGroup.ID, TemplateRegression, "assignee")
+	log.Printf("[Notification] Regression sent to assignee: error_group_id=%d user_id=%d", errorGroup.ID, *errorGroup.AssignedTo)
+	return nil
+}
+
+func (s *NotificationService) buildNotificationData(errorGroup *models.ErrorGroup, environment *models.Environment) *ErrorNotificationData {
+	return &ErrorNotificationData{
+		GroupID:         errorGroup.ID,
+		Interactive:     environment.Settings.Notifications.Telegram.Interactive,
+		Templates:       environment.Settings.Notifications.Telegram.Templates,
+		Message:         errorGroup.Message,
+		Environment:     environment.Name,
+		Level:           errorGroup.Level,
+		OccurrenceCount: errorGroup.OccurrenceCount,
+		FirstSeen:       errorGroup.FirstSeen,
+		StackPreview:    s.getStackPreview(errorGroup.Stack, 3),
+		ViewURL:         fmt.Sprintf("%s/projects/%d/error-groups/%d", s.baseURL, errorGroup.ProjectID, errorGroup.ID),
+	}
+}
+
+// ProcessPendingNotifications delivers notifications that were deferred by
+// back-off or quiet hours once their channel is allowed to send again. Groups
+// resolved or snoozed in the meantime drop their alert; ignored groups only keep
+// it while they are still spiking.
+func (s *NotificationService) ProcessPendingNotifications(ctx context.Context) error {
+	groups, err := s.queryGroups(ctx, `
+		AND eg.notify_pending_at IS NOT NULL AND eg.status IN ('unresolved', 'ignored')
+		AND (eg.snoozed_until IS NULL OR eg.snoozed_until <= NOW())
+		ORDER BY eg.notify_pending_at ASC
+		LIMIT 500
+	`)
+	if err != nil {
+		return fmt.Errorf("loading pending notifications: %w", err)
+	}
+
+	envs := map[int]*models.Environment{}
+	now := time.Now()
+	for i := range groups {
+		eg := &groups[i]
+		env, err := s.cachedEnvironment(envs, eg.EnvironmentID)
+		if err != nil {
+			log.Printf("[Notification] Error fetching environment %d: %v", eg.EnvironmentID, err)
+			continue
+		}
+
+		settings := &env.Settings.Notifications
+		if !settings.Telegram.Enabled {
+			s.clearPending(eg.ID)
+			continue
+		}
+		if eg.Status == "ignored" && !(settings.Telegram.Triggers.SpikeOnIgnored && s.hasSpike(eg)) {
+			s.clearPending(eg.ID)
+			continue
+		}
+		if !s.canSendNotification(eg, settings.Telegram.Policy, now) {
+			continue
+		}
+
+		if err := s.deliver(eg, env, settings); err != nil {
+			log.Printf("[Notification] Pending delivery failed: error_group_id=%d: %v", eg.ID, err)
+		}
+	}
+	return nil
+}
+
+// ProcessEscalations notifies the escalation channel for groups that are still
+// unresolved and still receiving events N minutes after the first alert.
+func (s *NotificationService) ProcessEscalations(ctx context.Context) error {
+	groups, err := s.queryGroups(ctx, `
+		AND eg.status = 'unresolved' AND eg.escalated_at IS NULL AND eg.streak_started_at IS NOT NULL
+		ORDER BY eg.streak_started_at ASC
+		LIMIT 500
+	`)
+	if err != nil {
+		return fmt.Errorf("loading escalation candidates: %w", err)
+	}
+
+	envs := map[int]*models.Environment{}
+	now := time.Now()
+	for i := range groups {
+		eg := &groups[i]
+		env, err := s.cachedEnvironment(envs, eg.EnvironmentID)
+		if err != nil {
+			log.Printf("[Notification] Error fetching environment %d: %v", eg.EnvironmentID, err)
+			continue
+		}
+
+		escalation := env.Settings.Notifications.Telegram.Escalation
+		if !s.shouldEscalate(eg, escalation, now) {
+			continue
+		}
+
+		botToken := escalation.BotToken
+		if botToken == "" {
+			botToken = env.Settings.Notifications.Telegram.BotToken
+		}
+
+		data := s.buildNotificationData(eg, env)
+		if err := s.telegramService.SendEscalationAlert(botToken, escalation.ChatID, data, escalation.GetAfterMinutes()); err != nil {
+			log.Printf("[Notification] Escalation failed: error_group_id=%d: %v", eg.ID, err)
+			continue
+		}
+
+		if _, err := s.db.Exec("UPDATE error_groups SET escalated_at = NOW() WHERE id = $1", eg.ID); err != nil {
+			log.Printf("[Notification] Error recording escalation: %v", err)
+		}
+		s.recordNotification(eg.ID, TemplateEscalation, "telegram")
+		log.Printf("[Notification] Escalated: error_group_id=%d", eg.ID)
+	}
+	return nil
+}
+
+// EvaluateThresholds re-checks threshold triggers for recently active groups so
+// that a threshold reached by the last event of a burst still alerts even if
+// no further events arrive.
+func (s *NotificationService) EvaluateThresholds(ctx context.Context) error {
+	groups, err := s.queryGroups(ctx, `
+		AND eg.status = 'unresolved' AND eg.last_seen >= NOW() - INTERVAL '1 day'
+		  AND (eg.last_notified_at IS NULL OR eg.last_notified_at < eg.last_seen)
+		  AND eg.notify_pending_at IS NULL
+		ORDER BY eg.last_seen DESC
+		LIMIT 500
+	`)
+	if err != nil {
+		return fmt.Errorf("loading threshold candidates: %w", err)
+	}
+
+	envs := map[int]*models.Environment{}
+	for i := range groups {
+		eg := &groups[i]
+		env, err := s.cachedEnvironment(envs, eg.EnvironmentID)
+		if err != nil {
+			log.Printf("[Notification] Error fetching environment %d: %v", eg.EnvironmentID, err)
+			continue
+		}
+
+		settings := &env.Settings.Notifications
+		threshold := settings.Telegram.Triggers.Threshold
+		if !settings.Telegram.Enabled || !threshold.Enabled {
+			continue
+		}
+
+		// Only groups whose last alert predates the current window
+		windowStart := time.Now().Add(-time.Duration(threshold.GetWindowMinutes()) * time.Minute)
+		if eg.LastNotifiedAt != nil && eg.LastNotifiedAt.After(windowStart) {
+			continue
+		}
+		if !s.hasReachedThreshold(eg, threshold) {
+			continue
+		}
+
+		if err := s.SendNotification(eg, env, settings); err != nil {
+			log.Printf("[Notification] Threshold delivery failed: error_group_id=%d: %v", eg.ID, err)
+		}
+	}
+	return nil
+}
+
+// SendDigests sends a periodic summary to every environment with digests enabled
+func (s *NotificationService) SendDigests(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.id, e.project_id, e.name, e.settings, e.last_digest_at FROM environments e
+		JOIN projects p ON p.id = e.project_id
+		WHERE e.is_active = TRUE AND p.deleted_at IS NULL
+		  AND (e.settings->'notifications'->'telegram'->'digest'->>'enabled')::boolean IS TRUE
+	`)
+	if err != nil {
+		return fmt.Errorf("loading digest environments: %w", err)
+	}
+
+	type digestEnv struct {
+		env          models.Environment
+		lastDigestAt *time.Time
+	}
+	targets := []digestEnv{}
+	for rows.Next() {
+		var d digestEnv
+		var settingsJSON []byte
+		if err := rows.Scan(&d.env.ID, &d.env.ProjectID, &d.env.Name, &settingsJSON, &d.lastDigestAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal(settingsJSON, &d.env.Settings); err != nil {
+			log.Printf("[Digest] Error unmarshaling settings for environment %d: %v", d.env.ID, err)
+			continue
+		}
+		if err := DecryptNotificationSecrets(s.secrets, &d.env.Settings.Notifications); err != nil {
+			log.Printf("[Digest] Error decrypting secrets for environment %d: %v", d.env.ID, err)
+			continue
+		}
+		targets = append(targets, d)
+	}
+	rows.Close()
+
+	now := time.Now()
+	for _, d := range targets {
+		telegram := d.env.Settings.Notifications.Telegram
+		if !telegram.Enabled {
+			continue
+		}
+
+		interval := time.Duration(telegram.Digest.GetIntervalHours()) * time.Hour
+		since := now.Add(-interval)
+		if d.lastDigestAt != nil {
+			if now.Sub(*d.lastDigestAt) < interval {
+				continue
+			}
+			since = *d.lastDigestAt
+		}
+
+		digest, err := s.buildDigest(ctx, &d.env, since)
+		if err != nil {
+			log.Printf("[Digest] Error building digest for environment %d: %v", d.env.ID, err)
+			continue
+		}
+
+		if err := s.telegramService.SendDigest(telegram.BotToken, telegram.ChatID, digest); err != nil {
+			log.Printf("[Digest] Failed for environment %d: %v", d.env.ID, err)
+			continue
+		}
+
+		if _, err := s.db.ExecContext(ctx, "UPDATE environments SET last_digest_at = $1 WHERE id = $2", now, d.env.ID); err != nil {
+			log.Printf("[Digest] Error recording digest: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *NotificationService) buildDigest(ctx context.Context, env *models.Environment, since time.Time) (*DigestData, error) {
+	digest := &DigestData{
+		Environment: env.Name,
+		Since:       since,
+		Templates:   env.Settings.Notifications.Telegram.Templates,
+		ViewURL:     fmt.Sprintf("%s/projects/%d/error-groups?environment_id=%d", s.baseURL, env.ProjectID, env.ID),
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM error_logs WHERE environment_id = $1 AND created_at >= $2
+	`, env.ID, since).Scan(&digest.TotalEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM error_groups WHERE environment_id = $1 AND first_seen >= $2
+	`, env.ID, since).Scan(&digest.NewGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT eg.message, COUNT(el.id) AS events
+		FROM error_logs el
+		JOIN error_groups eg ON eg.id = el.error_group_id
+		WHERE el.environment_id = $1 AND el.created_at >= $2 AND eg.status = 'unresolved'
+		GROUP BY eg.id, eg.message
+		ORDER BY events DESC
+		LIMIT 5
+	`, env.ID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var top DigestGroup
+		if err := rows.Scan(&top.Message, &top.Events); err != nil {
+			return nil, err
+		}
+		digest.TopGroups = append(digest.TopGroups, top)
+	}
+
+	return digest, rows.Err()
+}
+
+func (s *NotificationService) shouldEscalate(errorGroup *models.ErrorGroup, escalation models.EscalationPolicy, now time.Time) bool {
+	if !escalation.Enabled || escalation.ChatID == "" || errorGroup.StreakStartedAt == nil {
+		return false
+	}
+
+	after := time.Duration(escalation.GetAfterMinutes()) * time.Minute
+	deadline := errorGroup.StreakStartedAt.Add(after)
+
+	// Still firing: events kept arriving after the escalation deadline
+	return !now.Before(deadline) && !errorGroup.LastSeen.Before(deadline)
+}
+
 func (s *NotificationService) hasReachedThreshold(errorGroup *models.ErrorGroup, threshold models.ThresholdTrigger) bool {
 	windowStart := time.Now().Add(-time.Duration(threshold.GetWindowMinutes()) * time.Minute)
 
@@ -152,10 +507,13 @@ func (s *NotificationService) hasSpike(errorGroup *models.ErrorGroup) bool {
 	return currentPerMinute > (avgPerMinute * 100)
 }
 
-func (s *NotificationService) canSendNotification(errorGroup *models.ErrorGroup) bool {
+func (s *NotificationService) canSendNotification(errorGroup *models.ErrorGroup, policy models.DeliveryPolicy, now time.Time) bool {
+	if policy.QuietHours.Active(now) {
+		return false
+	}
 	if errorGroup.LastNotifiedAt != nil {
-		elapsed := time.Since(*errorGroup.LastNotifiedAt)
-		if elapsed < 1*time.Minute {
+		elapsed := now.Sub(*errorGroup.LastNotifiedAt)
+		if elapsed < policy.WindowFor(errorGroup.NotificationStep) {
 			return false
 		}
 	}
@@ -165,7 +523,11 @@ func (s *NotificationService) canSendNotification(errorGroup *models.ErrorGroup)
 func (s *NotificationService) updateNotificationTracking(errorGroupID int) {
 	_, err := s.db.Exec(`
 		UPDATE error_groups
-		SET last_notified_at = NOW(), notification_count = notification_count + 1
+		SET last_notified_at = NOW(),
+		    notification_count = notification_count + 1,
+		    notification_step = notification_step + 1,
+		    streak_started_at = COALESCE(streak_started_at, NOW()),
+		    notify_pending_at = NULL
 		WHERE id = $1
 	`, errorGroupID)
 
@@ -174,6 +536,91 @@ func (s *NotificationService) updateNotificationTracking(errorGroupID int) {
 	}
 }
 
+func (s *NotificationService) recordNotification(errorGroupID int, kind, channel string) {
+	err := RecordActivity(s.db, errorGroupID, nil, ActivityNotificationSent, map[string]interface{}{
+		"kind":    kind,
+		"channel": channel,
+	})
+	if err != nil {
+		log.Printf("[Notification] Error recording activity: %v", err)
+	}
+}
+
+func (s *NotificationService) markPending(errorGroupID int) {
+	_, err := s.db.Exec(`
+		UPDATE error_groups SET notify_pending_at = COALESCE(notify_pending_at, NOW()) WHERE id = $1
+	`, errorGroupID)
+
+	if err != nil {
+		log.Printf("[Notification] Error marking pending: %v", err)
+	}
+}
+
+func (s *NotificationService) clearPending(errorGroupID int) {
+	_, err := s.db.Exec("UPDATE error_groups SET notify_pending_at = NULL WHERE id = $1", errorGroupID)
+	if err != nil {
+		log.Printf("[Notification] Error clearing pending: %v", err)
+	}
+}
+
+// queryGroups loads the groups matching conditions (starting with AND, on alias eg) that can still
+// alert: their environment is active and their project isn't pending deletion
+func (s *NotificationService) queryGroups(ctx context.Context, conditions string) ([]models.ErrorGroup, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT eg.id, eg.project_id, eg.environment_id, eg.message, eg.stack, eg.level,
+		       eg.first_seen, eg.last_seen, eg.occurrence_count, eg.status, eg.last_notified_at,
+		       eg.notification_step, eg.streak_started_at, eg.notify_pending_at, eg.escalated_at
+		FROM error_groups eg
+		JOIN environments e ON e.id = eg.environment_id
+		JOIN projects p ON p.id = eg.project_id
+		WHERE e.is_active = TRUE AND p.deleted_at IS NULL
+	`+conditions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []models.ErrorGroup{}
+	for rows.Next() {
+		var eg models.ErrorGroup
+		if err := rows.Scan(
+			&eg.ID, &eg.ProjectID, &eg.EnvironmentID, &eg.Message, &eg.Stack, &eg.Level,
+			&eg.FirstSeen, &eg.LastSeen, &eg.OccurrenceCount, &eg.Status, &eg.LastNotifiedAt,
+			&eg.NotificationStep, &eg.StreakStartedAt, &eg.NotifyPendingAt, &eg.EscalatedAt,
+		); err != nil {
+			return nil, err
+		}
+		groups = append(groups, eg)
+	}
+	return groups, rows.Err()
+}
+
+func (s *NotificationService) cachedEnvironment(cache map[int]*models.Environment, environmentID int) (*models.Environment, error) {
+	if env, ok := cache[environmentID]; ok {
+		return env, nil
+	}
+
+	var env models.Environment
+	var settingsJSON []byte
+	err := s.db.QueryRow(`
+		SELECT id, project_id, name, settings FROM environments WHERE id = $1
+	`, environmentID).Scan(&env.ID, &env.ProjectID, &env.Name, &settingsJSON)
+	if err != nil {
+		return nil, err
+	}
+	if len(settingsJSON) > 0 {
+		if err := json.Unmarshal(settingsJSON, &env.Settings); err != nil {
+			return nil, err
+		}
+		if err := DecryptNotificationSecrets(s.secrets, &env.Settings.Notifications); err != nil {
+			return nil, err
+		}
+	}
+
+	cache[environmentID] = &env
+	return &env, nil
+}
+
 func (s *NotificationService) getStackPreview(stack *string, lines int) string {
 	if stack == nil || *stack == "" {
 		return ""
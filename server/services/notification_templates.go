@@ -0,0 +1,444 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/prabalesh/vigileye/models"
+)
+
+const (
+	TemplateError      = "error"
+	TemplateSpike      = "spike"
+	TemplateEscalation = "escalation"
+	TemplateRegression = "regression"
+	TemplateDigest     = "digest"
+	TemplateTest       = "test"
+
+	ParseModeMarkdownV2 = "MarkdownV2"
+	ParseModeHTML       = "HTML"
+
+	// TelegramMessageLimit is the maximum length of a message text in UTF-16 code units
+	TelegramMessageLimit = 4096
+	// maxMessageParts caps how many messages one alert may be split into before it is truncated
+	maxMessageParts = 3
+)
+
+var (
+	ErrUnknownTemplate  = errors.New("unknown template")
+	ErrUnknownParseMode = errors.New("parse_mode must be MarkdownV2 or HTML")
+)
+
+// Built-in templates are written with the format helpers so the same text works in both parse modes.
+// Literal text containing MarkdownV2 specials (. ! ( ) - etc.) must go through esc.
+var defaultTelegramTemplates = map[string]string{
+	TemplateError: `{{if eq .Level "warn"}}🟡{{else}}🔴{{end}} {{bold (printf "%s ERROR" (status .OccurrenceCount))}} in {{esc .Environment}}
+
+{{bold "Message:"}} {{.Message | truncate 1000 | esc}}
+{{bold "Environment:"}} {{esc .Environment}}
+{{bold "Level:"}} {{esc .Level}}
+{{bold "Occurrences:"}} {{.OccurrenceCount}}
+{{bold "First Seen:"}} {{date .FirstSeen | esc}}
+{{bold "Status:"}} Unresolved
+{{if .StackPreview}}
+{{bold "Stack Trace:"}}
+{{pre .StackPreview}}
+{{end}}
+{{link "View Full Details" .ViewURL}}`,
+
+	TemplateSpike: `⚠️ {{bold "IGNORED ERROR SPIKE"}} in {{esc .Environment}}
+
+{{esc "An ignored error is suddenly spiking!"}}
+
+{{bold "Error:"}} {{.Message | truncate 1000 | esc}}
+{{bold "Environment:"}} {{esc .Environment}}
+{{bold "Occurrences:"}} {{.OccurrenceCount}} {{esc "(100x higher than normal)"}}
+{{bold "Status:"}} Previously ignored
+
+{{esc "This might indicate a new issue. Consider investigating."}}
+
+{{link "View Details" .ViewURL}}`,
+
+	TemplateEscalation: `🚨 {{bold "ESCALATION"}} in {{esc .Environment}}
+
+{{esc (printf "An error is still unresolved and firing %d minutes after the first alert." .AfterMinutes)}}
+
+{{bold "Error:"}} {{.Message | truncate 1000 | esc}}
+{{bold "Environment:"}} {{esc .Environment}}
+{{bold "Occurrences:"}} {{.OccurrenceCount}}
+{{bold "First Seen:"}} {{date .FirstSeen | esc}}
+
+{{link "View Details" .ViewURL}}`,
+
+	TemplateRegression: `🔁 {{bold "REGRESSION"}} in {{esc .Environment}}
+
+{{esc "An error assigned to you was resolved but is occurring again."}}
+
+{{bold "Error:"}} {{.Message | truncate 1000 | esc}}
+{{bold "Environment:"}} {{esc .Environment}}
+{{bold "Occurrences:"}} {{.OccurrenceCount}}
+{{if .StackPreview}}
+{{pre .StackPreview}}
+{{end}}
+{{link "View Details" .ViewURL}}`,
+
+	TemplateDigest: `📊 {{bold "Error Digest"}} for {{esc .Environment}}
+
+{{bold "Since:"}} {{date .Since | esc}}
+{{bold "Events:"}} {{.TotalEvents}}
+{{bold "New Errors:"}} {{.NewGroups}}
+{{if .TopGroups}}
+{{bold "Top Unresolved:"}}
+{{range $i, $g := .TopGroups}}{{esc (printf "%d. " (add $i 1))}}{{$g.Message | truncate 200 | esc}} {{esc (printf "(%d)" $g.Events)}}
+{{end}}{{end}}
+{{link "Open Dashboard" .ViewURL}}`,
+
+	TemplateTest: `🔔 {{bold "Test Notification from Vigil Eye"}}
+
+{{esc "Your Telegram notifications are configured correctly!"}}
+
+You will receive alerts when:
+• New unique errors occur
+• Error thresholds are reached
+• Ignored errors spike unexpectedly
+
+{{esc "✅ Setup complete!"}}`,
+}
+
+// RenderTelegram renders a notification and splits it into messages that fit Telegram's length limit.
+// A broken override falls back to the built-in template so alerts are never lost to a typo.
+func RenderTelegram(templates models.TelegramTemplates, kind string, data interface{}) ([]string, string, error) {
+	parseMode := telegramParseMode(templates)
+	if parseMode == "" {
+		log.Printf("[RenderTelegram] Unknown parse mode %q, using %s", templates.ParseMode, ParseModeMarkdownV2)
+		parseMode = ParseModeMarkdownV2
+	}
+
+	defaultText, ok := defaultTelegramTemplates[kind]
+	if !ok {
+		return nil, "", ErrUnknownTemplate
+	}
+
+	var text string
+	if override := templateOverride(templates, kind); override != "" {
+		var err error
+		if text, err = renderTemplate(parseMode, override, data); err != nil {
+			log.Printf("[RenderTelegram] Custom %s template failed, using default: %v", kind, err)
+			text = ""
+		}
+	}
+	if text == "" {
+		var err error
+		if text, err = renderTemplate(parseMode, defaultText, data); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return SplitTelegramMessage(text, parseMode, TelegramMessageLimit), parseMode, nil
+}
+
+// ValidateTelegramTemplates parses every override and renders it against sample data
+func ValidateTelegramTemplates(templates models.TelegramTemplates) error {
+	parseMode := telegramParseMode(templates)
+	if parseMode == "" {
+		return ErrUnknownParseMode
+	}
+
+	for _, kind := range []string{TemplateError, TemplateSpike, TemplateEscalation, TemplateRegression, TemplateDigest} {
+		override := templateOverride(templates, kind)
+		if override == "" {
+			continue
+		}
+		if _, err := renderTemplate(parseMode, override, SampleTemplateData(kind)); err != nil {
+			return fmt.Errorf("templates.%s: %w", kind, err)
+		}
+	}
+	return nil
+}
+
+// SampleTemplateData is the data used for template previews and validation
+func SampleTemplateData(kind string) interface{} {
+	if kind == TemplateDigest {
+		return &DigestData{
+			Environment: "production",
+			Since:       time.Now().Add(-24 * time.Hour),
+			TotalEvents: 1284,
+			NewGroups:   3,
+			TopGroups: []DigestGroup{
+				{Message: "TypeError: Cannot read properties of undefined (reading 'user_id')", Events: 912},
+				{Message: "Timeout after 30s calling payments-api", Events: 240},
+			},
+			ViewURL: "https://vigileye.example.com/projects/1/error-groups?environment_id=1",
+		}
+	}
+
+	return &ErrorNotificationData{
+		GroupID:         42,
+		Message:         "TypeError: Cannot read properties of undefined (reading 'user_id')",
+		Environment:     "production",
+		Level:           "error",
+		OccurrenceCount: 17,
+		FirstSeen:       time.Now().Add(-2 * time.Hour),
+		StackPreview:    "at getUser (src/api/users.js:42:17)\nat async handler (src/routes/[id].js:12:5)\nat process_request (server_main.js:88:3)",
+		ViewURL:         "https://vigileye.example.com/projects/1/error-groups/42",
+		AfterMinutes:    60,
+	}
+}
+
+func telegramParseMode(templates models.TelegramTemplates) string {
+	switch templates.ParseMode {
+	case "", ParseModeMarkdownV2:
+		return ParseModeMarkdownV2
+	case ParseModeHTML:
+		return ParseModeHTML
+	}
+	return ""
+}
+
+func templateOverride(templates models.TelegramTemplates, kind string) string {
+	switch kind {
+	case TemplateError:
+		return templates.Error
+	case TemplateSpike:
+		return templates.Spike
+	case TemplateEscalation:
+		return templates.Escalation
+	case TemplateRegression:
+		return templates.Regression
+	case TemplateDigest:
+		return templates.Digest
+	}
+	return ""
+}
+
+func renderTemplate(parseMode, text string, data interface{}) (string, error) {
+	tmpl, err := template.New("telegram").Option("missingkey=error").Funcs(templateFuncs(parseMode)).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func templateFuncs(parseMode string) template.FuncMap {
+	funcs := template.FuncMap{
+		"truncate": truncateRunes,
+		"lines":    firstLines,
+		"date":     func(t time.Time) string { return t.Format("Jan 2, 3:04 PM") },
+		"add":      func(a, b int) int { return a + b },
+		"status": func(occurrences int) string {
+			if occurrences > 1 {
+				return "RECURRING"
+			}
+			return "NEW"
+		},
+	}
+
+	if parseMode == ParseModeHTML {
+		funcs["esc"] = html.EscapeString
+		funcs["bold"] = func(s string) string { return "<b>" + html.EscapeString(s) + "</b>" }
+		funcs["italic"] = func(s string) string { return "<i>" + html.EscapeString(s) + "</i>" }
+		funcs["code"] = func(s string) string { return "<code>" + html.EscapeString(s) + "</code>" }
+		funcs["pre"] = func(s string) string { return "<pre>" + html.EscapeString(s) + "</pre>" }
+		funcs["link"] = func(text, url string) string {
+			return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + "</a>"
+		}
+		return funcs
+	}
+
+	funcs["esc"] = escapeMarkdownV2
+	funcs["bold"] = func(s string) string { return "*" + escapeMarkdownV2(s) + "*" }
+	funcs["italic"] = func(s string) string { return "_" + escapeMarkdownV2(s) + "_" }
+	funcs["code"] = func(s string) string { return "`" + markdownV2CodeEscaper.Replace(s) + "`" }
+	funcs["pre"] = func(s string) string { return "```\n" + markdownV2CodeEscaper.Replace(s) + "\n```" }
+	funcs["link"] = func(text, url string) string {
+		return "[" + escapeMarkdownV2(text) + "](" + markdownV2URLEscaper.Replace(url) + ")"
+	}
+	return funcs
+}
+
+var (
+	markdownV2Escaper = strings.NewReplacer(
+		"\\", "\\\\",
+		"_", "\\_",
+		"*", "\\*",
+		"[", "\\[",
+		"]", "\\]",
+		"(", "\\(",
+		")", "\\)",
+		"~", "\\~",
+		"`", "\\`",
+		">", "\\>",
+		"#", "\\#",
+		"+", "\\+",
+		"-", "\\-",
+		"=", "\\=",
+		"|", "\\|",
+		"{", "\\{",
+		"}", "\\}",
+		".", "\\.",
+		"!", "\\!",
+	)
+	// Inside pre and code entities only ` and \ are special
+	markdownV2CodeEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`")
+	// Inside the (...) part of an inline link only ) and \ are special
+	markdownV2URLEscaper = strings.NewReplacer("\\", "\\\\", ")", "\\)")
+)
+
+// escapeMarkdownV2 escapes text for use outside entities in MarkdownV2
+func escapeMarkdownV2(text string) string {
+	return markdownV2Escaper.Replace(text)
+}
+
+func truncateRunes(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func firstLines(n int, s string) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SplitTelegramMessage splits rendered text on line boundaries so each part stays under limit.
+// Code blocks cut by a split are closed and reopened so every part parses on its own, and
+// anything beyond maxMessageParts is dropped with a truncation notice.
+func SplitTelegramMessage(text, parseMode string, limit int) []string {
+	if utf16Len(text) <= limit {
+		return []string{text}
+	}
+
+	open, closer, notice := "```\n", "\n```", "\n"+escapeMarkdownV2("… (truncated, view full details in the dashboard)")
+	if parseMode == ParseModeHTML {
+		open, closer, notice = "<pre>", "</pre>", "\n"+html.EscapeString("… (truncated, view full details in the dashboard)")
+	}
+	// Leave room for closing a code block and the truncation notice
+	budget := limit - utf16Len(closer) - utf16Len(notice)
+
+	var parts []string
+	var current strings.Builder
+	inPre := false
+
+	flush := func() {
+		part := current.String()
+		if inPre {
+			part += closer
+		}
+		parts = append(parts, part)
+		current.Reset()
+		if inPre {
+			current.WriteString(open)
+		}
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			line = "\n" + line
+		}
+
+		for utf16Len(current.String())+utf16Len(line) > budget {
+			room := budget - utf16Len(current.String())
+			if room < budget/4 && current.Len() > 0 {
+				// Prefer a clean break at the line boundary over a hard cut
+				flush()
+				line = strings.TrimPrefix(line, "\n")
+				continue
+			}
+			head, tail := cutAtUTF16(line, room, parseMode)
+			current.WriteString(head)
+			flush()
+			line = tail
+		}
+		current.WriteString(line)
+		inPre = togglesPre(line, parseMode, inPre)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+
+	if len(parts) > maxMessageParts {
+		parts = parts[:maxMessageParts]
+		parts[maxMessageParts-1] += notice
+	}
+	return parts
+}
+
+func togglesPre(line, parseMode string, inPre bool) bool {
+	if parseMode == ParseModeHTML {
+		opens, closes := strings.Count(line, "<pre>"), strings.Count(line, "</pre>")
+		if opens > closes {
+			return true
+		}
+		if closes > opens {
+			return false
+		}
+		return inPre
+	}
+	if strings.Count(line, "```")%2 == 1 {
+		return !inPre
+	}
+	return inPre
+}
+
+// cutAtUTF16 splits s so the head fits in n UTF-16 units without breaking an escape sequence
+func cutAtUTF16(s string, n int, parseMode string) (string, string) {
+	units, cut := 0, 0
+	for i, r := range s {
+		size := utf16.RuneLen(r)
+		if size < 0 {
+			size = 1
+		}
+		if units+size > n {
+			break
+		}
+		units += size
+		cut = i + utf8.RuneLen(r)
+	}
+
+	head := s[:cut]
+	if parseMode == ParseModeHTML {
+		// Don't cut inside an entity such as &amp;
+		if amp := strings.LastIndex(head, "&"); amp >= 0 && !strings.Contains(head[amp:], ";") {
+			cut = amp
+		}
+	} else {
+		// Don't leave a dangling escape backslash at the end of the head
+		backslashes := len(head) - len(strings.TrimRight(head, "\\"))
+		if backslashes%2 == 1 {
+			cut--
+		}
+	}
+	if cut <= 0 {
+		_, cut = utf8.DecodeRuneInString(s)
+	}
+	return s[:cut], s[cut:]
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
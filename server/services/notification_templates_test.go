@@ -0,0 +1,164 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prabalesh/vigileye/models"
+)
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	got := escapeMarkdownV2(`user_id is [nil] (see a\b.c)!`)
+	want := `user\_id is \[nil\] \(see a\\b\.c\)\!`
+	if got != want {
+		t.Errorf("escapeMarkdownV2() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderTelegramMarkdownV2(t *testing.T) {
+	data := &ErrorNotificationData{
+		Message:         "KeyError: 'user_id' in get_user()",
+		Environment:     "prod-eu",
+		Level:           "error",
+		OccurrenceCount: 1,
+		FirstSeen:       time.Date(2024, 3, 1, 15, 4, 0, 0, time.UTC),
+		StackPreview:    "File \"app/views.py\", line 12, in get_user\n  return users[`id`]",
+		ViewURL:         "https://example.com/projects/1/error-groups/(2)",
+	}
+
+	parts, parseMode, err := RenderTelegram(models.TelegramTemplates{}, TemplateError, data)
+	if err != nil {
+		t.Fatalf("RenderTelegram() error = %v", err)
+	}
+	if parseMode != ParseModeMarkdownV2 || len(parts) != 1 {
+		t.Fatalf("Expected one MarkdownV2 message, got %d %s", len(parts), parseMode)
+	}
+
+	text := parts[0]
+	for _, want := range []string{
+		"🔴 *NEW ERROR* in prod\\-eu",
+		"*Message:* KeyError: 'user\\_id' in get\\_user\\(\\)",
+		// Only ` and \ are escaped inside code blocks
+		"```\nFile \"app/views.py\", line 12, in get_user\n  return users[\\`id\\`]\n```",
+		"[View Full Details](https://example.com/projects/1/error-groups/(2\\))",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
+
+func TestRenderTelegramHTML(t *testing.T) {
+	data := SampleTemplateData(TemplateError).(*ErrorNotificationData)
+	data.Message = "<script> & friends"
+
+	parts, parseMode, err := RenderTelegram(models.TelegramTemplates{ParseMode: ParseModeHTML}, TemplateError, data)
+	if err != nil {
+		t.Fatalf("RenderTelegram() error = %v", err)
+	}
+	if parseMode != ParseModeHTML {
+		t.Fatalf("Expected HTML parse mode, got %s", parseMode)
+	}
+	if !strings.Contains(parts[0], "<b>Message:</b> &lt;script&gt; &amp; friends") || !strings.Contains(parts[0], "<pre>") {
+		t.Errorf("Unexpected HTML message:\n%s", parts[0])
+	}
+}
+
+func TestRenderTelegramOverride(t *testing.T) {
+	templates := models.TelegramTemplates{Error: `{{bold .Environment}}: {{esc .Message}}`}
+
+	parts, _, err := RenderTelegram(templates, TemplateError, SampleTemplateData(TemplateError))
+	if err != nil {
+		t.Fatalf("RenderTelegram() error = %v", err)
+	}
+	if !strings.HasPrefix(parts[0], "*production*: TypeError: Cannot read properties of undefined \\(reading 'user\\_id'\\)") {
+		t.Errorf("Unexpected override output: %q", parts[0])
+	}
+
+	// A broken override falls back to the default template
+	templates.Error = `{{.NoSuchField}}`
+	parts, _, err = RenderTelegram(templates, TemplateError, SampleTemplateData(TemplateError))
+	if err != nil || !strings.Contains(parts[0], "ERROR* in production") {
+		t.Errorf("Expected default template fallback, got %q, %v", parts, err)
+	}
+}
+
+func TestValidateTelegramTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates models.TelegramTemplates
+		wantErr   bool
+	}{
+		{"defaults", models.TelegramTemplates{}, false},
+		{"valid override", models.TelegramTemplates{ParseMode: ParseModeHTML, Digest: `{{.TotalEvents}} events`}, false},
+		{"syntax error", models.TelegramTemplates{Spike: `{{if .Message}`}, true},
+		{"unknown field", models.TelegramTemplates{Escalation: `{{.Bogus}}`}, true},
+		{"bad parse mode", models.TelegramTemplates{ParseMode: "Markdown"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateTelegramTemplates(tt.templates); (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidateTelegramTemplates() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSplitTelegramMessage(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("*Stack Trace:*\n```\n")
+	for i := 0; i < 300; i++ {
+		b.WriteString("at handler (src/routes/index.js:42:17)\n")
+	}
+	b.WriteString("```\n[View](https://example.com)")
+
+	parts := SplitTelegramMessage(b.String(), ParseModeMarkdownV2, TelegramMessageLimit)
+	if len(parts) < 2 {
+		t.Fatalf("Expected message to be split, got %d parts", len(parts))
+	}
+	for i, part := range parts {
+		if utf16Len(part) > TelegramMessageLimit {
+			t.Errorf("Part %d is %d units long", i, utf16Len(part))
+		}
+		// Each part must open and close its own code block
+		if strings.Count(part, "```")%2 != 0 {
+			t.Errorf("Part %d has an unbalanced code block", i)
+		}
+	}
+	if !strings.HasSuffix(parts[len(parts)-1], "[View](https://example.com)") {
+		t.Errorf("Expected the last part to keep the trailing link")
+	}
+}
+
+func TestSplitTelegramMessageTruncates(t *testing.T) {
+	// One long line of escaped text forces hard cuts, which must not split an escape sequence
+	text := strings.Repeat(escapeMarkdownV2("a.b!"), 10000)
+
+	parts := SplitTelegramMessage(text, ParseModeMarkdownV2, TelegramMessageLimit)
+	if len(parts) != maxMessageParts {
+		t.Fatalf("Expected %d parts, got %d", maxMessageParts, len(parts))
+	}
+	for i, part := range parts {
+		if utf16Len(part) > TelegramMessageLimit || !utf8.ValidString(part) {
+			t.Errorf("Part %d is invalid or too long (%d units)", i, utf16Len(part))
+		}
+		body := strings.TrimSuffix(part, "\n"+escapeMarkdownV2("… (truncated, view full details in the dashboard)"))
+		if trailing := len(body) - len(strings.TrimRight(body, "\\")); trailing%2 == 1 {
+			t.Errorf("Part %d ends with a dangling escape", i)
+		}
+	}
+	if !strings.Contains(parts[maxMessageParts-1], "truncated") {
+		t.Errorf("Expected truncation notice on the last part")
+	}
+}
+
+func TestSplitTelegramMessageHTMLEntities(t *testing.T) {
+	text := strings.Repeat("&amp;", 2000)
+
+	for i, part := range SplitTelegramMessage(text, ParseModeHTML, TelegramMessageLimit) {
+		if strings.Count(part, "&") != strings.Count(part, ";") {
+			t.Errorf("Part %d cuts through an entity", i)
+		}
+	}
+}
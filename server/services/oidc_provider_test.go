@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prabalesh/vigileye/config"
+)
+
+// mockOIDCProvider is a minimal OpenID provider: discovery, JWKS and a token endpoint that
+// checks PKCE and returns whatever ID token claims the test sets
+type mockOIDCProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	kid       string
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCProvider{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": m.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if id, secret, _ := r.BasicAuth(); id != "vigileye" || secret != "client-secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.PostForm.Get("code") != "auth-code" || PKCEChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(t, m.claims), "token_type": "Bearer"})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOIDCProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockOIDCProvider) validClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            m.URL,
+		"sub":            "user-123",
+		"aud":            "vigileye",
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "dev@example.com",
+		"email_verified": true,
+		"name":           "Dev",
+	}
+}
+
+func newTestOIDCProvider(m *mockOIDCProvider) *OIDCProvider {
+	return NewOIDCProvider(config.Config{
+		OIDCIssuer:       m.URL,
+		OIDCClientID:     "vigileye",
+		OIDCClientSecret: "client-secret",
+		OIDCRedirectURL:  "http://localhost:4000/api/auth/oidc/callback",
+	})
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	p := newTestOIDCProvider(m)
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if !strings.HasPrefix(authURL, m.URL+"/authorize?") || q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" {
+		t.Errorf("unexpected authorization URL %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != PKCEChallenge("verifier-1") {
+		t.Errorf("expected an S256 PKCE challenge, got %s", authURL)
+	}
+
+	m.challenge = q.Get("code_challenge")
+	m.claims = m.validClaims("nonce-1")
+
+	claims, err := p.Exchange(ctx, "auth-code", "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Issuer != m.URL || claims.Subject != "user-123" || claims.Email != "dev@example.com" || !claims.EmailVerified || claims.Name != "Dev" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// The token endpoint rejects a verifier that doesn't match the challenge
+	if _, err := p.Exchange(ctx, "auth-code", "wrong-verifier", "nonce-1"); err == nil {
+		t.Error("expected a PKCE mismatch to fail")
+	}
+}
+
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	p := newTestOIDCProvider(m)
+	ctx := context.Background()
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, m.validClaims("n"))
+	forged.Header["kid"] = m.kid
+	forgedToken, _ := forged.SignedString(otherKey)
+
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, m.validClaims("n")).SignedString([]byte("secret"))
+
+	with := func(key string, value interface{}) string {
+		claims := m.validClaims("n")
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return m.sign(t, claims)
+	}
+
+	tests := map[string]string{
+		"wrong signing key":   forgedToken,
+		"symmetric algorithm": hs256,
+		"wrong issuer":        with("iss", "https://evil.example.com"),
+		"wrong audience":      with("aud", "someone-else"),
+		"expired":             with("exp", time.Now().Add(-time.Hour).Unix()),
+		"no expiry":           with("exp", nil),
+		"nonce mismatch":      with("nonce", "other"),
+		"no nonce":            with("nonce", nil),
+		"no subject":          with("sub", nil),
+		"foreign azp":         with("aud", []string{"vigileye", "other"}),
+	}
+	for name, token := range tests {
+		if _, err := p.VerifyIDToken(ctx, token, "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: expected ErrInvalidIDToken, got %v", name, err)
+		}
+	}
+
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, m.validClaims("n")), "n"); err != nil {
+		t.Errorf("expected a valid token to verify, got %v", err)
+	}
+}
+
+func TestOIDCRefetchesRotatedKeys(t *testing.T) {
+	m := newMockOIDCProvider(t)
+	p := newTestOIDCProvider(m)
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, m.validClaims("n")), "n"); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	m.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	m.kid = "key-2"
+	// Refetches are rate limited; pretend the last one was a while ago
+	p.keysAt = time.Now().Add(-2 * jwksRefreshInterval)
+
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, m.validClaims("n")), "n"); err != nil {
+		t.Errorf("expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestEmailDomainAllowed(t *testing.T) {
+	domains := []string{"example.com"}
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"dev@example.com", true},
+		{"dev@EXAMPLE.com", true},
+		{"dev@sub.example.com", false},
+		{"dev@example.com.evil.io", false},
+		{"not-an-email", false},
+	}
+	for _, tt := range tests {
+		if got := EmailDomainAllowed(tt.email, domains); got != tt.want {
+			t.Errorf("EmailDomainAllowed(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+	if !EmailDomainAllowed("anyone@anywhere.io", nil) {
+		t.Error("expected no restriction without configured domains")
+	}
+}
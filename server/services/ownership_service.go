@@ -0,0 +1,152 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+var ErrInvalidOwnership = errors.New("invalid ownership file")
+
+// OwnershipFile is a project's stored ownership file
+type OwnershipFile struct {
+	Content   string     `json:"content"`
+	UpdatedBy *int       `json:"updated_by"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// OwnershipService stores per-project ownership files and applies them to new error groups
+type OwnershipService struct {
+	db     *sql.DB
+	groups *ErrorGroupService
+}
+
+func NewOwnershipService(db *sql.DB) *OwnershipService {
+	return &OwnershipService{db: db, groups: NewErrorGroupService(db)}
+}
+
+func (s *OwnershipService) Get(projectID int) (*OwnershipFile, error) {
+	var f OwnershipFile
+	err := s.db.QueryRow(`
+		SELECT content, updated_by, updated_at FROM project_ownership WHERE project_id = $1
+	`, projectID).Scan(&f.Content, &f.UpdatedBy, &f.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &OwnershipFile{}, nil
+	}
+	return &f, err
+}
+
+// Save validates and stores an ownership file, returning owners that are not project members
+func (s *OwnershipService) Save(projectID, userID int, content string) ([]string, error) {
+	ownership, err := utils.ParseOwnership(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOwnership, err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO project_ownership (project_id, content, updated_by, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (project_id) DO UPDATE
+		SET content = EXCLUDED.content, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+	`, projectID, content, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := projectMemberEmails(s.db, projectID, ownership.AllOwners())
+	if err != nil {
+		return nil, err
+	}
+	unknown := []string{}
+	for _, owner := range ownership.AllOwners() {
+		if _, ok := members[owner]; !ok {
+			unknown = append(unknown, owner)
+		}
+	}
+	return unknown, nil
+}
+
+// AutoAssign assigns an unassigned group to the first owner in the ownership file who is a project member
+func (s *OwnershipService) AutoAssign(projectID, groupID int) error {
+	var content string
+	err := s.db.QueryRow("SELECT content FROM project_ownership WHERE project_id = $1", projectID).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ownership, err := utils.ParseOwnership(content)
+	if err != nil {
+		return err
+	}
+
+	var stack, url sql.NullString
+	var assignedTo sql.NullInt64
+	err = s.db.QueryRow(`
+		SELECT stack, url, assigned_to FROM error_groups WHERE id = $1 AND project_id = $2
+	`, groupID, projectID).Scan(&stack, &url, &assignedTo)
+	if err == sql.ErrNoRows {
+		return ErrGroupNotFound
+	}
+	if err != nil || assignedTo.Valid {
+		return err
+	}
+
+	owners := ownership.Owners(url.String, utils.StackPaths(stack.String))
+	if len(owners) == 0 {
+		return nil
+	}
+
+	members, err := projectMemberEmails(s.db, projectID, owners)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		if userID, ok := members[owner]; ok {
+			log.Printf("[AutoAssign] Assigning group %d to user %d via ownership rules", groupID, userID)
+			return s.groups.Assign(projectID, groupID, &userID, nil)
+		}
+	}
+	return nil
+}
+
+// projectMemberEmails maps lowercased emails of project members (and the owner) to user IDs
+func projectMemberEmails(db *sql.DB, projectID int, emails []string) (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT LOWER(u.email), u.id FROM users u
+		LEFT JOIN project_members pm ON pm.user_id = u.id AND pm.project_id = $1
+		LEFT JOIN projects p ON p.owner_id = u.id AND p.id = $1
+		WHERE LOWER(u.email) = ANY($2) AND (pm.user_id IS NOT NULL OR p.id IS NOT NULL)
+	`, projectID, pq.Array(lowerAll(emails)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := map[string]int{}
+	for rows.Next() {
+		var email string
+		var id int
+		if err := rows.Scan(&email, &id); err != nil {
+			return nil, err
+		}
+		members[email] = id
+	}
+	return members, rows.Err()
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
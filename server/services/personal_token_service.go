@@ -0,0 +1,154 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const (
+	PersonalTokenPrefix = "vgl_pat_"
+
+	maxTokenExpiryDays     = 365
+	defaultTokenExpiryDays = 90
+	// last_used_at is only refreshed this often, so busy scripts don't write on every request
+	tokenUsageResolution = time.Minute
+)
+
+var (
+	ErrInvalidTokenInput  = errors.New("name (max 100 characters) and at least one valid scope are required; expiry must be 1-365 days")
+	ErrInvalidAccessToken = errors.New("invalid, expired or revoked token")
+	ErrTokenNotFound      = errors.New("token not found")
+)
+
+// PersonalToken is a user's API token, as listed; the secret itself is only returned on creation
+type PersonalToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TokenIdentity is who a personal access token acts as, and with which scopes
+type TokenIdentity struct {
+	TokenID int
+	UserID  int
+	Scopes  []string
+}
+
+type PersonalTokenService struct {
+	db *sql.DB
+}
+
+func NewPersonalTokenService(db *sql.DB) *PersonalTokenService {
+	return &PersonalTokenService{db: db}
+}
+
+// Create issues a token and returns it with its plaintext secret, which is never shown again
+func (s *PersonalTokenService) Create(userID int, name string, scopes []string, expiryDays int) (*PersonalToken, string, error) {
+	name = strings.TrimSpace(name)
+	if expiryDays == 0 {
+		expiryDays = defaultTokenExpiryDays
+	}
+	if name == "" || len(name) > 100 || len(scopes) == 0 || expiryDays < 1 || expiryDays > maxTokenExpiryDays {
+		return nil, "", ErrInvalidTokenInput
+	}
+	for _, scope := range scopes {
+		if !models.IsValidScope(scope) {
+			return nil, "", ErrInvalidTokenInput
+		}
+	}
+
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token := PersonalTokenPrefix + secret
+
+	t := &PersonalToken{Name: name, Prefix: token[:len(PersonalTokenPrefix)+4], Scopes: scopes}
+	err = s.db.QueryRow(`
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW() + make_interval(days => $6))
+		RETURNING id, expires_at, created_at
+	`, userID, name, utils.HashToken(token), t.Prefix, pq.Array(scopes), expiryDays).Scan(&t.ID, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// List returns the user's tokens that haven't been revoked, including expired ones
+func (s *PersonalTokenService) List(userID int) ([]PersonalToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, token_prefix, scopes, expires_at, last_used_at, last_used_ip, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []PersonalToken{}
+	for rows.Next() {
+		var t PersonalToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.LastUsedIP, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PersonalTokenService) Revoke(userID, tokenID int) error {
+	res, err := s.db.Exec(`
+		UPDATE personal_access_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented token and records its use
+func (s *PersonalTokenService) Authenticate(token, ip string) (*TokenIdentity, error) {
+	if !strings.HasPrefix(token, PersonalTokenPrefix) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	id := &TokenIdentity{}
+	var lastUsed *time.Time
+	err := s.db.QueryRow(`
+		SELECT id, user_id, scopes, last_used_at
+		FROM personal_access_tokens
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	`, utils.HashToken(token)).Scan(&id.TokenID, &id.UserID, pq.Array(&id.Scopes), &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsed == nil || time.Since(*lastUsed) > tokenUsageResolution {
+		_, err = s.db.Exec("UPDATE personal_access_tokens SET last_used_at = NOW(), last_used_ip = $1 WHERE id = $2", ip, id.TokenID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"database/sql"
+
+	"github.com/prabalesh/vigileye/models"
+)
+
+// LoadProjectAccess returns the user's role and environment overrides in the project, or nil if
+// they are not a member. The project owner is always an owner.
+func LoadProjectAccess(db *sql.DB, projectID, userID int) (*models.ProjectAccess, error) {
+	access := &models.ProjectAccess{EnvironmentRoles: map[int]string{}}
+	err := db.QueryRow(`
+		SELECT CASE WHEN p.owner_id = $2 THEN 'owner' ELSE pm.role END, p.deleted_at IS NOT NULL,
+		       p.require_2fa AND u.totp_enabled_at IS NULL
+		FROM projects p
+		JOIN users u ON u.id = $2
+		LEFT JOIN project_members pm ON pm.project_id = p.id AND pm.user_id = $2
+		WHERE p.id = $1 AND (p.owner_id = $2 OR pm.user_id IS NOT NULL)
+	`, projectID, userID).Scan(&access.Role, &access.ProjectDeleted, &access.TwoFactorMissing)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT environment_id, role FROM project_member_environment_roles
+		WHERE project_id = $1 AND user_id = $2
+	`, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var envID int
+		var role string
+		if err := rows.Scan(&envID, &role); err != nil {
+			return nil, err
+		}
+		access.EnvironmentRoles[envID] = role
+	}
+	return access, rows.Err()
+}
+
+// CanTriage reports whether the user may change the group's status, checking the role in the
+// group's environment. Unknown groups return ErrGroupNotFound.
+func (s *ErrorGroupService) CanTriage(projectID, groupID, userID int) (bool, error) {
+	access, err := LoadProjectAccess(s.db, projectID, userID)
+	if err != nil || access == nil || access.ProjectDeleted || access.TwoFactorMissing {
+		return false, err
+	}
+
+	var environmentID int
+	err = s.db.QueryRow("SELECT environment_id FROM error_groups WHERE id = $1 AND project_id = $2", groupID, projectID).Scan(&environmentID)
+	if err == sql.ErrNoRows {
+		return false, ErrGroupNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return access.CanIn(environmentID, models.PermErrorsTriage), nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/prabalesh/vigileye/models"
+)
+
+const projectPurgeBatchSize = 1000
+
+var (
+	ErrProjectNotFound   = errors.New("project not found")
+	ErrProjectNotDeleted = errors.New("project is not pending deletion")
+	ErrTransferTarget    = errors.New("ownership can only be transferred to an admin of the project")
+)
+
+// ProjectService handles project lifecycle: ownership transfer, soft deletion and purging
+type ProjectService struct {
+	db *sql.DB
+}
+
+func NewProjectService(db *sql.DB) *ProjectService {
+	return &ProjectService{db: db}
+}
+
+// SoftDelete hides the project and stops ingestion. Its data is kept until the grace period
+// ends, so the owner can still restore it.
+func (s *ProjectService) SoftDelete(projectID int, grace time.Duration) (*models.Project, error) {
+	var p models.Project
+	err := s.db.QueryRow(`
+		UPDATE projects SET deleted_at = NOW(), purge_after = NOW() + make_interval(secs => $2)
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, name, owner_id, created_at, deleted_at, purge_after
+	`, projectID, grace.Seconds()).Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt, &p.DeletedAt, &p.PurgeAfter)
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	}
+	return &p, err
+}
+
+// Restore undoes SoftDelete while the project hasn't been purged
+func (s *ProjectService) Restore(projectID int) (*models.Project, error) {
+	var p models.Project
+	err := s.db.QueryRow(`
+		UPDATE projects SET deleted_at = NULL, purge_after = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL AND purge_after > NOW()
+		RETURNING id, name, owner_id, created_at
+	`, projectID).Scan(&p.ID, &p.Name, &p.OwnerID, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotDeleted
+	}
+	return &p, err
+}
+
+// TransferOwnership makes an admin member the owner; the previous owner stays on as an admin
+func (s *ProjectService) TransferOwnership(projectID, fromUserID, toUserID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	err = tx.QueryRow("SELECT owner_id FROM projects WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", projectID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return ErrProjectNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if ownerID != fromUserID || toUserID == fromUserID {
+		return ErrTransferTarget
+	}
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, toUserID).Scan(&role)
+	if err == sql.ErrNoRows || (err == nil && role != models.RoleAdmin) {
+		return ErrTransferTarget
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE projects SET owner_id = $1 WHERE id = $2", toUserID, projectID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE project_members
+		SET role = CASE WHEN user_id = $2 THEN 'owner' ELSE 'admin' END
+		WHERE project_id = $1 AND user_id IN ($2, $3)
+	`, projectID, toUserID, fromUserID)
+	if err != nil {
+		return err
+	}
+	// The owner has full access everywhere, so overrides no longer apply
+	if _, err := tx.Exec("DELETE FROM project_member_environment_roles WHERE project_id = $1 AND user_id = $2", projectID, toUserID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeDeletedProjects permanently removes projects whose grace period has ended. Logs and error
+// groups go in batches first so the final cascading delete stays small.
+func (s *ProjectService) PurgeDeletedProjects(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM projects WHERE deleted_at IS NOT NULL AND purge_after <= NOW()
+	`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := s.purgeProject(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ProjectService) purgeProject(ctx context.Context, projectID int) error {
+	for _, table := range []string{"error_logs", "error_groups"} {
+		total := int64(0)
+		for {
+			res, err := s.db.ExecContext(ctx, `
+				DELETE FROM `+table+` WHERE id IN (
+					SELECT id FROM `+table+` WHERE project_id = $1 LIMIT $2
+				)
+			`, projectID, projectPurgeBatchSize)
+			if err != nil {
+				return err
+			}
+
+			n, _ := res.RowsAffected()
+			total += n
+			if n < projectPurgeBatchSize {
+				break
+			}
+			// Resume from the next run; the project stays deleted until it is fully purged
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+		if total > 0 {
+			log.Printf("[ProjectPurge] Deleted %d rows from %s for project %d", total, table, projectID)
+		}
+	}
+
+	// Environments, members and settings go with the project through ON DELETE CASCADE
+	_, err := s.db.ExecContext(ctx, "DELETE FROM projects WHERE id = $1 AND deleted_at IS NOT NULL", projectID)
+	if err != nil {
+		return err
+	}
+	log.Printf("[ProjectPurge] Purged project %d", projectID)
+	return nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+const retentionBatchSize = 1000
+
+type RetentionService struct {
+	db            *sql.DB
+	retentionDays int
+}
+
+func NewRetentionService(db *sql.DB, retentionDays int) *RetentionService {
+	return &RetentionService{db: db, retentionDays: retentionDays}
+}
+
+// PurgeExpiredLogs deletes error logs older than the retention period in small
+// batches so the table is never locked for long.
+func (s *RetentionService) PurgeExpiredLogs(ctx context.Context) error {
+	if s.retentionDays <= 0 {
+		return nil
+	}
+
+	total := int64(0)
+	for {
+		res, err := s.db.ExecContext(ctx, `
+			DELETE FROM error_logs WHERE id IN (
+				SELECT id FROM error_logs
+				WHERE created_at < NOW() - make_interval(days => $1)
+				LIMIT $2
+			)
+		`, s.retentionDays, retentionBatchSize)
+		if err != nil {
+			return err
+		}
+
+		n, _ := res.RowsAffected()
+		total += n
+		if n < retentionBatchSize {
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Printf("[Retention] Purged %d error logs older than %d days", total, s.retentionDays)
+	}
+	return nil
+}
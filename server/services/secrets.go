@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+// NewSecretBox builds the notification secret store from the server config
+func NewSecretBox(cfg config.Config) *utils.SecretBox {
+	box, err := utils.NewSecretBox(cfg.SecretKey, cfg.PreviousSecretKeys)
+	if err != nil {
+		log.Fatalf("Error initializing secret storage: %v", err)
+	}
+	return box
+}
+
+// notificationSecrets lists every secret field in the notification settings
+func notificationSecrets(n *models.NotificationSettings) []*string {
+	return []*string{
+		&n.Telegram.BotToken,
+		&n.Telegram.Escalation.BotToken,
+	}
+}
+
+// EncryptNotificationSecrets encrypts plaintext secrets in place
+func EncryptNotificationSecrets(box *utils.SecretBox, n *models.NotificationSettings) error {
+	for _, field := range notificationSecrets(n) {
+		if *field == "" || !box.NeedsRotation(*field) {
+			continue
+		}
+		plaintext, err := box.Decrypt(*field)
+		if err != nil {
+			return err
+		}
+		encrypted, err := box.Encrypt(plaintext)
+		if err != nil {
+			return err
+		}
+		*field = encrypted
+	}
+	return nil
+}
+
+// DecryptNotificationSecrets replaces encrypted secrets with their plaintext in place
+func DecryptNotificationSecrets(box *utils.SecretBox, n *models.NotificationSettings) error {
+	for _, field := range notificationSecrets(n) {
+		plaintext, err := box.Decrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = plaintext
+	}
+	return nil
+}
+
+// MaskNotificationSecrets hides secrets before settings leave the server
+func MaskNotificationSecrets(n *models.NotificationSettings) {
+	for _, field := range notificationSecrets(n) {
+		if *field != "" {
+			*field = models.MaskedSecret
+		}
+	}
+}
+
+// PreserveNotificationSecrets gives secrets write-only semantics: fields that
+// are omitted or still masked in an update keep their stored value.
+func PreserveNotificationSecrets(incoming, stored *models.NotificationSettings) {
+	in := notificationSecrets(incoming)
+	old := notificationSecrets(stored)
+	for i, field := range in {
+		if *field == "" || *field == models.MaskedSecret {
+			*field = *old[i]
+		}
+	}
+}
+
+// EncryptStoredSecrets encrypts plaintext secrets and re-encrypts secrets
+// sealed with a previous key. It is safe to run repeatedly.
+func EncryptStoredSecrets(ctx context.Context, db *sql.DB, box *utils.SecretBox) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, settings FROM environments")
+	if err != nil {
+		return 0, err
+	}
+
+	type envSettings struct {
+		id       int
+		settings models.EnvironmentSettings
+	}
+	pending := []envSettings{}
+	for rows.Next() {
+		var e envSettings
+		var settingsJSON []byte
+		if err := rows.Scan(&e.id, &settingsJSON); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(settingsJSON) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(settingsJSON, &e.settings); err != nil {
+			log.Printf("[Secrets] Skipping environment %d with invalid settings: %v", e.id, err)
+			continue
+		}
+
+		for _, field := range notificationSecrets(&e.settings.Notifications) {
+			if box.NeedsRotation(*field) {
+				pending = append(pending, e)
+				break
+			}
+		}
+	}
+	rows.Close()
+
+	updated := 0
+	for _, e := range pending {
+		if err := EncryptNotificationSecrets(box, &e.settings.Notifications); err != nil {
+			return updated, fmt.Errorf("environment %d: %w", e.id, err)
+		}
+		settingsJSON, err := json.Marshal(e.settings)
+		if err != nil {
+			return updated, err
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE environments SET settings = $1 WHERE id = $2", settingsJSON, e.id); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	SecurityLoginSucceeded    = "login_succeeded"
+	SecurityLoginFailed       = "login_failed"
+	SecurityLoginLocked       = "login_locked"
+	SecurityPasswordChanged   = "password_changed"
+	SecurityPasswordReset     = "password_reset"
+	SecurityTokenCreated      = "token_created"
+	SecurityTokenRevoked      = "token_revoked"
+	SecurityTwoFactorEnabled  = "two_factor_enabled"
+	SecurityTwoFactorDisabled = "two_factor_disabled"
+)
+
+// SecurityEvent is an entry in a user's sign-in and credential history
+type SecurityEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	IPAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RecordSecurityEvent appends an entry to a user's security log
+func RecordSecurityEvent(q execer, userID int, kind, ip, userAgent string, data map[string]interface{}) error {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO security_events (user_id, type, ip_address, user_agent, data) VALUES ($1, $2, $3, $4, $5)
+	`, userID, kind, ip, truncate(userAgent, 512), dataJSON)
+	return err
+}
+
+type SecurityEventService struct {
+	db *sql.DB
+}
+
+func NewSecurityEventService(db *sql.DB) *SecurityEventService {
+	return &SecurityEventService{db: db}
+}
+
+// List returns the user's security events, newest first, optionally of one type
+func (s *SecurityEventService) List(userID int, kind string, limit, offset int) ([]SecurityEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, type, ip_address, user_agent, data, created_at
+		FROM security_events
+		WHERE user_id = $1 AND ($2 = '' OR type = $2)
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4
+	`, userID, kind, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.IPAddress, &e.UserAgent, &e.Data, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+// refreshRaceWindow is how long after a rotation the previous refresh token is still taken as a
+// concurrent refresh rather than a copy
+const refreshRaceWindow = 10 * time.Second
+
+// maxUserAgent caps the user agent stored with a session
+const maxUserAgent = 512
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// Session is a signed-in device, as listed to its user
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// SessionTokens are issued on login and on every refresh. RefreshToken is empty when a refresh
+// lost a race with a concurrent one, whose refresh token the client keeps.
+type SessionTokens struct {
+	SessionID        string
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// SessionService issues short-lived access tokens backed by server-side sessions with rotating
+// refresh tokens
+type SessionService struct {
+	db         *sql.DB
+	jwtSecret  string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewSessionService(db *sql.DB, cfg config.Config) *SessionService {
+	return &SessionService{
+		db:         db,
+		jwtSecret:  cfg.JWTSecret,
+		accessTTL:  time.Duration(cfg.AccessTokenMinutes) * time.Minute,
+		refreshTTL: time.Duration(cfg.RefreshTokenDays) * 24 * time.Hour,
+	}
+}
+
+// SessionActive reports whether an access token's session is still valid
+func SessionActive(db *sql.DB, sessionID string, userID int) (bool, error) {
+	var active bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_sessions
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+		)
+	`, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// Create starts a session for a user who just authenticated
+func (s *SessionService) Create(userID int, userAgent, ip string) (*SessionTokens, error) {
+	sessionID, err := utils.GenerateToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &SessionTokens{SessionID: sessionID, RefreshToken: refresh, RefreshExpiresAt: time.Now().Add(s.refreshTTL)}
+	_, err = s.db.Exec(`
+		INSERT INTO user_sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, sessionID, userID, utils.HashToken(refresh), limitUserAgent(userAgent), ip, tokens.RefreshExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, s.signAccess(userID, tokens)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token. Reusing a
+// refresh token that was already rotated revokes the session, since one of the two holders
+// must have copied it, unless it was rotated moments ago by a concurrent refresh.
+func (s *SessionService) Refresh(refreshToken, userAgent, ip string) (*SessionTokens, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	hash := utils.HashToken(refreshToken)
+	var sessionID string
+	var userID int
+	var current, valid, racing bool
+	err = tx.QueryRow(`
+		SELECT id, user_id, refresh_token_hash = $1, revoked_at IS NULL AND expires_at > NOW(),
+		       COALESCE(rotated_at > NOW() - make_interval(secs => $2), FALSE)
+		FROM user_sessions
+		WHERE refresh_token_hash = $1 OR previous_refresh_hash = $1
+		FOR UPDATE
+	`, hash, refreshRaceWindow.Seconds()).Scan(&sessionID, &userID, &current, &valid, &racing)
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if !valid {
+		return nil, 0, ErrInvalidRefreshToken
+	}
+	if !current && racing {
+		tokens := &SessionTokens{SessionID: sessionID}
+		return tokens, userID, s.signAccess(userID, tokens)
+	}
+	if !current {
+		log.Printf("[SessionService] Rotated refresh token reused; revoking session of user %d", userID)
+		if _, err := tx.Exec("UPDATE user_sessions SET revoked_at = NOW() WHERE id = $1", sessionID); err != nil {
+			return nil, 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, ErrInvalidRefreshToken
+	}
+
+	next, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, 0, err
+	}
+	tokens := &SessionTokens{SessionID: sessionID, RefreshToken: next, RefreshExpiresAt: time.Now().Add(s.refreshTTL)}
+	_, err = tx.Exec(`
+		UPDATE user_sessions
+		SET refresh_token_hash = $1, previous_refresh_hash = $2, last_used_at = NOW(), rotated_at = NOW(),
+		    expires_at = $3, user_agent = $4, ip_address = $5
+		WHERE id = $6
+	`, utils.HashToken(next), hash, tokens.RefreshExpiresAt, limitUserAgent(userAgent), ip, sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+
+	return tokens, userID, s.signAccess(userID, tokens)
+}
+
+// limitUserAgent cuts a user agent to maxUserAgent characters for storage
+func limitUserAgent(userAgent string) string {
+	if utf8.RuneCountInString(userAgent) <= maxUserAgent {
+		return userAgent
+	}
+	return string([]rune(userAgent)[:maxUserAgent])
+}
+
+func (s *SessionService) signAccess(userID int, tokens *SessionTokens) error {
+	tokens.AccessExpiresAt = time.Now().Add(s.accessTTL)
+	token, err := utils.GenerateJWT(userID, tokens.SessionID, s.accessTTL, s.jwtSecret)
+	tokens.AccessToken = token
+	return err
+}
+
+// SignedInWithin reports whether the user's session started less than d ago, i.e. the user has
+// just authenticated
+func (s *SessionService) SignedInWithin(userID int, sessionID string, d time.Duration) (bool, error) {
+	var recent bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_sessions
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND created_at > NOW() - make_interval(secs => $3)
+		)
+	`, sessionID, userID, d.Seconds()).Scan(&recent)
+	return recent, err
+}
+
+// List returns the user's active sessions, most recently used first
+func (s *SessionService) List(userID int, currentSessionID string) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.UserAgent, &sess.IPAddress, &sess.CreatedAt, &sess.LastUsedAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sess.Current = sess.ID == currentSessionID
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of the user's sessions
+func (s *SessionService) Revoke(userID int, sessionID string) error {
+	res, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeByRefreshToken ends the session a refresh token belongs to
+func (s *SessionService) RevokeByRefreshToken(refreshToken string) error {
+	_, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE refresh_token_hash = $1 AND revoked_at IS NULL
+	`, utils.HashToken(refreshToken))
+	return err
+}
+
+// RevokeAll ends every session of the user, optionally keeping one (e.g. the caller's)
+func (s *SessionService) RevokeAll(userID int, exceptSessionID string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, exceptSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
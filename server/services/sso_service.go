@@ -0,0 +1,100 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrSSOEmailUnverified = errors.New("the identity provider did not return a verified email address")
+	ErrSSODomain          = errors.New("this email domain is not allowed to sign in")
+)
+
+// SSOService signs in users authenticated by an identity provider, provisioning accounts on first
+// login
+type SSOService struct {
+	db             *sql.DB
+	allowedDomains []string
+}
+
+func NewSSOService(db *sql.DB, allowedDomains []string) *SSOService {
+	return &SSOService{db: db, allowedDomains: allowedDomains}
+}
+
+// EmailDomainAllowed reports whether the email's domain is in the list; an empty list allows all
+func EmailDomainAllowed(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range domains {
+		if domain == strings.ToLower(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignIn returns the user for an identity. Known identities sign straight in; otherwise the
+// identity is linked to the account with the same verified email, or a new account is created.
+func (s *SSOService) SignIn(claims *OIDCClaims) (int, error) {
+	if len(s.allowedDomains) > 0 && (!claims.EmailVerified || !EmailDomainAllowed(claims.Email, s.allowedDomains)) {
+		return 0, ErrSSODomain
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`
+		UPDATE user_identities SET last_login_at = NOW(), email = $3
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id
+	`, claims.Issuer, claims.Subject, claims.Email).Scan(&userID)
+	if err == nil {
+		return userID, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// Linking by email is only safe when the provider vouches for it
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, ErrSSOEmailUnverified
+	}
+	email := strings.ToLower(claims.Email)
+
+	err = tx.QueryRow("SELECT id FROM users WHERE LOWER(email) = $1", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		name := claims.Name
+		if name == "" {
+			name, _, _ = strings.Cut(email, "@")
+		}
+		// SSO accounts have no password; an empty hash never matches one
+		err = tx.QueryRow(
+			"INSERT INTO users (email, password_hash, name, email_verified_at) VALUES ($1, '', $2, NOW()) RETURNING id",
+			email, name,
+		).Scan(&userID)
+	} else if err == nil {
+		_, err = tx.Exec("UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1", userID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)
+	`, userID, claims.Issuer, claims.Subject, email)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+var (
+	ErrHelperBotWebhook = errors.New("the helper bot can't send interactive alerts, use a separate bot")
+	ErrWebhookBotShared = errors.New("another environment already uses this bot for interactive alerts, give each one its own bot")
+)
+
+// Alert button actions carried in callback_data as "vg:<action>:<group_id>"
+const (
+	AlertActionResolve  = "resolve"
+	AlertActionIgnore   = "ignore"
+	AlertActionSnooze1h = "snooze1h"
+	AlertActionAssignMe = "assign"
+)
+
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// AlertActionKeyboard builds the triage buttons attached to an alert
+func AlertActionKeyboard(groupID int) interface{} {
+	button := func(text, action string) inlineKeyboardButton {
+		return inlineKeyboardButton{Text: text, CallbackData: fmt.Sprintf("vg:%s:%d", action, groupID)}
+	}
+
+	return inlineKeyboardMarkup{
+		InlineKeyboard: [][]inlineKeyboardButton{
+			{button("✅ Resolve", AlertActionResolve), button("🙈 Ignore", AlertActionIgnore)},
+			{button("⏰ Snooze 1h", AlertActionSnooze1h), button("🙋 Assign to me", AlertActionAssignMe)},
+		},
+	}
+}
+
+// ParseAlertAction decodes the callback_data of an alert button
+func ParseAlertAction(data string) (string, int, error) {
+	parts := strings.Split(data, ":")
+	if len(parts) != 3 || parts[0] != "vg" {
+		return "", 0, fmt.Errorf("unrecognized callback data %q", data)
+	}
+
+	switch parts[1] {
+	case AlertActionResolve, AlertActionIgnore, AlertActionSnooze1h, AlertActionAssignMe:
+	default:
+		return "", 0, fmt.Errorf("unknown action %q", parts[1])
+	}
+
+	groupID, err := strconv.Atoi(parts[2])
+	if err != nil || groupID <= 0 {
+		return "", 0, fmt.Errorf("invalid group id %q", parts[2])
+	}
+
+	return parts[1], groupID, nil
+}
+
+// AlertActionSummary describes a completed action for the edited alert message
+func AlertActionSummary(action, actorName string) string {
+	switch action {
+	case AlertActionResolve:
+		return fmt.Sprintf("✅ Resolved by %s", actorName)
+	case AlertActionIgnore:
+		return fmt.Sprintf("🙈 Ignored by %s", actorName)
+	case AlertActionSnooze1h:
+		return fmt.Sprintf("⏰ Snoozed for 1h by %s", actorName)
+	case AlertActionAssignMe:
+		return fmt.Sprintf("🙋 Assigned to %s", actorName)
+	}
+	return ""
+}
+
+// CheckWebhookBot reports whether botToken may take the webhook of an interactive environment.
+// A bot has a single webhook, so a bot shared with another interactive environment would steal
+// its button presses, and the helper bot would stop receiving commands.
+func CheckWebhookBot(db *sql.DB, box *utils.SecretBox, environmentID int, botToken, helperBotToken string) error {
+	if helperBotToken != "" && botToken == helperBotToken {
+		return ErrHelperBotWebhook
+	}
+
+	rows, err := db.Query(`
+		SELECT settings FROM environments
+		WHERE id <> $1
+		  AND (settings->'notifications'->'telegram'->>'enabled')::boolean IS TRUE
+		  AND (settings->'notifications'->'telegram'->>'interactive')::boolean IS TRUE
+	`, environmentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var settingsJSON []byte
+		if err := rows.Scan(&settingsJSON); err != nil {
+			return err
+		}
+		var settings models.EnvironmentSettings
+		if err := json.Unmarshal(settingsJSON, &settings); err != nil {
+			continue
+		}
+		other, err := box.Decrypt(settings.Notifications.Telegram.BotToken)
+		if err != nil {
+			return err
+		}
+		if other == botToken {
+			return ErrWebhookBotShared
+		}
+	}
+	return rows.Err()
+}
+
+// WebhookSecretToken derives the per-environment secret Telegram sends back with webhook updates
+func WebhookSecretToken(serverKey string, environmentID int) string {
+	mac := hmac.New(sha256.New, []byte(serverKey))
+	fmt.Fprintf(mac, "telegram-webhook:%d", environmentID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
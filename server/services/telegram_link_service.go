@@ -0,0 +1,336 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prabalesh/vigileye/models"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const linkCodeTTL = 10 * time.Minute
+
+const (
+	LinkPurposeUser = "user"
+	LinkPurposeChat = "chat"
+)
+
+var (
+	ErrInvalidLinkCode   = errors.New("invalid or expired link code")
+	ErrTelegramNotLinked = errors.New("telegram account not linked")
+	ErrChatNotLinked     = errors.New("chat not linked to a project")
+)
+
+// TelegramLinkService maps Telegram accounts and chats to Vigileye users and
+// projects via one-time codes generated in the dashboard
+type TelegramLinkService struct {
+	db *sql.DB
+}
+
+func NewTelegramLinkService(db *sql.DB) *TelegramLinkService {
+	return &TelegramLinkService{db: db}
+}
+
+// LinkResult describes what a redeemed code was linked to
+type LinkResult struct {
+	Purpose     string
+	UserID      int
+	ProjectID   int
+	ProjectName string
+}
+
+// TelegramChat is a chat linked to a project
+type TelegramChat struct {
+	ChatID        int64     `json:"chat_id"`
+	ChatTitle     string    `json:"chat_title"`
+	LinkedBy      *int      `json:"linked_by"`
+	Subscriptions []string  `json:"subscriptions"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// CreateUserLinkCode issues a code the user sends to the helper bot as /link <code>
+func (s *TelegramLinkService) CreateUserLinkCode(userID int) (string, time.Time, error) {
+	return s.createCode(userID, nil, LinkPurposeUser)
+}
+
+// CreateChatLinkCode issues a code that links the chat it is sent from to a project
+func (s *TelegramLinkService) CreateChatLinkCode(userID, projectID int) (string, time.Time, error) {
+	return s.createCode(userID, &projectID, LinkPurposeChat)
+}
+
+func (s *TelegramLinkService) createCode(userID int, projectID *int, purpose string) (string, time.Time, error) {
+	code, err := utils.GenerateCode(8)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(linkCodeTTL)
+	_, err = s.db.Exec(`
+		INSERT INTO telegram_link_codes (code, user_id, project_id, purpose, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, code, userID, projectID, purpose, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return code, expiresAt, nil
+}
+
+// Redeem consumes a code sent to the helper bot. User codes link the sender's
+// Telegram account; chat codes link the chat the code was sent from.
+func (s *TelegramLinkService) Redeem(code string, chatID int64, chatTitle string, telegramUserID int64, telegramUsername string) (*LinkResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var result LinkResult
+	var projectID sql.NullInt64
+	err = tx.QueryRow(`
+		UPDATE telegram_link_codes SET used_at = NOW()
+		WHERE code = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING purpose, user_id, project_id
+	`, code).Scan(&result.Purpose, &result.UserID, &projectID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidLinkCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch result.Purpose {
+	case LinkPurposeUser:
+		// A Telegram account and a Vigileye user each map to at most one counterpart
+		_, err = tx.Exec("DELETE FROM telegram_user_links WHERE telegram_user_id = $1 OR user_id = $2", telegramUserID, result.UserID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO telegram_user_links (telegram_user_id, user_id, telegram_username)
+			VALUES ($1, $2, $3)
+		`, telegramUserID, result.UserID, telegramUsername)
+		if err != nil {
+			return nil, err
+		}
+
+	case LinkPurposeChat:
+		result.ProjectID = int(projectID.Int64)
+		err = tx.QueryRow("SELECT name FROM projects WHERE id = $1", result.ProjectID).Scan(&result.ProjectName)
+		if err != nil {
+			return nil, ErrInvalidLinkCode
+		}
+
+		// Relinking a chat to another project drops its old subscriptions
+		_, err = tx.Exec("DELETE FROM telegram_chat_links WHERE chat_id = $1", chatID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO telegram_chat_links (chat_id, project_id, chat_title, linked_by)
+			VALUES ($1, $2, $3, $4)
+		`, chatID, result.ProjectID, chatTitle, result.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, ErrInvalidLinkCode
+	}
+
+	return &result, tx.Commit()
+}
+
+// LookupUser returns the Vigileye user linked to a Telegram account
+func (s *TelegramLinkService) LookupUser(telegramUserID int64) (int, string, error) {
+	var userID int
+	var name string
+	err := s.db.QueryRow(`
+		SELECT u.id, u.name FROM telegram_user_links tl
+		JOIN users u ON u.id = tl.user_id
+		WHERE tl.telegram_user_id = $1
+	`, telegramUserID).Scan(&userID, &name)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrTelegramNotLinked
+	}
+	return userID, name, err
+}
+
+// GetUserLink returns the Telegram username linked to a user, if any
+func (s *TelegramLinkService) GetUserLink(userID int) (int64, string, error) {
+	var telegramUserID int64
+	var username sql.NullString
+	err := s.db.QueryRow(`
+		SELECT telegram_user_id, telegram_username FROM telegram_user_links WHERE user_id = $1
+	`, userID).Scan(&telegramUserID, &username)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrTelegramNotLinked
+	}
+	return telegramUserID, username.String, err
+}
+
+func (s *TelegramLinkService) Unlink(userID int) error {
+	_, err := s.db.Exec("DELETE FROM telegram_user_links WHERE user_id = $1", userID)
+	return err
+}
+
+// LinkedProject returns the project a chat is linked to
+func (s *TelegramLinkService) LinkedProject(chatID int64) (int, string, error) {
+	var projectID int
+	var name string
+	err := s.db.QueryRow(`
+		SELECT p.id, p.name FROM telegram_chat_links tc
+		JOIN projects p ON p.id = tc.project_id
+		WHERE tc.chat_id = $1 AND p.deleted_at IS NULL
+	`, chatID).Scan(&projectID, &name)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrChatNotLinked
+	}
+	return projectID, name, err
+}
+
+// HiddenEnvironments lists the environments a linked chat must not show: those withheld from
+// whoever linked it and, when the sender has linked their Telegram account, from the sender.
+// A chat whose linker has left the project counts as unlinked.
+func (s *TelegramLinkService) HiddenEnvironments(chatID int64, projectID int, telegramUserID int64) ([]int, error) {
+	var linkedBy sql.NullInt64
+	err := s.db.QueryRow("SELECT linked_by FROM telegram_chat_links WHERE chat_id = $1", chatID).Scan(&linkedBy)
+	if err == sql.ErrNoRows || (err == nil && !linkedBy.Valid) {
+		return nil, ErrChatNotLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	linker, err := LoadProjectAccess(s.db, projectID, int(linkedBy.Int64))
+	if err != nil {
+		return nil, err
+	}
+	if linker == nil {
+		return nil, ErrChatNotLinked
+	}
+	hidden := linker.DeniedEnvironments(models.PermProjectView)
+
+	userID, _, err := s.LookupUser(telegramUserID)
+	if err == ErrTelegramNotLinked {
+		return hidden, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sender, err := LoadProjectAccess(s.db, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if sender != nil {
+		hidden = append(hidden, sender.DeniedEnvironments(models.PermProjectView)...)
+	}
+	return hidden, nil
+}
+
+// ProjectChats lists the chats linked to a project with their subscriptions
+func (s *TelegramLinkService) ProjectChats(projectID int) ([]TelegramChat, error) {
+	rows, err := s.db.Query(`
+		SELECT tc.chat_id, COALESCE(tc.chat_title, ''), tc.linked_by, tc.created_at,
+		       COALESCE(array_agg(e.name ORDER BY e.name) FILTER (WHERE e.name IS NOT NULL), '{}')
+		FROM telegram_chat_links tc
+		LEFT JOIN telegram_chat_subscriptions ts ON ts.chat_id = tc.chat_id
+		LEFT JOIN environments e ON e.id = ts.environment_id
+		WHERE tc.project_id = $1
+		GROUP BY tc.chat_id
+		ORDER BY tc.created_at ASC
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []TelegramChat{}
+	for rows.Next() {
+		var c TelegramChat
+		var subs pq.StringArray
+		if err := rows.Scan(&c.ChatID, &c.ChatTitle, &c.LinkedBy, &c.CreatedAt, &subs); err != nil {
+			return nil, err
+		}
+		c.Subscriptions = []string(subs)
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
+
+func (s *TelegramLinkService) UnlinkChat(projectID int, chatID int64) error {
+	_, err := s.db.Exec("DELETE FROM telegram_chat_links WHERE project_id = $1 AND chat_id = $2", projectID, chatID)
+	return err
+}
+
+// SetSubscription subscribes or unsubscribes a linked chat to an environment's alerts by name.
+// Environments in exclude cannot be subscribed to.
+func (s *TelegramLinkService) SetSubscription(chatID int64, projectID int, environmentName string, subscribed bool, exclude []int) error {
+	var environmentID int
+	err := s.db.QueryRow("SELECT id FROM environments WHERE project_id = $1 AND name = $2", projectID, environmentName).Scan(&environmentID)
+	if err == sql.ErrNoRows || (err == nil && subscribed && slices.Contains(exclude, environmentID)) {
+		return ErrEnvironmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if subscribed {
+		_, err = s.db.Exec(`
+			INSERT INTO telegram_chat_subscriptions (chat_id, environment_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, chatID, environmentID)
+	} else {
+		_, err = s.db.Exec("DELETE FROM telegram_chat_subscriptions WHERE chat_id = $1 AND environment_id = $2", chatID, environmentID)
+	}
+	return err
+}
+
+// SubscribedChats returns the linked chats that mirror alerts for an environment. Access is
+// checked at send time, so chats whose linker lost access to the environment are skipped.
+func (s *TelegramLinkService) SubscribedChats(environmentID int) ([]int64, error) {
+	rows, err := s.db.Query(`
+		SELECT ts.chat_id, tc.project_id, tc.linked_by FROM telegram_chat_subscriptions ts
+		JOIN telegram_chat_links tc ON tc.chat_id = ts.chat_id
+		JOIN environments e ON e.id = ts.environment_id AND e.project_id = tc.project_id
+		JOIN projects p ON p.id = tc.project_id AND p.deleted_at IS NULL
+		WHERE ts.environment_id = $1 AND tc.linked_by IS NOT NULL
+	`, environmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	type subscription struct {
+		chatID    int64
+		projectID int
+		linkedBy  int
+	}
+	subs := []subscription{}
+	for rows.Next() {
+		var sub subscription
+		if err := rows.Scan(&sub.chatID, &sub.projectID, &sub.linkedBy); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	chats := []int64{}
+	for _, sub := range subs {
+		access, err := LoadProjectAccess(s.db, sub.projectID, sub.linkedBy)
+		if err != nil {
+			return nil, err
+		}
+		if access != nil && access.CanIn(environmentID, models.PermProjectView) {
+			chats = append(chats, sub.chatID)
+		}
+	}
+	return chats, nil
+}
@@ -7,129 +7,146 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/prabalesh/vigileye/models"
 )
 
+const TelegramAPIURL = "https://api.telegram.org"
+
 type TelegramService struct {
 	client *http.Client
+	apiURL string
 }
 
 func NewTelegramService() *TelegramService {
+	return NewTelegramServiceWithAPIURL(TelegramAPIURL)
+}
+
+// NewTelegramServiceWithAPIURL targets a different Bot API server (e.g. a fake in tests)
+func NewTelegramServiceWithAPIURL(apiURL string) *TelegramService {
 	return &TelegramService{
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		apiURL: apiURL,
 	}
 }
 
 // TestNotification sends a test message to verify bot setup
-func (s *TelegramService) TestNotification(botToken, chatID string) error {
-	message := "🔔 *Test Notification from Vigil Eye*\n\n" +
-		"Your Telegram notifications are configured correctly!\n\n" +
-		"You will receive alerts when:\n" +
-		"• New unique errors occur\n" +
-		"• Error thresholds are reached\n" +
-		"• Ignored errors spike unexpectedly\n\n" +
-		"✅ Setup complete!"
-
-	return s.sendMessage(botToken, chatID, message)
+func (s *TelegramService) TestNotification(botToken, chatID string, templates models.TelegramTemplates) error {
+	return s.send(botToken, chatID, templates, TemplateTest, nil, nil)
 }
 
 // SendErrorNotification sends error alert to Telegram
 func (s *TelegramService) SendErrorNotification(botToken, chatID string, data *ErrorNotificationData) error {
-	message := s.formatErrorMessage(data)
-	return s.sendMessage(botToken, chatID, message)
+	var markup interface{}
+	if data.Interactive {
+		markup = AlertActionKeyboard(data.GroupID)
+	}
+	return s.send(botToken, chatID, data.Templates, TemplateError, data, markup)
 }
 
 // SendSpikeAlert sends alert for ignored error that's spiking
 func (s *TelegramService) SendSpikeAlert(botToken, chatID string, data *ErrorNotificationData) error {
-	message := fmt.Sprintf(
-		"⚠️ *IGNORED ERROR SPIKE* in %s\n\n"+
-			"An ignored error is suddenly spiking!\n\n"+
-			"*Error:* %s\n"+
-			"*Environment:* %s\n"+
-			"*Occurrences:* %d (100x higher than normal)\n"+
-			"*Status:* Previously ignored\n\n"+
-			"This might indicate a new issue. Consider investigating.\n\n"+
-			"[View Details](%s)",
-		data.Environment,
-		escapeMarkdown(data.Message),
-		data.Environment,
-		data.OccurrenceCount,
-		data.ViewURL,
-	)
-
-	return s.sendMessage(botToken, chatID, message)
-}
-
-func (s *TelegramService) formatErrorMessage(data *ErrorNotificationData) string {
-	emoji := "🔴"
-	status := "NEW"
-
-	if data.Level == "warn" {
-		emoji = "🟡"
-	}
+	return s.send(botToken, chatID, data.Templates, TemplateSpike, data, nil)
+}
+
+// SendRegressionAlert tells an assignee that their resolved error is occurring again
+func (s *TelegramService) SendRegressionAlert(botToken, chatID string, data *ErrorNotificationData) error {
+	return s.send(botToken, chatID, data.Templates, TemplateRegression, data, nil)
+}
 
-	if data.OccurrenceCount > 1 {
-		status = "RECURRING"
+// SendEscalationAlert sends alert for an error that is still firing after the escalation window
+func (s *TelegramService) SendEscalationAlert(botToken, chatID string, data *ErrorNotificationData, afterMinutes int) error {
+	escalation := *data
+	escalation.AfterMinutes = afterMinutes
+	return s.send(botToken, chatID, data.Templates, TemplateEscalation, &escalation, nil)
+}
+
+// SendDigest sends a periodic summary of errors in an environment
+func (s *TelegramService) SendDigest(botToken, chatID string, data *DigestData) error {
+	return s.send(botToken, chatID, data.Templates, TemplateDigest, data, nil)
+}
+
+// send renders a template and delivers it, split across several messages if needed;
+// the reply markup goes on the last part so buttons sit under the full alert
+func (s *TelegramService) send(botToken, chatID string, templates models.TelegramTemplates, kind string, data interface{}, markup interface{}) error {
+	parts, parseMode, err := RenderTelegram(templates, kind, data)
+	if err != nil {
+		return fmt.Errorf("failed to render %s message: %w", kind, err)
 	}
 
-	message := fmt.Sprintf(
-		"%s *%s ERROR* in %s\n\n"+
-			"*Message:* %s\n"+
-			"*Environment:* %s\n"+
-			"*Level:* %s\n"+
-			"*Occurrences:* %d\n"+
-			"*First Seen:* %s\n"+
-			"*Status:* Unresolved\n\n",
-		emoji,
-		status,
-		data.Environment,
-		escapeMarkdown(data.Message),
-		data.Environment,
-		data.Level,
-		data.OccurrenceCount,
-		data.FirstSeen.Format("Jan 2, 3:04 PM"),
-	)
-
-	// Add stack trace preview (first 3 lines)
-	if data.StackPreview != "" {
-		lines := strings.Split(data.StackPreview, "\n")
-		previewLines := lines
-		if len(lines) > 3 {
-			previewLines = lines[:3]
+	for i, text := range parts {
+		payload := map[string]interface{}{
+			"chat_id":    chatID,
+			"text":       text,
+			"parse_mode": parseMode,
+		}
+		if markup != nil && i == len(parts)-1 {
+			payload["reply_markup"] = markup
 		}
 
-		message += "*Stack Trace:*\n```\n"
-		message += strings.Join(previewLines, "\n")
-		if len(lines) > 3 {
-			message += "\n... (view full stack in dashboard)"
+		if _, err := s.call(botToken, "sendMessage", payload); err != nil {
+			return err
 		}
-		message += "\n```\n\n"
 	}
-
-	// Add link to view full details
-	message += fmt.Sprintf("[View Full Details](%s)", data.ViewURL)
-
-	return message
+	return nil
 }
 
-func (s *TelegramService) sendMessage(botToken, chatID, text string) error {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", botToken)
-
+// EditMessageText replaces the text of a previously sent message, keeping its formatting entities
+func (s *TelegramService) EditMessageText(botToken string, chatID int64, messageID int, text string, entities interface{}, markup interface{}) error {
 	payload := map[string]interface{}{
 		"chat_id":    chatID,
+		"message_id": messageID,
 		"text":       text,
-		"parse_mode": "Markdown",
 	}
+	if entities != nil {
+		payload["entities"] = entities
+	}
+	if markup != nil {
+		payload["reply_markup"] = markup
+	}
+
+	_, err := s.call(botToken, "editMessageText", payload)
+	return err
+}
+
+// AnswerCallbackQuery acknowledges an inline button press with a short toast
+func (s *TelegramService) AnswerCallbackQuery(botToken, callbackQueryID, text string) error {
+	_, err := s.call(botToken, "answerCallbackQuery", map[string]interface{}{
+		"callback_query_id": callbackQueryID,
+		"text":              text,
+	})
+	return err
+}
+
+// SetWebhook points the bot's updates at our server; Telegram echoes secretToken
+// in the X-Telegram-Bot-Api-Secret-Token header of every delivery.
+func (s *TelegramService) SetWebhook(botToken, url, secretToken string) error {
+	_, err := s.call(botToken, "setWebhook", map[string]interface{}{
+		"url":             url,
+		"secret_token":    secretToken,
+		"allowed_updates": []string{"callback_query"},
+	})
+	return err
+}
+
+func (s *TelegramService) DeleteWebhook(botToken string) error {
+	_, err := s.call(botToken, "deleteWebhook", map[string]interface{}{})
+	return err
+}
+
+func (s *TelegramService) call(botToken, method string, payload map[string]interface{}) (json.RawMessage, error) {
+	url := fmt.Sprintf("%s/bot%s/%s", s.apiURL, botToken, method)
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
 	resp, err := s.client.Post(url, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -140,46 +157,25 @@ func (s *TelegramService) sendMessage(botToken, chatID, text string) error {
 		// Handle common errors with user-friendly messages
 		if description, ok := errorResp["description"].(string); ok {
 			if strings.Contains(description, "bot was blocked") {
-				return fmt.Errorf("bot was blocked by user or removed from group")
+				return nil, fmt.Errorf("bot was blocked by user or removed from group")
 			}
 			if strings.Contains(description, "chat not found") {
-				return fmt.Errorf("invalid chat ID or bot not in group")
+				return nil, fmt.Errorf("invalid chat ID or bot not in group")
 			}
 			if strings.Contains(description, "Unauthorized") {
-				return fmt.Errorf("invalid bot token")
+				return nil, fmt.Errorf("invalid bot token")
 			}
-			return fmt.Errorf("telegram API error: %s", description)
+			return nil, fmt.Errorf("telegram API error: %s", description)
 		}
 
-		return fmt.Errorf("telegram API error: status %d", resp.StatusCode)
+		return nil, fmt.Errorf("telegram API error: status %d", resp.StatusCode)
 	}
 
-	return nil
-}
-
-// escapeMarkdown escapes special Markdown characters
-func escapeMarkdown(text string) string {
-	replacer := strings.NewReplacer(
-		"_", "\\_",
-		"*", "\\*",
-		"[", "\\[",
-		"]", "\\]",
-		"(", "\\(",
-		")", "\\)",
-		"~", "\\~",
-		"`", "\\`",
-		">", "\\>",
-		"#", "\\#",
-		"+", "\\+",
-		"-", "\\-",
-		"=", "\\=",
-		"|", "\\|",
-		"{", "\\{",
-		"}", "\\}",
-		".", "\\.",
-		"!", "\\!",
-	)
-	return replacer.Replace(text)
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result.Result, nil
 }
 
 type ErrorNotificationData struct {
@@ -190,4 +186,24 @@ type ErrorNotificationData struct {
 	FirstSeen       time.Time
 	StackPreview    string
 	ViewURL         string
+	GroupID         int
+	Interactive     bool
+	// AfterMinutes is set on escalation alerts
+	AfterMinutes int
+	Templates    models.TelegramTemplates
+}
+
+type DigestData struct {
+	Environment string
+	Since       time.Time
+	TotalEvents int
+	NewGroups   int
+	TopGroups   []DigestGroup
+	ViewURL     string
+	Templates   models.TelegramTemplates
+}
+
+type DigestGroup struct {
+	Message string
+	Events  int
 }
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prabalesh/vigileye/config"
+	"github.com/prabalesh/vigileye/utils"
+)
+
+const (
+	mfaTokenPurpose   = "mfa"
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+	// After this many wrong codes in a row the second step is locked, like password sign-in
+	maxTOTPFailures = 5
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp   = errors.New("two-factor authentication has not been set up")
+	ErrInvalidTOTPCode     = errors.New("invalid authentication code")
+	ErrInvalidMFAToken     = errors.New("sign-in expired, please sign in again")
+	ErrTooManyTOTPFailures = errors.New("too many invalid codes, please try again later")
+)
+
+// TwoFactorService manages TOTP enrollment, recovery codes and the second login step
+type TwoFactorService struct {
+	db     *sql.DB
+	box    *utils.SecretBox
+	secret string
+	now    func() time.Time
+}
+
+func NewTwoFactorService(db *sql.DB, cfg config.Config) *TwoFactorService {
+	return &TwoFactorService{db: db, box: NewSecretBox(cfg), secret: cfg.SecretKey, now: time.Now}
+}
+
+// Enabled reports whether the user has confirmed a TOTP device
+func (s *TwoFactorService) Enabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Setup generates a new pending secret and returns it with its provisioning URI. It only takes
+// effect once Enable confirms a code from it.
+func (s *TwoFactorService) Setup(userID int) (string, string, error) {
+	var email string
+	var enabled bool
+	err := s.db.QueryRow("SELECT email, totp_enabled_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&email, &enabled)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := s.box.Encrypt(secret)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := s.db.Exec("UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2", encrypted, userID); err != nil {
+		return "", "", err
+	}
+	return secret, utils.TOTPProvisioningURI(secret, email, "Vigil Eye"), nil
+}
+
+// Enable confirms the pending secret with a code from the authenticator and returns a fresh set
+// of recovery codes, which are only shown this once
+func (s *TwoFactorService) Enable(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var encrypted sql.NullString
+	var enabled bool
+	err = tx.QueryRow(`
+		SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&encrypted, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !encrypted.Valid {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	secret, err := s.box.Decrypt(encrypted.String)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := utils.ValidateTOTP(secret, code, s.now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1, totp_failures = 0 WHERE id = $2
+	`, step, userID)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Disable turns two-factor authentication off after checking a current code
+func (s *TwoFactorService) Disable(userID int, code string) error {
+	return s.withVerifiedCode(userID, code, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1
+		`, userID)
+		return err
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a current code
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	var codes []string
+	err := s.withVerifiedCode(userID, code, func(tx *sql.Tx) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Verify checks a TOTP code or an unused recovery code for the second login step
+func (s *TwoFactorService) Verify(userID int, code string) error {
+	return s.withVerifiedCode(userID, code, nil)
+}
+
+// withVerifiedCode checks code and, if it is valid, consumes it and runs fn in the same
+// transaction. Wrong codes are counted across logins and lock the second step for a minute,
+// doubling up to an hour, so it can't be brute-forced.
+func (s *TwoFactorService) withVerifiedCode(userID int, code string, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var encrypted sql.NullString
+	var enabled bool
+	var lastStep int64
+	var locked bool
+	err = tx.QueryRow(`
+		SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step, COALESCE(totp_locked_until > NOW(), FALSE)
+		FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&encrypted, &enabled, &lastStep, &locked)
+	if err != nil {
+		return err
+	}
+	if !enabled || !encrypted.Valid {
+		return ErrTwoFactorNotSetUp
+	}
+	if locked {
+		return ErrTooManyTOTPFailures
+	}
+
+	secret, err := s.box.Decrypt(encrypted.String)
+	if err != nil {
+		return err
+	}
+
+	valid := false
+	if step, ok := utils.ValidateTOTP(secret, code, s.now()); ok && step > lastStep {
+		valid = true
+		if _, err := tx.Exec("UPDATE users SET totp_last_step = $1 WHERE id = $2", step, userID); err != nil {
+			return err
+		}
+	} else {
+		res, err := tx.Exec(`
+			UPDATE user_recovery_codes SET used_at = NOW()
+			WHERE id = (
+				SELECT id FROM user_recovery_codes
+				WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+				LIMIT 1
+			)
+		`, userID, utils.HashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		valid = n == 1
+	}
+
+	if !valid {
+		var failures int
+		err := tx.QueryRow(`
+			UPDATE users SET
+				totp_failures = CASE WHEN totp_last_failure_at < NOW() - make_interval(secs => $2)
+					THEN 1 ELSE totp_failures + 1 END,
+				totp_last_failure_at = NOW()
+			WHERE id = $1
+			RETURNING totp_failures
+		`, userID, loginFailureWindow.Seconds()).Scan(&failures)
+		if err != nil {
+			return err
+		}
+		if lockout := loginLockout(failures, maxTOTPFailures); lockout > 0 {
+			_, err := tx.Exec(`
+				UPDATE users SET totp_locked_until = NOW() + make_interval(secs => $2) WHERE id = $1
+			`, userID, lockout.Seconds())
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrInvalidTOTPCode
+	}
+
+	if _, err := tx.Exec("UPDATE users SET totp_failures = 0, totp_locked_until = NULL WHERE id = $1", userID); err != nil {
+		return err
+	}
+	if fn != nil {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// IssueMFAToken returns the intermediate token a password or SSO login hands out when a second
+// factor is needed; method ("password" or "sso") is how the first factor was checked
+func (s *TwoFactorService) IssueMFAToken(userID int, method string) string {
+	return utils.SignToken(mfaTokenPurpose, strconv.Itoa(userID)+" "+method, s.now().Add(mfaTokenTTL), s.secret)
+}
+
+// ParseMFAToken returns the user an intermediate token was issued to and their first factor
+func (s *TwoFactorService) ParseMFAToken(token string) (int, string, error) {
+	subject, err := utils.VerifySignedToken(token, mfaTokenPurpose, s.secret, s.now())
+	if err != nil {
+		return 0, "", ErrInvalidMFAToken
+	}
+	id, method, _ := strings.Cut(subject, " ")
+	userID, err := strconv.Atoi(id)
+	if err != nil || method == "" {
+		return 0, "", ErrInvalidMFAToken
+	}
+	return userID, method, nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := utils.GenerateCode(10)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		_, err = tx.Exec(`
+			INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, utils.HashToken(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+)
+
+// Unambiguous characters for codes that people type by hand
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateCode returns a random human-friendly code of length n
+func GenerateCode(n int) (string, error) {
+	code := make([]byte, n)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := range code {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = codeAlphabet[idx.Int64()]
+	}
+	return string(code), nil
+}
+
+// GenerateToken returns n random bytes encoded as URL-safe base64, for opaque secrets
+func GenerateToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
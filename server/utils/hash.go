@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"sync"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,3 +15,14 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("vigileye-dummy-password")
+	return hash
+})
+
+// DummyPasswordCheck takes as long as CheckPasswordHash against a real hash. Logins for unknown
+// accounts call it so response times don't reveal which emails are registered.
+func DummyPasswordCheck(password string) {
+	CheckPasswordHash(password, dummyPasswordHash())
+}
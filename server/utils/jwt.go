@@ -7,31 +7,50 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateJWT(userID int, secret string) (string, error) {
+// AccessClaims identifies the user and the session an access token was issued for
+type AccessClaims struct {
+	UserID    int
+	SessionID string
+}
+
+// GenerateJWT issues a short-lived access token for a session; clients renew it with the
+// session's refresh token
+func GenerateJWT(userID int, sessionID string, ttl time.Duration, secret string) (string, error) {
+	now := time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(),
+		"sid":     sessionID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
 	})
 
 	return token.SignedString([]byte(secret))
 }
 
-func VerifyJWT(tokenString, secret string) (int, error) {
+func VerifyJWT(tokenString, secret string) (*AccessClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
 		return []byte(secret), nil
-	})
+	}, jwt.WithExpirationRequired())
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID := int(claims["user_id"].(float64))
-		return userID, nil
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return nil, errors.New("invalid token")
 	}
 
-	return 0, errors.New("invalid token")
+	return &AccessClaims{UserID: int(userID), SessionID: sessionID}, nil
 }
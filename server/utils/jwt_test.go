@@ -2,13 +2,16 @@ package utils
 
 import (
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestJWT(t *testing.T) {
 	userID := 123
 	secret := "test-secret-at-least-32-chars-long"
 
-	token, err := GenerateJWT(userID, secret)
+	token, err := GenerateJWT(userID, "session-1", 15*time.Minute, secret)
 	if err != nil {
 		t.Fatalf("Failed to generate JWT: %v", err)
 	}
@@ -17,13 +20,16 @@ func TestJWT(t *testing.T) {
 		t.Fatal("Token should not be empty")
 	}
 
-	parsedID, err := VerifyJWT(token, secret)
+	claims, err := VerifyJWT(token, secret)
 	if err != nil {
 		t.Fatalf("Failed to verify JWT: %v", err)
 	}
 
-	if parsedID != userID {
-		t.Errorf("Expected userID %d, got %d", userID, parsedID)
+	if claims.UserID != userID {
+		t.Errorf("Expected userID %d, got %d", userID, claims.UserID)
+	}
+	if claims.SessionID != "session-1" {
+		t.Errorf("Expected session-1, got %q", claims.SessionID)
 	}
 
 	// Test invalid secret
@@ -32,3 +38,29 @@ func TestJWT(t *testing.T) {
 		t.Error("Expected error with wrong secret, got nil")
 	}
 }
+
+func TestJWTExpired(t *testing.T) {
+	secret := "test-secret-at-least-32-chars-long"
+
+	token, _ := GenerateJWT(1, "session-1", -time.Minute, secret)
+	if _, err := VerifyJWT(token, secret); err == nil {
+		t.Error("Expected error for an expired token, got nil")
+	}
+}
+
+func TestJWTRequiresSessionClaims(t *testing.T) {
+	secret := "test-secret-at-least-32-chars-long"
+	exp := time.Now().Add(time.Hour).Unix()
+
+	// Tokens issued before sessions existed carry no sid and must not be accepted
+	for name, claims := range map[string]jwt.MapClaims{
+		"no session": {"user_id": 1, "exp": exp},
+		"no user":    {"sid": "session-1", "exp": exp},
+		"no expiry":  {"user_id": 1, "sid": "session-1"},
+	} {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if _, err := VerifyJWT(token, secret); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Members are mentioned by email, e.g. "@alice@example.com can you look?"
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,})`)
+
+// ParseMentions returns the distinct lowercased emails mentioned in a comment, in order
+func ParseMentions(body string) []string {
+	seen := map[string]bool{}
+	mentions := []string{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		email := strings.ToLower(m[1])
+		if !seen[email] {
+			seen[email] = true
+			mentions = append(mentions, email)
+		}
+	}
+	return mentions
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"no mentions here", []string{}},
+		{"@alice@example.com can you look?", []string{"alice@example.com"}},
+		{"cc @Bob.Smith@corp.example.co.uk, @alice@example.com and @bob.smith@corp.example.co.uk.", []string{"bob.smith@corp.example.co.uk", "alice@example.com"}},
+		{"mail alice@example.com directly", []string{}},
+		{"(@carol@example.com)", []string{"carol@example.com"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseMentions(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMentions(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
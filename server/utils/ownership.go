@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OwnershipRule assigns errors whose stack or request URL matches Pattern to Owners
+type OwnershipRule struct {
+	Pattern string
+	// URL rules (written as "url:/api/payments/**") match the request path instead of stack frames
+	URL    bool
+	Owners []string
+	Line   int
+	re     *regexp.Regexp
+}
+
+// Ownership is a parsed CODEOWNERS-style file. As in CODEOWNERS, the last matching rule wins.
+//
+//	# comment
+//	src/billing/**          alice@example.com bob@example.com
+//	*.py                    data-team@example.com
+//	url:/api/payments/**    payments@example.com
+type Ownership struct {
+	Rules []OwnershipRule
+}
+
+// ParseOwnership parses an ownership file, reporting the first invalid line
+func ParseOwnership(content string) (*Ownership, error) {
+	o := &Ownership{}
+	for i, line := range strings.Split(content, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule := OwnershipRule{Pattern: fields[0], Line: i + 1}
+		if p, ok := strings.CutPrefix(rule.Pattern, "url:"); ok {
+			rule.URL, rule.Pattern = true, p
+		}
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("line %d: empty pattern", i+1)
+		}
+
+		// A rule without owners marks paths as explicitly unowned
+		for _, owner := range fields[1:] {
+			if !strings.Contains(owner, "@") || strings.HasPrefix(owner, "@") {
+				return nil, fmt.Errorf("line %d: owner %q must be a user email", i+1, owner)
+			}
+			rule.Owners = append(rule.Owners, strings.ToLower(owner))
+		}
+
+		re, err := ownershipRegexp(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %w", i+1, rule.Pattern, err)
+		}
+		rule.re = re
+		o.Rules = append(o.Rules, rule)
+	}
+	return o, nil
+}
+
+// Owners returns the owners for an error, checking stack frames top-down before the request URL
+func (o *Ownership) Owners(requestURL string, stackPaths []string) []string {
+	for _, path := range stackPaths {
+		if rule := o.match(normalizeOwnershipPath(path), false); rule != nil {
+			return rule.Owners
+		}
+	}
+
+	if requestURL != "" {
+		if rule := o.match(urlPath(requestURL), true); rule != nil {
+			return rule.Owners
+		}
+	}
+	return nil
+}
+
+// AllOwners lists every owner mentioned in the file
+func (o *Ownership) AllOwners() []string {
+	seen := map[string]bool{}
+	owners := []string{}
+	for _, rule := range o.Rules {
+		for _, owner := range rule.Owners {
+			if !seen[owner] {
+				seen[owner] = true
+				owners = append(owners, owner)
+			}
+		}
+	}
+	return owners
+}
+
+func (o *Ownership) match(path string, isURL bool) *OwnershipRule {
+	for i := len(o.Rules) - 1; i >= 0; i-- {
+		rule := &o.Rules[i]
+		if rule.URL == isURL && rule.re.MatchString(path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// ownershipRegexp compiles a gitignore-style pattern: "*" stays within a path segment, "**" spans
+// segments, a leading "/" anchors to the root and a match on a directory covers everything below it
+func ownershipRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if strings.HasPrefix(pattern, "/") {
+		b.WriteString("^/?")
+	} else {
+		b.WriteString("(^|/)")
+	}
+
+	p := strings.Trim(pattern, "/")
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				if i+2 < len(p) && p[i+2] == '/' {
+					// "**/" matches zero or more directories
+					b.WriteString("(.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("(/.*)?$")
+
+	return regexp.Compile(b.String())
+}
+
+var stackPathPattern = regexp.MustCompile(`File "([^"]+)"|([^\s()"'\[\]]+\.[A-Za-z0-9]+):\d+`)
+
+// StackPaths extracts source file paths from a stack trace, top frame first
+func StackPaths(stack string) []string {
+	paths := []string{}
+	for _, m := range stackPathPattern.FindAllStringSubmatch(stack, -1) {
+		path := m[1]
+		if path == "" {
+			path = m[2]
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// normalizeOwnershipPath turns bundler and browser URLs into repository-style paths
+func normalizeOwnershipPath(path string) string {
+	path = strings.ReplaceAll(path, "\\", "/")
+	if strings.Contains(path, "://") {
+		if u, err := url.Parse(path); err == nil {
+			path = u.Path
+		}
+	}
+	path = strings.TrimPrefix(path, "./")
+	for strings.HasPrefix(path, "/./") {
+		path = "/" + strings.TrimPrefix(path, "/./")
+	}
+	return path
+}
+
+func urlPath(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Path == "" {
+		return raw
+	}
+	return u.Path
+}
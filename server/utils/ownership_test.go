@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testOwnershipFile = `
+# Default owners for the frontend
+src/**                     web@example.com
+src/billing/               Alice@example.com bob@example.com
+*.py                       data@example.com
+/app/jobs/*.go             jobs@example.com
+src/vendor/                # explicitly unowned
+
+url:/api/payments/**       payments@example.com
+url:/api/*/export          exports@example.com
+`
+
+func TestParseOwnership(t *testing.T) {
+	o, err := ParseOwnership(testOwnershipFile)
+	if err != nil {
+		t.Fatalf("ParseOwnership() error = %v", err)
+	}
+	if len(o.Rules) != 7 {
+		t.Fatalf("Expected 7 rules, got %d", len(o.Rules))
+	}
+	if !o.Rules[5].URL || o.Rules[5].Pattern != "/api/payments/**" {
+		t.Errorf("Expected url rule, got %+v", o.Rules[5])
+	}
+	if got := o.Rules[1].Owners; !reflect.DeepEqual(got, []string{"alice@example.com", "bob@example.com"}) {
+		t.Errorf("Expected lowercased owners, got %v", got)
+	}
+
+	want := []string{"web@example.com", "alice@example.com", "bob@example.com", "data@example.com", "jobs@example.com", "payments@example.com", "exports@example.com"}
+	if got := o.AllOwners(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AllOwners() = %v, want %v", got, want)
+	}
+}
+
+func TestParseOwnershipErrors(t *testing.T) {
+	for _, content := range []string{
+		"src/** @alice",
+		"src/** alice",
+		"url: alice@example.com",
+	} {
+		if _, err := ParseOwnership(content); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
+
+func TestOwnershipOwners(t *testing.T) {
+	o, err := ParseOwnership(testOwnershipFile)
+	if err != nil {
+		t.Fatalf("ParseOwnership() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		url   string
+		paths []string
+		want  []string
+	}{
+		{"last matching rule wins", "", []string{"src/billing/invoice.ts"}, []string{"alice@example.com", "bob@example.com"}},
+		{"glob across directories", "", []string{"src/components/Button.tsx"}, []string{"web@example.com"}},
+		{"unanchored extension", "", []string{"/srv/app/models/user.py"}, []string{"data@example.com"}},
+		{"anchored pattern", "", []string{"/app/jobs/cleanup.go"}, []string{"jobs@example.com"}},
+		{"anchored pattern does not match nested dirs", "", []string{"/app/jobs/sub/cleanup.go"}, nil},
+		{"explicitly unowned", "", []string{"src/vendor/lodash.js"}, nil},
+		{"top frame first", "", []string{"lib/unknown.js", "src/billing/pay.ts", "src/app.ts"}, []string{"alice@example.com", "bob@example.com"}},
+		{"webpack path", "", []string{"webpack:///./src/index.js"}, []string{"web@example.com"}},
+		{"browser bundle url", "", []string{"https://cdn.example.com/src/main.js"}, []string{"web@example.com"}},
+		{"url fallback", "https://shop.example.com/api/payments/charge?id=1", []string{"node_modules/express/router.js"}, []string{"payments@example.com"}},
+		{"url single segment glob", "/api/orders/export", nil, []string{"exports@example.com"}},
+		{"no match", "/health", []string{"main.rs"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := o.Owners(tt.url, tt.paths); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Owners() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStackPaths(t *testing.T) {
+	stack := `TypeError: x is undefined
+    at getUser (src/api/users.js:42:17)
+    at async https://cdn.example.com/static/main.3f2a.js:1:2041
+  File "/srv/app/views.py", line 12, in get_user
+main.handler(0xc000010000)
+	/app/jobs/cleanup.go:88 +0x1d
+	at com.example.Foo.bar(Foo.java:12)`
+
+	want := []string{
+		"src/api/users.js",
+		"https://cdn.example.com/static/main.3f2a.js",
+		"/srv/app/views.py",
+		"/app/jobs/cleanup.go",
+		"Foo.java",
+	}
+	if got := StackPaths(stack); !reflect.DeepEqual(got, want) {
+		t.Errorf("StackPaths() = %v, want %v", got, want)
+	}
+}
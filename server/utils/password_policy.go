@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything after 72 bytes, so longer passwords would silently be truncated
+const maxPasswordBytes = 72
+
+// A few of the most common passwords that meet any length rule
+var commonPasswords = map[string]bool{
+	"password":      true,
+	"password1":     true,
+	"password123":   true,
+	"12345678":      true,
+	"123456789":     true,
+	"1234567890":    true,
+	"qwertyuiop":    true,
+	"qwerty123":     true,
+	"iloveyou":      true,
+	"sunshine":      true,
+	"princess":      true,
+	"football":      true,
+	"baseball":      true,
+	"welcome1":      true,
+	"letmein1":      true,
+	"trustno1":      true,
+	"abcd1234":      true,
+	"11111111":      true,
+	"00000000":      true,
+	"passw0rd":      true,
+	"administrator": true,
+}
+
+// PasswordPolicy describes which new passwords are accepted
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols must appear
+	MinClasses int
+}
+
+// Validate returns a message suitable for the user when password doesn't meet the policy. The
+// email is used to reject passwords built from the account's own address.
+func (p PasswordPolicy) Validate(password, email string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)
+	}
+
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] {
+		return fmt.Errorf("password is too common")
+	}
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok && len(local) >= 4 && strings.Contains(lowered, local) {
+		return fmt.Errorf("password must not contain your email address")
+	}
+	return nil
+}
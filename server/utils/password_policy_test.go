@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, MinClasses: 3}
+
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"meets policy", "Correct-horse9", true},
+		{"too short", "Ab1-", false},
+		{"too few classes", "alllowercaseletters", false},
+		{"two classes", "lowercase123456", false},
+		{"too long for bcrypt", "Aa1-" + strings.Repeat("x", 70), false},
+		{"common", "Password123", false},
+		{"contains email", "Jane.Doe-2024x", false},
+		{"multibyte counts characters", "Ünïcödé-Pässwörd1", true},
+	}
+	for _, tt := range tests {
+		err := policy.Validate(tt.password, "jane.doe@example.com")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate(%q) = %v, want ok=%v", tt.name, tt.password, err, tt.ok)
+		}
+	}
+}
+
+func TestPasswordPolicyDefaults(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinClasses: 1}
+	if err := policy.Validate("longenoughpassphrase", "dev@example.com"); err != nil {
+		t.Errorf("expected a long single-class password to pass, got %v", err)
+	}
+	if err := policy.Validate("short", "dev@example.com"); err == nil {
+		t.Error("expected a short password to fail")
+	}
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces secret values in audit records
+const Redacted = "[REDACTED]"
+
+var secretKeyParts = []string{"password", "secret", "token", "api_key", "apikey", "authorization", "recovery_code"}
+
+// IsSecretKey reports whether a JSON field name holds a credential
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToJSONValue converts v to the generic form encoding/json decodes into (maps, slices, float64,
+// string, bool, nil), so values of different Go types can be compared
+func ToJSONValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// RedactSecrets returns a copy of a generic JSON value with the values of secret fields replaced
+func RedactSecrets(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			if IsSecretKey(k) && child != nil && child != "" {
+				out[k] = Redacted
+			} else {
+				out[k] = RedactSecrets(child)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, child := range val {
+			out[i] = RedactSecrets(child)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// FieldChange is one changed field in a JSONDiff
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// JSONDiff compares two values field by field and returns the changes keyed by dotted path.
+// Objects are compared recursively; arrays and scalars are compared whole. Secret fields show
+// that they changed, but not their values.
+func JSONDiff(before, after interface{}) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	diffValues("", ToJSONValue(before), ToJSONValue(after), false, changes)
+	return changes
+}
+
+func diffValues(path string, before, after interface{}, secret bool, changes map[string]FieldChange) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap || (before == nil && afterIsMap) || (beforeIsMap && after == nil) {
+		keys := map[string]bool{}
+		for k := range beforeMap {
+			keys[k] = true
+		}
+		for k := range afterMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			diffValues(childPath, beforeMap[k], afterMap[k], secret || IsSecretKey(k), changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	if secret {
+		change := FieldChange{}
+		if before != nil && before != "" {
+			change.From = Redacted
+		}
+		if after != nil && after != "" {
+			change.To = Redacted
+		}
+		changes[path] = change
+		return
+	}
+	changes[path] = FieldChange{From: RedactSecrets(before), To: RedactSecrets(after)}
+}
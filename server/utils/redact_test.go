@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	in := ToJSONValue(map[string]interface{}{
+		"name":     "production",
+		"api_key":  "3f2c",
+		"password": "",
+		"notifications": map[string]interface{}{
+			"telegram": map[string]interface{}{"bot_token": "123:abc", "chat_ids": []string{"1"}},
+		},
+	})
+	want := map[string]interface{}{
+		"name":     "production",
+		"api_key":  Redacted,
+		"password": "",
+		"notifications": map[string]interface{}{
+			"telegram": map[string]interface{}{"bot_token": Redacted, "chat_ids": []interface{}{"1"}},
+		},
+	}
+	if got := RedactSecrets(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactSecrets = %#v, want %#v", got, want)
+	}
+}
+
+func TestJSONDiff(t *testing.T) {
+	type settings struct {
+		Enabled  bool   `json:"enabled"`
+		BotToken string `json:"bot_token"`
+	}
+	type env struct {
+		Name     string   `json:"name"`
+		APIKey   string   `json:"api_key"`
+		Settings settings `json:"settings"`
+	}
+
+	before := env{Name: "staging", APIKey: "old", Settings: settings{Enabled: false, BotToken: "t1"}}
+	after := env{Name: "staging", APIKey: "new", Settings: settings{Enabled: true, BotToken: "t2"}}
+
+	want := map[string]FieldChange{
+		"api_key":            {From: Redacted, To: Redacted},
+		"settings.bot_token": {From: Redacted, To: Redacted},
+		"settings.enabled":   {From: false, To: true},
+	}
+	if got := JSONDiff(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("JSONDiff = %#v, want %#v", got, want)
+	}
+
+	if got := JSONDiff(before, before); len(got) != 0 {
+		t.Errorf("expected no changes, got %#v", got)
+	}
+
+	created := JSONDiff(nil, map[string]string{"role": "viewer"})
+	if !reflect.DeepEqual(created, map[string]FieldChange{"role": {From: nil, To: "viewer"}}) {
+		t.Errorf("unexpected diff for a created value: %#v", created)
+	}
+}
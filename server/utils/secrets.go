@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const encryptedPrefix = "enc:v1:"
+
+// SecretBox encrypts small secrets (bot tokens, webhook URLs) with AES-256-GCM.
+// Values are tagged with the ID of the key that encrypted them so the primary
+// key can be rotated while older values stay readable.
+type SecretBox struct {
+	primaryID string
+	keys      map[string]cipher.AEAD
+}
+
+func NewSecretBox(primary string, previous []string) (*SecretBox, error) {
+	if primary == "" {
+		return nil, errors.New("secret key is required")
+	}
+
+	box := &SecretBox{keys: make(map[string]cipher.AEAD)}
+	for i, secret := range append([]string{primary}, previous...) {
+		if secret == "" {
+			continue
+		}
+		id, aead, err := deriveKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			box.primaryID = id
+		}
+		box.keys[id] = aead
+	}
+
+	return box, nil
+}
+
+func deriveKey(secret string) (string, cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	idSum := sha256.Sum256([]byte("vigileye-key-id:" + secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(idSum[:4]), aead, nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+func (b *SecretBox) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	aead := b.keys[b.primaryID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(b.primaryID))
+	return encryptedPrefix + b.primaryID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of an encrypted value. Values that were never
+// encrypted (legacy plaintext) are returned unchanged.
+func (b *SecretBox) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	aead, ok := b.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key %s", parts[0])
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(parts[0]))
+	if err != nil {
+		return "", errors.New("failed to decrypt value")
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether value is plaintext or encrypted with a non-primary key
+func (b *SecretBox) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, encryptedPrefix+b.primaryID+":")
+}
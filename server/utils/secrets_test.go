@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox("primary-secret-key", nil)
+	if err != nil {
+		t.Fatalf("Failed to create secret box: %v", err)
+	}
+
+	encrypted, err := box.Encrypt("123456:ABC-bot-token")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	if !IsEncrypted(encrypted) {
+		t.Errorf("Expected encrypted value, got %s", encrypted)
+	}
+
+	decrypted, err := box.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if decrypted != "123456:ABC-bot-token" {
+		t.Errorf("Expected original plaintext, got %s", decrypted)
+	}
+
+	// Legacy plaintext passes through
+	plain, err := box.Decrypt("legacy-token")
+	if err != nil || plain != "legacy-token" {
+		t.Errorf("Expected plaintext passthrough, got %q (%v)", plain, err)
+	}
+	if !box.NeedsRotation("legacy-token") {
+		t.Error("Plaintext values should need rotation")
+	}
+	if box.NeedsRotation(encrypted) {
+		t.Error("Values encrypted with the primary key should not need rotation")
+	}
+}
+
+func TestSecretBoxRotation(t *testing.T) {
+	oldBox, _ := NewSecretBox("old-secret-key", nil)
+	encrypted, _ := oldBox.Encrypt("bot-token")
+
+	newBox, _ := NewSecretBox("new-secret-key", []string{"old-secret-key"})
+	if !newBox.NeedsRotation(encrypted) {
+		t.Error("Values encrypted with a previous key should need rotation")
+	}
+
+	decrypted, err := newBox.Decrypt(encrypted)
+	if err != nil || decrypted != "bot-token" {
+		t.Fatalf("Expected previous key to decrypt, got %q (%v)", decrypted, err)
+	}
+
+	// Without the previous key the value is unreadable
+	strangerBox, _ := NewSecretBox("new-secret-key", nil)
+	if _, err := strangerBox.Decrypt(encrypted); err == nil {
+		t.Error("Expected error decrypting with unknown key")
+	}
+}
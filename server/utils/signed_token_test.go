@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedToken(t *testing.T) {
+	secret := "test-secret-at-least-32-chars-long"
+	now := time.Unix(1700000000, 0)
+	token := SignToken("invite", "42", now.Add(time.Hour), secret)
+
+	subject, err := VerifySignedToken(token, "invite", secret, now)
+	if err != nil || subject != "42" {
+		t.Fatalf("expected subject 42, got %q (%v)", subject, err)
+	}
+
+	if _, err := VerifySignedToken(token, "invite", secret, now.Add(time.Hour)); err != ErrTokenExpired {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+	if _, err := VerifySignedToken(token, "password-reset", secret, now); err != ErrTokenInvalid {
+		t.Errorf("expected a token for another purpose to be rejected, got %v", err)
+	}
+	if _, err := VerifySignedToken(token, "invite", "another-secret", now); err != ErrTokenInvalid {
+		t.Errorf("expected a token signed with another secret to be rejected, got %v", err)
+	}
+
+	// Changing the subject or expiry invalidates the signature
+	parts := strings.Split(token, ".")
+	forged := SignToken("invite", "43", now.Add(time.Hour), "x")
+	forgedParts := strings.Split(forged, ".")
+	if _, err := VerifySignedToken(forgedParts[0]+"."+parts[1]+"."+parts[2], "invite", secret, now); err != ErrTokenInvalid {
+		t.Errorf("expected a forged subject to be rejected, got %v", err)
+	}
+	if _, err := VerifySignedToken(parts[0]+".9999999999."+parts[2], "invite", secret, now); err != ErrTokenInvalid {
+		t.Errorf("expected a forged expiry to be rejected, got %v", err)
+	}
+	if _, err := VerifySignedToken("garbage", "invite", secret, now); err != ErrTokenInvalid {
+		t.Errorf("expected garbage to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 4226 appendix D
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp(key, uint64(counter), 6); got != code {
+			t.Errorf("hotp(counter=%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238 appendix B, SHA1 column
+func TestTOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		counter := TOTPCounter(time.Unix(tt.unix, 0))
+		if got := hotp(key, uint64(counter), 8); got != tt.code {
+			t.Errorf("totp(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+
+	// The same key through the public API, which uses 6 digits
+	secret := totpEncoding.EncodeToString(key)
+	code, err := TOTPCode(secret, time.Unix(59, 0))
+	if err != nil || code != "287082" {
+		t.Errorf("TOTPCode = %q, %v; want 287082", code, err)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := TOTPCode(secret, now)
+
+	counter, ok := ValidateTOTP(secret, code, now)
+	if !ok || counter != TOTPCounter(now) {
+		t.Fatalf("expected the current code to validate at step %d, got %d %v", TOTPCounter(now), counter, ok)
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(TOTPPeriod)); !ok {
+		t.Error("expected the previous step's code to be accepted for clock drift")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(3*TOTPPeriod)); ok {
+		t.Error("expected an old code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("expected spaces in the code to be ignored")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("expected a short code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("JBSWY3DPEHPK3PXP", "dev@example.com", "Vigil Eye")
+	for _, want := range []string{"otpauth://totp/Vigil%20Eye:dev@example.com?", "secret=JBSWY3DPEHPK3PXP", "issuer=Vigil+Eye", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("expected %q in %s", want, uri)
+		}
+	}
+}
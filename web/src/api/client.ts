@@ -1,22 +1,62 @@
 import axios from 'axios';
+import type { AxiosError, InternalAxiosRequestConfig } from 'axios';
+
+const baseURL = import.meta.env.VITE_API_URL || 'http://localhost:4000';
 
 const client = axios.create({
-    baseURL: import.meta.env.VITE_API_URL || 'http://localhost:4000',
+    baseURL,
     withCredentials: true,
     headers: {
         'Content-Type': 'application/json',
     },
 });
 
+type RetriableRequest = InternalAxiosRequestConfig & { _retried?: boolean };
+
+// Requests that fail together share one refresh, so the refresh token is only rotated once
+let refreshing: Promise<void> | null = null;
+
+function refreshSession(): Promise<void> {
+    if (!refreshing) {
+        // Sent without the interceptor so that a failed refresh isn't itself retried
+        refreshing = axios
+            .post('/api/auth/refresh', undefined, { baseURL, withCredentials: true })
+            .then(() => undefined)
+            .finally(() => {
+                refreshing = null;
+            });
+    }
+    return refreshing;
+}
+
+function redirectToLogin() {
+    // Avoid infinite loop if we're already on login page
+    if (!window.location.pathname.includes('/login') && !window.location.pathname.includes('/register')) {
+        window.location.href = '/login';
+    }
+}
+
 client.interceptors.response.use(
     (response) => response,
-    (error) => {
-        if (error.response?.status === 401) {
-            // Avoid infinite loop if we're already on login page
-            if (!window.location.pathname.includes('/login') && !window.location.pathname.includes('/register')) {
-                window.location.href = '/login';
+    async (error: AxiosError) => {
+        const request = error.config as RetriableRequest | undefined;
+        if (error.response?.status !== 401 || !request) {
+            return Promise.reject(error);
+        }
+
+        // Access tokens are short-lived: renew once and replay the request before giving up
+        if (!request._retried) {
+            request._retried = true;
+            try {
+                await refreshSession();
+            } catch {
+                redirectToLogin();
+                return Promise.reject(error);
             }
+            return client(request);
         }
+
+        redirectToLogin();
         return Promise.reject(error);
     }
 );
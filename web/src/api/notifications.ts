@@ -23,9 +23,7 @@ export async function updateNotificationSettings(
     envId: number,
     settings: NotificationSettings
 ): Promise<void> {
-    await client.patch(`/api/projects/${projectId}/environments/${envId}`, {
-        settings: { notifications: settings }
-    });
+    await client.put(`/api/projects/${projectId}/environments/${envId}/notifications`, settings);
 }
 
 export async function testTelegramNotification(